@@ -182,10 +182,34 @@ func (r *RegValue) UnmarshalJSON(b []byte) error {
 }
 
 // URL configuration
+// Every non-zero field overrides the related default_cache value for the
+// requests matching the url regex.
 type URL struct {
-	TTL                 Duration `json:"ttl" yaml:"ttl"`
-	Headers             []string `json:"headers" yaml:"headers"`
-	DefaultCacheControl string   `json:"default_cache_control" yaml:"default_cache_control"`
+	TTL                    Duration                `json:"ttl" yaml:"ttl"`
+	Stale                  Duration                `json:"stale" yaml:"stale"`
+	Headers                []string                `json:"headers" yaml:"headers"`
+	DefaultCacheControl    string                  `json:"default_cache_control" yaml:"default_cache_control"`
+	Mode                   string                  `json:"mode" yaml:"mode"`
+	Storers                []string                `json:"storers" yaml:"storers"`
+	Timeout                Timeout                 `json:"timeout" yaml:"timeout"`
+	MaxBodyBytes           uint64                  `json:"max_cacheable_body_bytes" yaml:"max_cacheable_body_bytes"`
+	AllowedHTTPVerbs       []string                `json:"allowed_http_verbs" yaml:"allowed_http_verbs"`
+	Key                    *Key                    `json:"key,omitempty" yaml:"key,omitempty"`
+	DownstreamCacheControl *DownstreamCacheControl `json:"downstream_cache_control,omitempty" yaml:"downstream_cache_control,omitempty"`
+	ServeStaleOnTimeout    *bool                   `json:"serve_stale_on_timeout,omitempty" yaml:"serve_stale_on_timeout,omitempty"`
+}
+
+// DownstreamCacheControl rewrites the Cache-Control sent to the clients
+// without changing the stored policy. Value replaces the whole header, the
+// durations cap the related directives and Visibility replaces public/private.
+type DownstreamCacheControl struct {
+	Value                string   `json:"value,omitempty" yaml:"value,omitempty"`
+	MaxAge               Duration `json:"max_age,omitempty" yaml:"max_age,omitempty"`
+	SMaxAge              Duration `json:"s_maxage,omitempty" yaml:"s_maxage,omitempty"`
+	StaleWhileRevalidate Duration `json:"stale_while_revalidate,omitempty" yaml:"stale_while_revalidate,omitempty"`
+	StaleIfError         Duration `json:"stale_if_error,omitempty" yaml:"stale_if_error,omitempty"`
+	Visibility           string   `json:"visibility,omitempty" yaml:"visibility,omitempty"`
+	CDNCacheControl      string   `json:"cdn_cache_control,omitempty" yaml:"cdn_cache_control,omitempty"`
 }
 
 // CacheProvider config
@@ -231,15 +255,151 @@ type Timeout struct {
 
 // CDN config
 type CDN struct {
-	APIKey    string `json:"api_key,omitempty" yaml:"api_key,omitempty"`
-	Dynamic   bool   `json:"dynamic,omitempty" yaml:"dynamic,omitempty"`
-	Email     string `json:"email,omitempty" yaml:"email,omitempty"`
-	Hostname  string `json:"hostname,omitempty" yaml:"hostname,omitempty"`
-	Network   string `json:"network,omitempty" yaml:"network,omitempty"`
-	Provider  string `json:"provider,omitempty" yaml:"provider,omitempty"`
-	Strategy  string `json:"strategy,omitempty" yaml:"strategy,omitempty"`
-	ServiceID string `json:"service_id,omitempty" yaml:"service_id,omitempty"`
-	ZoneID    string `json:"zone_id,omitempty" yaml:"zone_id,omitempty"`
+	APIKey          string     `json:"api_key,omitempty" yaml:"api_key,omitempty"`
+	AccessKeyID     string     `json:"access_key_id,omitempty" yaml:"access_key_id,omitempty"`
+	AccessToken     string     `json:"access_token,omitempty" yaml:"access_token,omitempty"`
+	BaseURL         string     `json:"base_url,omitempty" yaml:"base_url,omitempty"`
+	ClientSecret    string     `json:"client_secret,omitempty" yaml:"client_secret,omitempty"`
+	ClientToken     string     `json:"client_token,omitempty" yaml:"client_token,omitempty"`
+	CredentialsFile string     `json:"credentials_file,omitempty" yaml:"credentials_file,omitempty"`
+	DistributionID  string     `json:"distribution_id,omitempty" yaml:"distribution_id,omitempty"`
+	Dynamic         bool       `json:"dynamic,omitempty" yaml:"dynamic,omitempty"`
+	Email           string     `json:"email,omitempty" yaml:"email,omitempty"`
+	Endpoint        string     `json:"endpoint,omitempty" yaml:"endpoint,omitempty"`
+	Hostname        string     `json:"hostname,omitempty" yaml:"hostname,omitempty"`
+	Network         string     `json:"network,omitempty" yaml:"network,omitempty"`
+	Profile         string     `json:"profile,omitempty" yaml:"profile,omitempty"`
+	ProjectID       string     `json:"project_id,omitempty" yaml:"project_id,omitempty"`
+	Provider        string     `json:"provider,omitempty" yaml:"provider,omitempty"`
+	PullZoneID      string     `json:"pull_zone_id,omitempty" yaml:"pull_zone_id,omitempty"`
+	Purge           CDNPurge   `json:"purge,omitempty" yaml:"purge,omitempty"`
+	ResourceGroup   string     `json:"resource_group,omitempty" yaml:"resource_group,omitempty"`
+	SecretAccessKey string     `json:"secret_access_key,omitempty" yaml:"secret_access_key,omitempty"`
+	SessionToken    string     `json:"session_token,omitempty" yaml:"session_token,omitempty"`
+	Strategy        string     `json:"strategy,omitempty" yaml:"strategy,omitempty"`
+	ServiceID       string     `json:"service_id,omitempty" yaml:"service_id,omitempty"`
+	SubscriptionID  string     `json:"subscription_id,omitempty" yaml:"subscription_id,omitempty"`
+	URLMap          string     `json:"url_map,omitempty" yaml:"url_map,omitempty"`
+	Webhook         CDNWebhook `json:"webhook,omitempty" yaml:"webhook,omitempty"`
+	ZoneID          string     `json:"zone_id,omitempty" yaml:"zone_id,omitempty"`
+}
+
+// CDNPurge configures the delivery of the purge requests to the CDN
+// provider. The failed requests are retried with an exponential backoff
+// starting at Backoff until MaxAttempts, and RateLimit bounds the requests
+// sent per second.
+type CDNPurge struct {
+	MaxAttempts int      `json:"max_attempts,omitempty" yaml:"max_attempts,omitempty"`
+	Backoff     Duration `json:"backoff,omitempty" yaml:"backoff,omitempty"`
+	RateLimit   float64  `json:"rate_limit,omitempty" yaml:"rate_limit,omitempty"`
+	Timeout     Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+// CDNWebhook configures the purge requests sent by the webhook and varnish
+// providers. The Endpoints and the Body are rendered as text/template with
+// the purged Tags, URIs and Keys, the Headers are added as is when sending.
+type CDNWebhook struct {
+	Endpoints []string          `json:"endpoints,omitempty" yaml:"endpoints,omitempty"`
+	Method    string            `json:"method,omitempty" yaml:"method,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Body      string            `json:"body,omitempty" yaml:"body,omitempty"`
+}
+
+// GeneratedETag configures the validator computed from the body of the
+// stored responses without ETag nor Last-Modified.
+type GeneratedETag struct {
+	Enable         bool     `json:"enable" yaml:"enable"`
+	Weak           bool     `json:"weak,omitempty" yaml:"weak,omitempty"`
+	ExcludeHeaders []string `json:"exclude_headers,omitempty" yaml:"exclude_headers,omitempty"`
+}
+
+// Coalescing configures how long the concurrent requests sharing the same
+// key wait for the leader upstream response. Once the wait is exceeded, a
+// follower gets the stale response when ServeStale is enabled and one is
+// available, otherwise it requests the upstream itself.
+type Coalescing struct {
+	Wait       Duration `json:"wait,omitempty" yaml:"wait,omitempty"`
+	ServeStale bool     `json:"serve_stale,omitempty" yaml:"serve_stale,omitempty"`
+}
+
+// Revalidation configures the worker pool running the background
+// revalidations of the stale-while-revalidate responses. A key is revalidated
+// at most once at a time and not again during the window after it completes.
+type Revalidation struct {
+	Workers   int      `json:"workers,omitempty" yaml:"workers,omitempty"`
+	QueueSize int      `json:"queue_size,omitempty" yaml:"queue_size,omitempty"`
+	Window    Duration `json:"window,omitempty" yaml:"window,omitempty"`
+}
+
+// EarlyExpiration configures the probabilistic early expiration (XFetch).
+// Before a fresh response expires, a request triggers its refresh in
+// background with a probability increasing as the expiry approaches, scaled
+// by the measured upstream latency and Beta (1 by default, higher values
+// refresh earlier).
+type EarlyExpiration struct {
+	Enable bool    `json:"enable" yaml:"enable"`
+	Beta   float64 `json:"beta,omitempty" yaml:"beta,omitempty"`
+}
+
+// OriginLimit bounds the concurrent upstream requests, globally and per
+// host. The requests exceeding the limits wait in a bounded queue (1000 by
+// default, none when negative) where the client requests go ahead of the
+// background ones, up to the queue timeout. On overflow, the stale response
+// is served when available, otherwise a 503 with Retry-After.
+type OriginLimit struct {
+	MaxConcurrent        int      `json:"max_concurrent,omitempty" yaml:"max_concurrent,omitempty"`
+	MaxConcurrentPerHost int      `json:"max_concurrent_per_host,omitempty" yaml:"max_concurrent_per_host,omitempty"`
+	QueueSize            int      `json:"queue_size,omitempty" yaml:"queue_size,omitempty"`
+	QueueTimeout         Duration `json:"queue_timeout,omitempty" yaml:"queue_timeout,omitempty"`
+	RetryAfter           Duration `json:"retry_after,omitempty" yaml:"retry_after,omitempty"`
+}
+
+// ForcedRefresh authorizes the requests carrying the Souin-Refresh header
+// (or souin_refresh query parameter) to skip the cache lookup and store the
+// fresh upstream response. The value must be a token signed with Secret or
+// the client must match one of the AllowedIPs (IPs or CIDRs). PurgeVariants
+// removes the other variants of the resource before the refresh.
+type ForcedRefresh struct {
+	Secret        string   `json:"secret,omitempty" yaml:"secret,omitempty"`
+	AllowedIPs    []string `json:"allowed_ips,omitempty" yaml:"allowed_ips,omitempty"`
+	PurgeVariants bool     `json:"purge_variants,omitempty" yaml:"purge_variants,omitempty"`
+}
+
+// InvalidationBus propagates the purges, deletions and flushes to the other
+// instances using local storers. Transport is redis, nats or http. URL is
+// the redis or nats server, Channel the subject shared by the instances and
+// Peers the bus endpoints of the other instances for the http transport.
+// Secret signs the events sent over http.
+type InvalidationBus struct {
+	Transport string   `json:"transport,omitempty" yaml:"transport,omitempty"`
+	URL       string   `json:"url,omitempty" yaml:"url,omitempty"`
+	Channel   string   `json:"channel,omitempty" yaml:"channel,omitempty"`
+	Peers     []string `json:"peers,omitempty" yaml:"peers,omitempty"`
+	Secret    string   `json:"secret,omitempty" yaml:"secret,omitempty"`
+}
+
+// HeuristicFreshness configures the lifetime computed from the Last-Modified
+// header for the responses without explicit freshness (RFC 9111 section 4.2.2).
+type HeuristicFreshness struct {
+	Enable   bool     `json:"enable" yaml:"enable"`
+	Fraction float64  `json:"fraction,omitempty" yaml:"fraction,omitempty"`
+	Min      Duration `json:"min,omitempty" yaml:"min,omitempty"`
+	Max      Duration `json:"max,omitempty" yaml:"max,omitempty"`
+}
+
+// Rule is a cache rule applied to the requests and responses matching its
+// CEL condition. The condition can reference the request (method, host, path,
+// query, headers, cookies) and the response (status, content_type, headers).
+type Rule struct {
+	Name          string   `json:"name" yaml:"name"`
+	Condition     string   `json:"condition" yaml:"condition"`
+	Bypass        bool     `json:"bypass,omitempty" yaml:"bypass,omitempty"`
+	TTL           Duration `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+	Stale         Duration `json:"stale,omitempty" yaml:"stale,omitempty"`
+	KeyComponents []string `json:"key_components,omitempty" yaml:"key_components,omitempty"`
+	SurrogateKeys []string `json:"surrogate_keys,omitempty" yaml:"surrogate_keys,omitempty"`
+	Storers       []string `json:"storers,omitempty" yaml:"storers,omitempty"`
+	StripHeaders  []string `json:"strip_headers,omitempty" yaml:"strip_headers,omitempty"`
 }
 
 type Key struct {
@@ -258,32 +418,43 @@ type Key struct {
 
 // DefaultCache configuration
 type DefaultCache struct {
-	AllowedHTTPVerbs             []string      `json:"allowed_http_verbs" yaml:"allowed_http_verbs"`
-	AllowedAdditionalStatusCodes []int         `json:"allowed_additional_status_codes" yaml:"allowed_additional_status_codes"`
-	Badger                       CacheProvider `json:"badger" yaml:"badger"`
-	CDN                          CDN           `json:"cdn" yaml:"cdn"`
-	CacheName                    string        `json:"cache_name" yaml:"cache_name"`
-	Distributed                  bool          `json:"distributed" yaml:"distributed"`
-	Headers                      []string      `json:"headers" yaml:"headers"`
-	Key                          Key           `json:"key" yaml:"key"`
-	Etcd                         CacheProvider `json:"etcd" yaml:"etcd"`
-	Mode                         string        `json:"mode" yaml:"mode"`
-	Nats                         CacheProvider `json:"nats" yaml:"nats"`
-	Nuts                         CacheProvider `json:"nuts" yaml:"nuts"`
-	Olric                        CacheProvider `json:"olric" yaml:"olric"`
-	Otter                        CacheProvider `json:"otter" yaml:"otter"`
-	Redis                        CacheProvider `json:"redis" yaml:"redis"`
-	Port                         Port          `json:"port" yaml:"port"`
-	Regex                        Regex         `json:"regex" yaml:"regex"`
-	SimpleFS                     CacheProvider `json:"simplefs" yaml:"simplefs"`
-	Stale                        Duration      `json:"stale" yaml:"stale"`
-	Storers                      []string      `json:"storers" yaml:"storers"`
-	Timeout                      Timeout       `json:"timeout" yaml:"timeout"`
-	TTL                          Duration      `json:"ttl" yaml:"ttl"`
-	DefaultCacheControl          string        `json:"default_cache_control" yaml:"default_cache_control"`
-	MaxBodyBytes                 uint64        `json:"max_cacheable_body_bytes" yaml:"max_cacheable_body_bytes"`
-	DisableCoalescing            bool          `json:"disable_coalescing" yaml:"disable_coalescing"`
-	MappingEvictionInterval      Duration      `json:"mapping_eviction_interval" yaml:"mapping_eviction_interval"`
+	AllowedHTTPVerbs             []string           `json:"allowed_http_verbs" yaml:"allowed_http_verbs"`
+	AllowedAdditionalStatusCodes []int              `json:"allowed_additional_status_codes" yaml:"allowed_additional_status_codes"`
+	Badger                       CacheProvider      `json:"badger" yaml:"badger"`
+	CDN                          CDN                `json:"cdn" yaml:"cdn"`
+	CacheName                    string             `json:"cache_name" yaml:"cache_name"`
+	Distributed                  bool               `json:"distributed" yaml:"distributed"`
+	Headers                      []string           `json:"headers" yaml:"headers"`
+	Key                          Key                `json:"key" yaml:"key"`
+	Etcd                         CacheProvider      `json:"etcd" yaml:"etcd"`
+	Mode                         string             `json:"mode" yaml:"mode"`
+	Nats                         CacheProvider      `json:"nats" yaml:"nats"`
+	Nuts                         CacheProvider      `json:"nuts" yaml:"nuts"`
+	Olric                        CacheProvider      `json:"olric" yaml:"olric"`
+	Otter                        CacheProvider      `json:"otter" yaml:"otter"`
+	Redis                        CacheProvider      `json:"redis" yaml:"redis"`
+	Port                         Port               `json:"port" yaml:"port"`
+	Regex                        Regex              `json:"regex" yaml:"regex"`
+	SimpleFS                     CacheProvider      `json:"simplefs" yaml:"simplefs"`
+	Stale                        Duration           `json:"stale" yaml:"stale"`
+	Storers                      []string           `json:"storers" yaml:"storers"`
+	Timeout                      Timeout            `json:"timeout" yaml:"timeout"`
+	TTL                          Duration           `json:"ttl" yaml:"ttl"`
+	DefaultCacheControl          string             `json:"default_cache_control" yaml:"default_cache_control"`
+	MaxBodyBytes                 uint64             `json:"max_cacheable_body_bytes" yaml:"max_cacheable_body_bytes"`
+	DisableCoalescing            bool               `json:"disable_coalescing" yaml:"disable_coalescing"`
+	Coalescing                   Coalescing         `json:"coalescing" yaml:"coalescing"`
+	Revalidation                 Revalidation       `json:"revalidation" yaml:"revalidation"`
+	EarlyExpiration              EarlyExpiration    `json:"early_expiration" yaml:"early_expiration"`
+	OriginLimit                  OriginLimit        `json:"origin_limit" yaml:"origin_limit"`
+	ForcedRefresh                ForcedRefresh      `json:"forced_refresh" yaml:"forced_refresh"`
+	InvalidationBus              InvalidationBus    `json:"invalidation_bus" yaml:"invalidation_bus"`
+	MappingEvictionInterval      Duration           `json:"mapping_eviction_interval" yaml:"mapping_eviction_interval"`
+	Rules                        []Rule             `json:"rules" yaml:"rules"`
+	HeuristicFreshness           HeuristicFreshness `json:"heuristic_freshness" yaml:"heuristic_freshness"`
+	GeneratedETag                GeneratedETag      `json:"generated_etag" yaml:"generated_etag"`
+	TargetedCacheControl         []string           `json:"targeted_cache_control" yaml:"targeted_cache_control"`
+	ServeStaleOnTimeout          bool               `json:"serve_stale_on_timeout" yaml:"serve_stale_on_timeout"`
 }
 
 // GetAllowedHTTPVerbs returns the allowed verbs to cache
@@ -406,6 +577,36 @@ func (d *DefaultCache) IsCoalescingDisable() bool {
 	return d.DisableCoalescing
 }
 
+// GetCoalescing returns the coalescing configuration
+func (d *DefaultCache) GetCoalescing() Coalescing {
+	return d.Coalescing
+}
+
+// GetRevalidation returns the background revalidation configuration
+func (d *DefaultCache) GetRevalidation() Revalidation {
+	return d.Revalidation
+}
+
+// GetEarlyExpiration returns the probabilistic early expiration configuration
+func (d *DefaultCache) GetEarlyExpiration() EarlyExpiration {
+	return d.EarlyExpiration
+}
+
+// GetOriginLimit returns the upstream concurrency limits
+func (d *DefaultCache) GetOriginLimit() OriginLimit {
+	return d.OriginLimit
+}
+
+// GetForcedRefresh returns the authorized forced refresh configuration
+func (d *DefaultCache) GetForcedRefresh() ForcedRefresh {
+	return d.ForcedRefresh
+}
+
+// GetInvalidationBus returns the cross-instance invalidation bus configuration
+func (d *DefaultCache) GetInvalidationBus() InvalidationBus {
+	return d.InvalidationBus
+}
+
 // GetMappingEvictionInterval returns the interval for mapping eviction
 func (d *DefaultCache) GetMappingEvictionInterval() time.Duration {
 	if d.MappingEvictionInterval.Duration == 0 {
@@ -414,6 +615,31 @@ func (d *DefaultCache) GetMappingEvictionInterval() time.Duration {
 	return d.MappingEvictionInterval.Duration
 }
 
+// GetRules returns the CEL cache rules
+func (d *DefaultCache) GetRules() []Rule {
+	return d.Rules
+}
+
+// GetHeuristicFreshness returns the heuristic freshness configuration
+func (d *DefaultCache) GetHeuristicFreshness() HeuristicFreshness {
+	return d.HeuristicFreshness
+}
+
+// GetGeneratedETag returns the generated ETag configuration
+func (d *DefaultCache) GetGeneratedETag() GeneratedETag {
+	return d.GeneratedETag
+}
+
+// GetTargetedCacheControl returns the ordered targeted cache control fields (RFC 9213)
+func (d *DefaultCache) GetTargetedCacheControl() []string {
+	return d.TargetedCacheControl
+}
+
+// GetServeStaleOnTimeout returns if the stale response is served when the backend timeout is exceeded
+func (d *DefaultCache) GetServeStaleOnTimeout() bool {
+	return d.ServeStaleOnTimeout
+}
+
 // DefaultCacheInterface interface
 type DefaultCacheInterface interface {
 	GetAllowedHTTPVerbs() []string
@@ -440,7 +666,18 @@ type DefaultCacheInterface interface {
 	GetDefaultCacheControl() string
 	GetMaxBodyBytes() uint64
 	IsCoalescingDisable() bool
+	GetCoalescing() Coalescing
+	GetRevalidation() Revalidation
+	GetEarlyExpiration() EarlyExpiration
+	GetOriginLimit() OriginLimit
+	GetForcedRefresh() ForcedRefresh
+	GetInvalidationBus() InvalidationBus
 	GetMappingEvictionInterval() time.Duration
+	GetRules() []Rule
+	GetHeuristicFreshness() HeuristicFreshness
+	GetGeneratedETag() GeneratedETag
+	GetTargetedCacheControl() []string
+	GetServeStaleOnTimeout() bool
 }
 
 // APIEndpoint is the minimal structure to define an endpoint
@@ -474,14 +711,32 @@ type API struct {
 }
 
 type SurrogateConfiguration struct {
-	Storer string `json:"storer" yaml:"storer"`
+	Storer             string   `json:"storer" yaml:"storer"`
+	CompactionInterval Duration `json:"compaction_interval,omitempty" yaml:"compaction_interval,omitempty"`
+	HierarchicalKeys   bool     `json:"hierarchical_keys,omitempty" yaml:"hierarchical_keys,omitempty"`
+	MaxBodyBytes       int      `json:"max_body_bytes,omitempty" yaml:"max_body_bytes,omitempty"`
+	MaxBodyTags        int      `json:"max_body_tags,omitempty" yaml:"max_body_tags,omitempty"`
+	// Dependencies maps a tag, with optional {name} placeholders, to the
+	// tags purged along with it.
+	Dependencies       map[string][]string `json:"dependencies,omitempty" yaml:"dependencies,omitempty"`
+	MaxDependencyDepth int                 `json:"max_dependency_depth,omitempty" yaml:"max_dependency_depth,omitempty"`
+	MaxWildcardTags    int                 `json:"max_wildcard_tags,omitempty" yaml:"max_wildcard_tags,omitempty"`
+}
+
+// SurrogateJSONPath extracts the surrogate keys from the values matching the
+// JSONPath expression in the JSON response bodies, prefixed with Prefix.
+type SurrogateJSONPath struct {
+	Path   string `json:"path" yaml:"path"`
+	Prefix string `json:"prefix,omitempty" yaml:"prefix,omitempty"`
 }
 
 // SurrogateKeys structure define the way surrogate keys are stored
 type SurrogateKeys struct {
 	SurrogateConfiguration
-	URL     string            `json:"url" yaml:"url"`
-	Headers map[string]string `json:"headers" yaml:"headers"`
+	URL       string              `json:"url" yaml:"url"`
+	Headers   map[string]string   `json:"headers" yaml:"headers"`
+	JSONPaths []SurrogateJSONPath `json:"json_paths,omitempty" yaml:"json_paths,omitempty"`
+	GraphQL   bool                `json:"graphql,omitempty" yaml:"graphql,omitempty"`
 }
 
 // AbstractConfigurationInterface interface
@@ -13,10 +13,11 @@ import (
 type testConfiguration struct {
 	defaultCache *configurationtypes.DefaultCache
 	cacheKeys    configurationtypes.CacheKeys
+	urls         map[string]configurationtypes.URL
 }
 
-func (*testConfiguration) GetUrls() map[string]configurationtypes.URL {
-	return nil
+func (t *testConfiguration) GetUrls() map[string]configurationtypes.URL {
+	return t.urls
 }
 func (*testConfiguration) GetPluginName() string {
 	return ""
@@ -19,15 +19,25 @@ const (
 
 type graphQLContext struct {
 	custom bool
+	urls   map[string]bool
+}
+
+func (g *graphQLContext) isCustom(req *http.Request) bool {
+	if rule := GetMatchedURL(req.Context()); rule != nil && g.urls[rule.Pattern] {
+		return true
+	}
+
+	return g.custom
 }
 
 func (g *graphQLContext) SetContextWithBaseRequest(req *http.Request, baseRq *http.Request) *http.Request {
+	custom := g.isCustom(req)
 	ctx := req.Context()
-	ctx = context.WithValue(ctx, GraphQL, g.custom)
+	ctx = context.WithValue(ctx, GraphQL, custom)
 	ctx = context.WithValue(ctx, HashBody, "")
 	ctx = context.WithValue(ctx, IsMutationRequest, false)
 
-	if g.custom && req.Body != nil {
+	if custom && req.Body != nil {
 		b := bytes.NewBuffer([]byte{})
 		_, _ = io.Copy(b, req.Body)
 		req.Body = io.NopCloser(b)
@@ -52,6 +62,14 @@ func (g *graphQLContext) SetupContext(c configurationtypes.AbstractConfiguration
 		g.custom = true
 		c.GetLogger().Debug("Enable GraphQL logic due to your custom HTTP verbs setup.")
 	}
+
+	g.urls = make(map[string]bool)
+	for pattern, u := range c.GetUrls() {
+		if len(u.AllowedHTTPVerbs) != 0 {
+			g.urls[pattern] = true
+			c.GetLogger().Debugf("Enable GraphQL logic for the url %s due to your custom HTTP verbs setup.", pattern)
+		}
+	}
 }
 
 func isMutation(b []byte) bool {
@@ -59,12 +77,13 @@ func isMutation(b []byte) bool {
 }
 
 func (g *graphQLContext) SetContext(req *http.Request) *http.Request {
+	custom := g.isCustom(req)
 	ctx := req.Context()
-	ctx = context.WithValue(ctx, GraphQL, g.custom)
+	ctx = context.WithValue(ctx, GraphQL, custom)
 	ctx = context.WithValue(ctx, HashBody, "")
 	ctx = context.WithValue(ctx, IsMutationRequest, false)
 
-	if g.custom && req.Body != nil {
+	if custom && req.Body != nil {
 		b := bytes.NewBuffer([]byte{})
 		_, _ = io.Copy(b, req.Body)
 		req.Body = io.NopCloser(b)
@@ -33,6 +33,7 @@ type keyContext struct {
 	headers        []string
 	template       string
 	overrides      []map[*regexp.Regexp]keyContext
+	urls           map[string]keyContext
 
 	initializer func(r *http.Request) *http.Request
 }
@@ -41,6 +42,22 @@ func (*keyContext) SetContextWithBaseRequest(req *http.Request, _ *http.Request)
 	return req
 }
 
+func newKeyContext(k configurationtypes.Key) keyContext {
+	return keyContext{
+		disable_body:   k.DisableBody,
+		disable_host:   k.DisableHost,
+		disable_method: k.DisableMethod,
+		disable_query:  k.DisableQuery,
+		sort_query:     k.SortQuery,
+		disable_scheme: k.DisableScheme,
+		disable_vary:   k.DisableVary,
+		hash:           k.Hash,
+		displayable:    !k.Hide,
+		template:       k.Template,
+		headers:        k.Headers,
+	}
+}
+
 func (g *keyContext) SetupContext(c configurationtypes.AbstractConfigurationInterface) {
 	k := c.GetDefaultCache().GetKey()
 	g.disable_body = k.DisableBody
@@ -59,19 +76,14 @@ func (g *keyContext) SetupContext(c configurationtypes.AbstractConfigurationInte
 
 	for _, cacheKey := range c.GetCacheKeys() {
 		for r, v := range cacheKey {
-			g.overrides = append(g.overrides, map[*regexp.Regexp]keyContext{r.Regexp: {
-				disable_body:   v.DisableBody,
-				disable_host:   v.DisableHost,
-				disable_method: v.DisableMethod,
-				disable_query:  v.DisableQuery,
-				sort_query:     v.SortQuery,
-				disable_scheme: v.DisableScheme,
-				disable_vary:   v.DisableVary,
-				hash:           v.Hash,
-				displayable:    !v.Hide,
-				template:       v.Template,
-				headers:        v.Headers,
-			}})
+			g.overrides = append(g.overrides, map[*regexp.Regexp]keyContext{r.Regexp: newKeyContext(v)})
+		}
+	}
+
+	g.urls = make(map[string]keyContext)
+	for pattern, u := range c.GetUrls() {
+		if u.Key != nil {
+			g.urls[pattern] = newKeyContext(*u.Key)
 		}
 	}
 
@@ -134,12 +146,25 @@ func parseKeyInformations(req *http.Request, kCtx keyContext) (query, body, host
 	return
 }
 
+// forRequest returns the key strategy of the matched urls rule if it
+// declares one, the default key strategy otherwise.
+func (g *keyContext) forRequest(req *http.Request) *keyContext {
+	if rule := GetMatchedURL(req.Context()); rule != nil {
+		if urlKey, ok := g.urls[rule.Pattern]; ok {
+			return &urlKey
+		}
+	}
+
+	return g
+}
+
 func (g *keyContext) computeKey(req *http.Request) (key string, headers []string, hash, displayable bool) {
-	if g.template != "" {
-		return req.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer).ReplaceAll(g.template, ""), g.headers, g.hash, g.displayable
+	base := g.forRequest(req)
+	if base.template != "" {
+		return req.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer).ReplaceAll(base.template, ""), base.headers, base.hash, base.displayable
 	}
 	key = req.URL.Path
-	query, body, host, scheme, method, headerValues, headers, displayable, hash := parseKeyInformations(req, *g)
+	query, body, host, scheme, method, headerValues, headers, displayable, hash := parseKeyInformations(req, *base)
 
 	hasOverride := false
 	for _, current := range g.overrides {
@@ -179,7 +204,7 @@ func (g *keyContext) SetContext(req *http.Request) *http.Request {
 							key,
 						),
 						core.DISABLE_VARY_CTX, //nolint:staticcheck // we don't care about collision
-						g.disable_vary,
+						g.forRequest(req).disable_vary,
 					),
 					Hashed,
 					hash,
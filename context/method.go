@@ -3,6 +3,7 @@ package context
 import (
 	"context"
 	"net/http"
+	"slices"
 
 	"github.com/darkweak/souin/configurationtypes"
 )
@@ -14,6 +15,7 @@ var defaultVerbs []string = []string{http.MethodGet, http.MethodHead}
 type methodContext struct {
 	allowedVerbs []string
 	custom       bool
+	urls         map[string][]string
 }
 
 func (*methodContext) SetContextWithBaseRequest(req *http.Request, _ *http.Request) *http.Request {
@@ -27,19 +29,25 @@ func (m *methodContext) SetupContext(c configurationtypes.AbstractConfigurationI
 		m.custom = true
 	}
 	c.GetLogger().Debugf("Allow %d method(s). %v.", len(m.allowedVerbs), m.allowedVerbs)
+
+	m.urls = make(map[string][]string)
+	for pattern, u := range c.GetUrls() {
+		if len(u.AllowedHTTPVerbs) != 0 {
+			m.urls[pattern] = u.AllowedHTTPVerbs
+			c.GetLogger().Debugf("Allow %d method(s) for the url %s. %v.", len(u.AllowedHTTPVerbs), pattern, u.AllowedHTTPVerbs)
+		}
+	}
 }
 
 func (m *methodContext) SetContext(req *http.Request) *http.Request {
-	v := false
-
-	for _, a := range m.allowedVerbs {
-		if req.Method == a {
-			v = true
-			break
+	allowedVerbs := m.allowedVerbs
+	if rule := GetMatchedURL(req.Context()); rule != nil {
+		if urlVerbs, ok := m.urls[rule.Pattern]; ok {
+			allowedVerbs = urlVerbs
 		}
 	}
 
-	return req.WithContext(context.WithValue(req.Context(), SupportedMethod, v))
+	return req.WithContext(context.WithValue(req.Context(), SupportedMethod, slices.Contains(allowedVerbs, req.Method)))
 }
 
 var _ ctx = (*methodContext)(nil)
@@ -11,22 +11,45 @@ const Mode ctxKey = "souin_ctx.MODE"
 
 type ModeContext struct {
 	Strict, Bypass_request, Bypass_response bool
+
+	urls map[string]*ModeContext
 }
 
 func (*ModeContext) SetContextWithBaseRequest(req *http.Request, _ *http.Request) *http.Request {
 	return req
 }
 
-func (mc *ModeContext) SetupContext(c configurationtypes.AbstractConfigurationInterface) {
-	mode := c.GetDefaultCache().GetMode()
+func (mc *ModeContext) setMode(mode string) {
 	mc.Bypass_request = mode == "bypass" || mode == "bypass_request"
 	mc.Bypass_response = mode == "bypass" || mode == "bypass_response"
 	mc.Strict = !mc.Bypass_request && !mc.Bypass_response
+}
+
+func (mc *ModeContext) SetupContext(c configurationtypes.AbstractConfigurationInterface) {
+	mode := c.GetDefaultCache().GetMode()
+	mc.setMode(mode)
 	c.GetLogger().Debugf("The cache logic will run as %s: %+v", mode, mc)
+
+	mc.urls = make(map[string]*ModeContext)
+	for pattern, u := range c.GetUrls() {
+		if u.Mode != "" {
+			urlMode := &ModeContext{}
+			urlMode.setMode(u.Mode)
+			mc.urls[pattern] = urlMode
+			c.GetLogger().Debugf("The cache logic will run as %s for the url %s: %+v", u.Mode, pattern, urlMode)
+		}
+	}
 }
 
 func (mc *ModeContext) SetContext(req *http.Request) *http.Request {
-	return req.WithContext(context.WithValue(req.Context(), Mode, mc))
+	current := mc
+	if rule := GetMatchedURL(req.Context()); rule != nil {
+		if urlMode, ok := mc.urls[rule.Pattern]; ok {
+			current = urlMode
+		}
+	}
+
+	return req.WithContext(context.WithValue(req.Context(), Mode, current))
 }
 
 var _ ctx = (*ModeContext)(nil)
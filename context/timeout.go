@@ -9,8 +9,9 @@ import (
 )
 
 const (
-	TimeoutCache  ctxKey = "souin_ctx.TIMEOUT_CACHE"
-	TimeoutCancel ctxKey = "souin_ctx.TIMEOUT_CANCEL"
+	TimeoutBackend ctxKey = "souin_ctx.TIMEOUT_BACKEND"
+	TimeoutCache   ctxKey = "souin_ctx.TIMEOUT_CACHE"
+	TimeoutCancel  ctxKey = "souin_ctx.TIMEOUT_CANCEL"
 )
 
 const (
@@ -20,6 +21,7 @@ const (
 
 type timeoutContext struct {
 	timeoutCache, timeoutBackend time.Duration
+	urls                         map[string]timeoutContext
 }
 
 func (*timeoutContext) SetContextWithBaseRequest(req *http.Request, _ *http.Request) *http.Request {
@@ -37,11 +39,36 @@ func (t *timeoutContext) SetupContext(c configurationtypes.AbstractConfiguration
 	}
 	c.GetLogger().Infof("Set backend timeout to %v", t.timeoutBackend)
 	c.GetLogger().Infof("Set cache timeout to %v", t.timeoutCache)
+
+	t.urls = make(map[string]timeoutContext)
+	for pattern, u := range c.GetUrls() {
+		if u.Timeout.Backend.Duration == 0 && u.Timeout.Cache.Duration == 0 {
+			continue
+		}
+
+		urlTimeout := timeoutContext{timeoutCache: t.timeoutCache, timeoutBackend: t.timeoutBackend}
+		if u.Timeout.Cache.Duration != 0 {
+			urlTimeout.timeoutCache = u.Timeout.Cache.Duration
+		}
+		if u.Timeout.Backend.Duration != 0 {
+			urlTimeout.timeoutBackend = u.Timeout.Backend.Duration
+		}
+		t.urls[pattern] = urlTimeout
+		c.GetLogger().Infof("Set backend timeout to %v and cache timeout to %v for the url %s", urlTimeout.timeoutBackend, urlTimeout.timeoutCache, pattern)
+	}
 }
 
 func (t *timeoutContext) SetContext(req *http.Request) *http.Request {
-	ctx, cancel := context.WithTimeout(req.Context(), t.timeoutBackend)
-	return req.WithContext(context.WithValue(context.WithValue(ctx, TimeoutCancel, cancel), TimeoutCache, t.timeoutCache))
+	timeoutCache, timeoutBackend := t.timeoutCache, t.timeoutBackend
+	if rule := GetMatchedURL(req.Context()); rule != nil {
+		if urlTimeout, ok := t.urls[rule.Pattern]; ok {
+			timeoutCache, timeoutBackend = urlTimeout.timeoutCache, urlTimeout.timeoutBackend
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), timeoutBackend)
+	ctx = context.WithValue(ctx, TimeoutBackend, timeoutBackend)
+	return req.WithContext(context.WithValue(context.WithValue(ctx, TimeoutCancel, cancel), TimeoutCache, timeoutCache))
 }
 
 var _ ctx = (*timeoutContext)(nil)
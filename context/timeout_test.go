@@ -67,6 +67,9 @@ func Test_TimeoutContext_SetContext(t *testing.T) {
 
 	req := httptest.NewRequest(http.MethodGet, "http://domain.com", nil)
 	req = ctx.SetContext(req)
+	if req.Context().Value(TimeoutBackend).(time.Duration) != time.Second {
+		t.Error("The TimeoutBackend context must be set in the request.")
+	}
 	if req.Context().Value(TimeoutCache).(time.Duration) != time.Millisecond {
 		t.Error("The TimeoutCache context must be set in the request.")
 	}
@@ -23,6 +23,7 @@ type (
 		Mode      ctx
 		Now       ctx
 		Timeout   ctx
+		URL       ctx
 	}
 )
 
@@ -37,10 +38,12 @@ func GetContext() *Context {
 		Mode:      &ModeContext{},
 		Now:       &nowContext{},
 		Timeout:   &timeoutContext{},
+		URL:       &urlContext{},
 	}
 }
 
 func (c *Context) Init(co configurationtypes.AbstractConfigurationInterface) {
+	c.URL.SetupContext(co)
 	c.CacheName.SetupContext(co)
 	c.GraphQL.SetupContext(co)
 	c.Key.SetupContext(co)
@@ -51,7 +54,7 @@ func (c *Context) Init(co configurationtypes.AbstractConfigurationInterface) {
 }
 
 func (c *Context) SetBaseContext(req *http.Request) *http.Request {
-	return c.Mode.SetContext(c.Timeout.SetContext(c.Method.SetContext(c.CacheName.SetContext(c.Now.SetContext(req)))))
+	return c.Mode.SetContext(c.Timeout.SetContext(c.Method.SetContext(c.CacheName.SetContext(c.Now.SetContext(c.URL.SetContext(req))))))
 }
 
 func (c *Context) SetContext(req *http.Request, baseRq *http.Request) *http.Request {
@@ -0,0 +1,92 @@
+package context
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"sort"
+
+	"github.com/darkweak/souin/configurationtypes"
+)
+
+const MatchedURL ctxKey = "souin_ctx.MATCHED_URL"
+
+// URLRule is the urls configuration entry matching the current request.
+type URLRule struct {
+	Pattern string
+	configurationtypes.URL
+}
+
+type urlRule struct {
+	regexp *regexp.Regexp
+	rule   *URLRule
+}
+
+type urlContext struct {
+	rules []urlRule
+}
+
+func (*urlContext) SetContextWithBaseRequest(req *http.Request, _ *http.Request) *http.Request {
+	return req
+}
+
+// sortedURLPatterns returns the urls patterns in their matching precedence, the
+// longest (most specific) first then the lexicographic order for the same
+// length. The first pattern matching the host and path wins.
+func sortedURLPatterns(urls map[string]configurationtypes.URL) []string {
+	patterns := make([]string, 0, len(urls))
+	for pattern := range urls {
+		patterns = append(patterns, pattern)
+	}
+
+	sort.Slice(patterns, func(i, j int) bool {
+		if len(patterns[i]) != len(patterns[j]) {
+			return len(patterns[i]) > len(patterns[j])
+		}
+
+		return patterns[i] < patterns[j]
+	})
+
+	return patterns
+}
+
+func (u *urlContext) SetupContext(c configurationtypes.AbstractConfigurationInterface) {
+	urls := c.GetUrls()
+	u.rules = make([]urlRule, 0, len(urls))
+
+	for _, pattern := range sortedURLPatterns(urls) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			c.GetLogger().Errorf("Impossible to compile the url regexp %s: %v", pattern, err)
+			continue
+		}
+
+		u.rules = append(u.rules, urlRule{
+			regexp: re,
+			rule:   &URLRule{Pattern: pattern, URL: urls[pattern]},
+		})
+	}
+	c.GetLogger().Debugf("Loaded %d url rule(s).", len(u.rules))
+}
+
+func (u *urlContext) SetContext(req *http.Request) *http.Request {
+	target := req.Host + req.URL.Path
+	for _, r := range u.rules {
+		if r.regexp.MatchString(target) {
+			return req.WithContext(context.WithValue(req.Context(), MatchedURL, r.rule))
+		}
+	}
+
+	return req
+}
+
+// GetMatchedURL returns the urls rule resolved for the request or nil if none matched.
+func GetMatchedURL(c context.Context) *URLRule {
+	if rule, ok := c.Value(MatchedURL).(*URLRule); ok {
+		return rule
+	}
+
+	return nil
+}
+
+var _ ctx = (*urlContext)(nil)
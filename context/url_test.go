@@ -0,0 +1,114 @@
+package context
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/darkweak/souin/configurationtypes"
+	"go.uber.org/zap"
+)
+
+func newURLTestConfiguration() *testConfiguration {
+	c := &testConfiguration{
+		defaultCache: &configurationtypes.DefaultCache{},
+		urls: map[string]configurationtypes.URL{
+			"domain.com/api": {
+				Mode:             "bypass",
+				AllowedHTTPVerbs: []string{http.MethodGet, http.MethodPost},
+				Timeout: configurationtypes.Timeout{
+					Backend: configurationtypes.Duration{Duration: time.Second},
+				},
+				Key: &configurationtypes.Key{DisableHost: true, DisableMethod: true},
+			},
+			"domain.com/api/v2": {
+				TTL: configurationtypes.Duration{Duration: time.Minute},
+			},
+			"domain.com/static": {
+				Stale: configurationtypes.Duration{Duration: time.Hour},
+			},
+		},
+	}
+	c.SetLogger(zap.NewNop().Sugar())
+
+	return c
+}
+
+func Test_URLContext_SetupContext(t *testing.T) {
+	ctx := urlContext{}
+	ctx.SetupContext(newURLTestConfiguration())
+
+	if len(ctx.rules) != 3 {
+		t.Fatalf("The url context must contain 3 rules, %d given.", len(ctx.rules))
+	}
+	if ctx.rules[0].rule.Pattern != "domain.com/api/v2" {
+		t.Errorf("The longest pattern must be evaluated first, %s given.", ctx.rules[0].rule.Pattern)
+	}
+}
+
+func Test_URLContext_SetContext(t *testing.T) {
+	ctx := urlContext{}
+	ctx.SetupContext(newURLTestConfiguration())
+
+	req := ctx.SetContext(httptest.NewRequest(http.MethodGet, "http://domain.com/api/v2/users", nil))
+	if rule := GetMatchedURL(req.Context()); rule == nil || rule.Pattern != "domain.com/api/v2" || rule.TTL.Duration != time.Minute {
+		t.Errorf("The matched url must be domain.com/api/v2, %+v given.", rule)
+	}
+
+	req = ctx.SetContext(httptest.NewRequest(http.MethodGet, "http://domain.com/api/users", nil))
+	if rule := GetMatchedURL(req.Context()); rule == nil || rule.Pattern != "domain.com/api" {
+		t.Errorf("The matched url must be domain.com/api, %+v given.", rule)
+	}
+
+	ctx.SetupContext(&testConfiguration{
+		defaultCache: &configurationtypes.DefaultCache{},
+		urls: map[string]configurationtypes.URL{
+			"domain.com/b.": {TTL: configurationtypes.Duration{Duration: time.Second}},
+			"domain.com/.c": {TTL: configurationtypes.Duration{Duration: time.Minute}},
+		},
+	})
+	req = ctx.SetContext(httptest.NewRequest(http.MethodGet, "http://domain.com/bc", nil))
+	if rule := GetMatchedURL(req.Context()); rule == nil || rule.Pattern != "domain.com/.c" {
+		t.Errorf("The patterns of the same length must be evaluated in the lexicographic order, %+v given.", rule)
+	}
+
+	req = ctx.SetContext(httptest.NewRequest(http.MethodGet, "http://domain.com/other", nil))
+	if rule := GetMatchedURL(req.Context()); rule != nil {
+		t.Errorf("No url must match, %+v given.", rule)
+	}
+}
+
+func Test_URLContext_Overrides(t *testing.T) {
+	c := newURLTestConfiguration()
+	co := GetContext()
+	co.Init(c)
+
+	req := co.SetBaseContext(httptest.NewRequest(http.MethodPost, "http://domain.com/api/users", nil))
+	if !req.Context().Value(SupportedMethod).(bool) {
+		t.Error("The POST method must be supported for the domain.com/api url.")
+	}
+	if mode := req.Context().Value(Mode).(*ModeContext); !mode.Bypass_request || !mode.Bypass_response {
+		t.Errorf("The mode must be bypass for the domain.com/api url, %+v given.", mode)
+	}
+	if deadline, ok := req.Context().Deadline(); !ok || time.Until(deadline) > time.Second {
+		t.Error("The backend timeout must be one second for the domain.com/api url.")
+	}
+	req = co.SetContext(req, req)
+	if req.Context().Value(Key) != "http-/api/users" {
+		t.Errorf("The key must be http-/api/users, %s given.", req.Context().Value(Key))
+	}
+
+	req = co.SetBaseContext(httptest.NewRequest(http.MethodPost, "http://domain.com/static/style.css", nil))
+	if req.Context().Value(SupportedMethod).(bool) {
+		t.Error("The POST method must not be supported for the domain.com/static url.")
+	}
+	if mode := req.Context().Value(Mode).(*ModeContext); !mode.Strict {
+		t.Errorf("The mode must be strict for the domain.com/static url, %+v given.", mode)
+	}
+	req.Method = http.MethodGet
+	req = co.SetContext(req, req)
+	if req.Context().Value(Key) != "GET-http-domain.com-/static/style.css" {
+		t.Errorf("The key must be GET-http-domain.com-/static/style.css, %s given.", req.Context().Value(Key))
+	}
+}
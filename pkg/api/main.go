@@ -1,6 +1,8 @@
 package api
 
 import (
+	"errors"
+	"io"
 	"net/http"
 
 	"github.com/darkweak/souin/configurationtypes"
@@ -13,6 +15,21 @@ import (
 // MapHandler is a map to store the available http Handlers
 type MapHandler struct {
 	Handlers *map[string]http.HandlerFunc
+	closers  []io.Closer
+}
+
+// Close releases the resources held by the endpoints.
+func (m *MapHandler) Close() error {
+	if m == nil {
+		return nil
+	}
+
+	var errs []error
+	for _, closer := range m.closers {
+		errs = append(errs, closer.Close())
+	}
+
+	return errors.Join(errs...)
 }
 
 // GenerateHandlerMap generate the MapHandler
@@ -22,7 +39,7 @@ func GenerateHandlerMap(
 	surrogateStorage providers.SurrogateInterface,
 ) *MapHandler {
 	hm := make(map[string]http.HandlerFunc)
-	shouldEnable := false
+	closers := []io.Closer{}
 
 	souinAPI := configuration.GetAPI()
 	basePathAPIS := souinAPI.BasePath
@@ -32,16 +49,15 @@ func GenerateHandlerMap(
 
 	for _, endpoint := range Initialize(configuration, storers, surrogateStorage) {
 		if endpoint.IsEnabled() {
-			shouldEnable = true
 			hm[basePathAPIS+endpoint.GetBasePath()] = endpoint.HandleRequest
 		}
+		// The disabled endpoints may still apply the remote invalidations.
+		if closer, ok := endpoint.(io.Closer); ok {
+			closers = append(closers, closer)
+		}
 	}
 
-	if shouldEnable {
-		return &MapHandler{Handlers: &hm}
-	}
-
-	return nil
+	return &MapHandler{Handlers: &hm, closers: closers}
 }
 
 // Initialize contains all apis that should be enabled
@@ -10,14 +10,29 @@ import (
 )
 
 const (
-	counter = "counter"
-	average = "average"
+	counter   = "counter"
+	average   = "average"
+	histogram = "histogram"
+	gauge     = "gauge"
 
 	RequestCounter             = "souin_request_upstream_counter"
 	RequestRevalidationCounter = "souin_request_revalidation_counter"
 	NoCachedResponseCounter    = "souin_no_cached_response_counter"
 	CachedResponseCounter      = "souin_cached_response_counter"
 	AvgResponseTime            = "souin_avg_response_time"
+	CoalescedResponseCounter   = "souin_coalesced_response_counter"
+	CoalescingTimeoutCounter   = "souin_coalescing_timeout_counter"
+	CoalescingWaiters          = "souin_coalescing_waiters"
+	RevalidationQueueSize      = "souin_revalidation_queue_size"
+	RevalidationDedupCounter   = "souin_revalidation_deduplicated_counter"
+	RevalidationDroppedCounter = "souin_revalidation_dropped_counter"
+	EarlyRefreshCounter        = "souin_early_refresh_counter"
+	OriginQueueSize            = "souin_origin_queue_size"
+	OriginRejectedCounter      = "souin_origin_rejected_counter"
+	CDNPurgeSuccessCounter     = "souin_cdn_purge_success_counter"
+	CDNPurgeFailureCounter     = "souin_cdn_purge_failure_counter"
+	CDNPurgeRetryCounter       = "souin_cdn_purge_retry_counter"
+	CDNPurgeQueueSize          = "souin_cdn_purge_queue_size"
 )
 
 // PrometheusAPI object contains informations related to the endpoints
@@ -67,6 +82,13 @@ func Increment(name string) {
 	}
 }
 
+// Set will set the gauge to the referred value.
+func Set(name string, value float64) {
+	if g, ok := registered[name].(prometheus.Gauge); ok {
+		g.Set(value)
+	}
+}
+
 // Increment will add the referred value the counter.
 func Add(name string, value float64) {
 	if c, ok := registered[name].(prometheus.Counter); ok {
@@ -93,6 +115,19 @@ func push(promType, name, help string) {
 		})
 		prometheus.MustRegister(avg)
 		registered[name] = avg
+	case histogram:
+		h := prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    name,
+			Help:    help,
+			Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+		})
+		prometheus.MustRegister(h)
+		registered[name] = h
+	case gauge:
+		registered[name] = promauto.NewGauge(prometheus.GaugeOpts{
+			Name: name,
+			Help: help,
+		})
 	}
 }
 
@@ -104,4 +139,17 @@ func run() {
 	push(counter, NoCachedResponseCounter, "No cached response counter")
 	push(counter, CachedResponseCounter, "Cached response counter")
 	push(average, AvgResponseTime, "Average response time")
+	push(counter, CoalescedResponseCounter, "Response shared with the coalesced requests counter")
+	push(counter, CoalescingTimeoutCounter, "Coalesced requests that exceeded the coalescing wait counter")
+	push(histogram, CoalescingWaiters, "Number of coalesced requests waiting for the same key")
+	push(gauge, RevalidationQueueSize, "Number of background revalidations waiting in the queue")
+	push(counter, RevalidationDedupCounter, "Background revalidations skipped because the key is already revalidated counter")
+	push(counter, RevalidationDroppedCounter, "Background revalidations dropped because the queue is full counter")
+	push(counter, EarlyRefreshCounter, "Fresh responses refreshed before their expiry counter")
+	push(gauge, OriginQueueSize, "Number of upstream requests waiting for the origin concurrency limit")
+	push(counter, OriginRejectedCounter, "Upstream requests rejected by the origin concurrency limit counter")
+	push(counter, CDNPurgeSuccessCounter, "CDN purge requests accepted by the provider counter")
+	push(counter, CDNPurgeFailureCounter, "CDN purge requests abandoned after a permanent error or the last attempt counter")
+	push(counter, CDNPurgeRetryCounter, "CDN purge requests scheduled for a retry counter")
+	push(gauge, CDNPurgeQueueSize, "Number of CDN purge requests waiting to be sent")
 }
@@ -13,8 +13,8 @@ func Test_Run(t *testing.T) {
 	}
 
 	run()
-	if len(registered) != 5 {
-		t.Error("The registered additional metrics array must have 5 items.")
+	if len(registered) != 18 {
+		t.Error("The registered additional metrics array must have 18 items.")
 	}
 
 	i, ok := registered[RequestCounter]
@@ -61,6 +61,33 @@ func Test_Run(t *testing.T) {
 	if !ok {
 		t.Errorf("The souin_avg_response_time element must be a prometheus.Histogram object, %T given.", i)
 	}
+
+	i, ok = registered[CoalescedResponseCounter]
+	if !ok {
+		t.Error("The registered array must have the souin_coalesced_response_counter key")
+	}
+	_, ok = i.(prometheus.Counter)
+	if !ok {
+		t.Errorf("The souin_coalesced_response_counter element must be a prometheus.Counter object, %T given.", i)
+	}
+
+	i, ok = registered[CoalescingWaiters]
+	if !ok {
+		t.Error("The registered array must have the souin_coalescing_waiters key")
+	}
+	_, ok = i.(prometheus.Histogram)
+	if !ok {
+		t.Errorf("The souin_coalescing_waiters element must be a prometheus.Histogram object, %T given.", i)
+	}
+
+	i, ok = registered[RevalidationQueueSize]
+	if !ok {
+		t.Error("The registered array must have the souin_revalidation_queue_size key")
+	}
+	_, ok = i.(prometheus.Gauge)
+	if !ok {
+		t.Errorf("The souin_revalidation_queue_size element must be a prometheus.Gauge object, %T given.", i)
+	}
 }
 
 func getMetricValue(col prometheus.Collector, t string) float64 {
@@ -119,6 +146,17 @@ func Test_Increment(t *testing.T) {
 	}
 }
 
+func Test_Set(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+	run()
+	Set(RevalidationQueueSize, 3)
+	m := dto.Metric{}
+	_ = registered[RevalidationQueueSize].(prometheus.Gauge).Write(&m)
+	if m.Gauge.GetValue() != 3 {
+		t.Errorf("The souin_revalidation_queue_size value must be equal to 3 when it's set, %f given.", m.Gauge.GetValue())
+	}
+}
+
 func Test_push(t *testing.T) {
 	prometheus.DefaultRegisterer = prometheus.NewRegistry()
 	registered = make(map[string]interface{})
@@ -136,6 +174,12 @@ func Test_push(t *testing.T) {
 		t.Errorf("The dummy entry must be a type of prometheus.Histogram when the average type is set, %T given.", i)
 	}
 
+	push(histogram, "dummy_histogram", "")
+	i = registered["dummy_histogram"]
+	if _, ok := i.(prometheus.Histogram); !ok {
+		t.Errorf("The dummy_histogram entry must be a type of prometheus.Histogram when the histogram type is set, %T given.", i)
+	}
+
 	push(counter, "dummy_counter", "")
 	i = registered["dummy_counter"]
 	if i == nil {
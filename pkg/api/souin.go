@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/darkweak/souin/configurationtypes"
+	"github.com/darkweak/souin/pkg/bus"
 	"github.com/darkweak/souin/pkg/storage/types"
 	"github.com/darkweak/souin/pkg/surrogate/providers"
 	"github.com/darkweak/storages/core"
@@ -25,6 +26,8 @@ type SouinAPI struct {
 	allowedMethods   []string
 	compiledBP       *regexp.Regexp
 	extractArgsBP    *regexp.Regexp
+	bus              *bus.Bus
+	removeHandler    func()
 }
 
 type invalidationType string
@@ -36,11 +39,21 @@ const (
 	groupInvalidationType     invalidationType = "group"
 )
 
+// dryRunHeader makes the surrogate keys PURGE return the tags it would purge.
+const dryRunHeader = "Souin-Purge-Dry-Run"
+
 type invalidation struct {
 	Type      invalidationType `json:"type"`
 	Selectors []string         `json:"selectors"`
 	Groups    []string         `json:"groups"`
 	Purge     bool             `json:"purge"`
+	DryRun    bool             `json:"dry_run"`
+}
+
+// invalidationResult reports the tags purged by a group invalidation, the
+// patterns resolved and expanded with their dependents.
+type invalidationResult struct {
+	Tags []string `json:"tags"`
 }
 
 func initializeSouin(
@@ -58,7 +71,7 @@ func initializeSouin(
 		allowedMethods = []string{http.MethodGet, http.MethodHead}
 	}
 
-	return &SouinAPI{
+	s := &SouinAPI{
 		basePath,
 		configuration.GetAPI().Souin.Enable,
 		storers,
@@ -66,9 +79,56 @@ func initializeSouin(
 		allowedMethods,
 		regexp.MustCompile(basePath + "/.+"),
 		regexp.MustCompile(basePath + "/(.+)"),
+		bus.Get(configuration.GetDefaultCache().GetInvalidationBus(), configuration.GetLogger()),
+		nil,
+	}
+	s.removeHandler = s.bus.Handle(s.apply)
+
+	return s
+}
+
+// Close stops applying the remote invalidations and releases the bus.
+func (s *SouinAPI) Close() error {
+	s.removeHandler()
+
+	return s.bus.Close()
+}
+
+// apply applies locally an invalidation published by another instance. The
+// tags are purged from the local index only, the CDN was already purged by
+// the publisher.
+func (s *SouinAPI) apply(event bus.Event) {
+	switch event.Type {
+	case bus.PurgeEvent:
+		for _, k := range append(s.surrogateStorage.PurgeTags(event.Tags), event.Keys...) {
+			s.BulkDelete(k, event.Purge)
+		}
+	case bus.DeleteEvent:
+		for _, k := range event.Keys {
+			s.BulkDelete(k, event.Purge)
+		}
+	case bus.PatternEvent:
+		for _, current := range s.storers {
+			current.DeleteMany(event.Pattern)
+		}
+	case bus.FlushEvent:
+		s.flush()
+	case bus.GroupEvent:
+		s.surrogateStorage.PurgeTags(event.Tags)
 	}
 }
 
+func (s *SouinAPI) flush() {
+	for _, current := range s.storers {
+		current.DeleteMany(".+")
+	}
+	e := s.surrogateStorage.Reset()
+	if e != nil {
+		fmt.Printf("Error while purging the surrogate keys: %+v.", e)
+	}
+	fmt.Println("Successfully clear the cache and the surrogate keys storage.")
+}
+
 // BulkDelete allow user to delete multiple items with regexp
 func (s *SouinAPI) BulkDelete(key string, purge bool) {
 	key, _ = strings.CutPrefix(key, core.MappingKeyPrefix)
@@ -216,6 +276,39 @@ func (s *SouinAPI) purgeMapping() {
 	fmt.Println("Successfully clear the mappings.")
 }
 
+// lookupSurrogateKeys returns the tags per entry, of the key or uri query
+// parameters, the per-tag stats on /surrogate_keys/stats, or the stored keys.
+func (s *SouinAPI) lookupSurrogateKeys(r *http.Request) interface{} {
+	if strings.HasSuffix(r.URL.Path, "/surrogate_keys/stats") {
+		return s.surrogateStorage.Stats()
+	}
+
+	query := r.URL.Query()
+	if keys, uris := query["key"], query["uri"]; len(keys) > 0 || len(uris) > 0 {
+		lookup := map[string][]string{}
+		for _, key := range keys {
+			lookup[key] = s.surrogateStorage.LookupKey(key)
+		}
+		for _, uri := range uris {
+			for key, tags := range s.surrogateStorage.LookupURI(uri) {
+				lookup[key] = tags
+			}
+		}
+
+		return lookup
+	}
+
+	return s.surrogateStorage.List()
+}
+
+// writeTags answers the dry-run invalidations with the tags they would purge.
+func (*SouinAPI) writeTags(w http.ResponseWriter, tags []string) {
+	res, _ := json.Marshal(invalidationResult{Tags: providers.NonEmpty(tags)})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(res)
+}
+
 // HandleRequest will handle the request
 func (s *SouinAPI) HandleRequest(w http.ResponseWriter, r *http.Request) {
 	res := []byte{}
@@ -223,7 +316,9 @@ func (s *SouinAPI) HandleRequest(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
 		if strings.Contains(r.RequestURI, s.GetBasePath()+"/surrogate_keys") {
-			res, _ = json.Marshal(s.surrogateStorage.List())
+			res, _ = json.Marshal(s.lookupSurrogateKeys(r))
+		} else if strings.HasSuffix(r.RequestURI, s.GetBasePath()+"/purges") {
+			res, _ = json.Marshal(s.surrogateStorage.PurgeReport())
 		} else if compile {
 			search := s.extractArgsBP.FindAllStringSubmatch(r.RequestURI, -1)[0][1]
 			res, _ = json.Marshal(s.listKeys(search))
@@ -235,6 +330,11 @@ func (s *SouinAPI) HandleRequest(w http.ResponseWriter, r *http.Request) {
 		}
 		w.Header().Set("Content-Type", "application/json")
 	case http.MethodPost:
+		if strings.HasSuffix(r.RequestURI, s.GetBasePath()+"/bus") {
+			s.bus.ServeHTTP(w, r)
+			return
+		}
+
 		var invalidator invalidation
 		defer func() {
 			_ = r.Body.Close()
@@ -245,12 +345,27 @@ func (s *SouinAPI) HandleRequest(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		var groups []string
+		if invalidator.Type == groupInvalidationType {
+			groups, err = s.surrogateStorage.MatchTags(http.Header{"Surrogate-Key": invalidator.Groups})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if invalidator.DryRun {
+				s.writeTags(w, groups)
+				return
+			}
+		}
+
 		keysToInvalidate := []string{}
 		switch invalidator.Type {
 		case groupInvalidationType:
 			var surrogateKeys []string
-			keysToInvalidate, surrogateKeys = s.surrogateStorage.Purge(http.Header{"Surrogate-Key": invalidator.Groups})
+			keysToInvalidate, surrogateKeys = s.surrogateStorage.PurgeMatched(groups)
 			keysToInvalidate = append(keysToInvalidate, surrogateKeys...)
+			res, _ = json.Marshal(invalidationResult{Tags: providers.NonEmpty(surrogateKeys)})
+			w.Header().Set("Content-Type", "application/json")
 		case uriPrefixInvalidationType, uriInvalidationType:
 			bodyKeys := []string{}
 			listedKeys := s.GetAll()
@@ -305,21 +420,23 @@ func (s *SouinAPI) HandleRequest(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		if invalidator.Type != groupInvalidationType {
+			s.surrogateStorage.InvalidateKeys(keysToInvalidate)
+		}
 		for _, k := range keysToInvalidate {
 			s.BulkDelete(k, invalidator.Purge)
 		}
+		if invalidator.Type == groupInvalidationType {
+			s.bus.Publish(bus.Event{Type: bus.PurgeEvent, Tags: providers.EscapeWildcards(groups), Purge: invalidator.Purge})
+		} else {
+			s.bus.Publish(bus.Event{Type: bus.DeleteEvent, Keys: keysToInvalidate, Purge: invalidator.Purge})
+		}
 		w.WriteHeader(http.StatusOK)
 	case "PURGE":
 		if compile {
 			if strings.HasSuffix(r.RequestURI, s.GetBasePath()+"/flush") {
-				for _, current := range s.storers {
-					current.DeleteMany(".+")
-				}
-				e := s.surrogateStorage.Destruct()
-				if e != nil {
-					fmt.Printf("Error while purging the surrogate keys: %+v.", e)
-				}
-				fmt.Println("Successfully clear the cache and the surrogate keys storage.")
+				s.flush()
+				s.bus.Publish(bus.Event{Type: bus.FlushEvent})
 			} else if strings.HasSuffix(r.RequestURI, s.GetBasePath()+"/mapping") {
 				s.purgeMapping()
 			} else {
@@ -327,15 +444,30 @@ func (s *SouinAPI) HandleRequest(w http.ResponseWriter, r *http.Request) {
 				for _, current := range s.storers {
 					current.DeleteMany(submatch)
 				}
+				s.bus.Publish(bus.Event{Type: bus.PatternEvent, Pattern: submatch})
 			}
 		} else {
-			ck, surrogateKeys := s.surrogateStorage.Purge(r.Header)
+			tags, err := s.surrogateStorage.MatchTags(r.Header)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if r.Header.Get(dryRunHeader) == "true" {
+				s.writeTags(w, tags)
+				return
+			}
+
+			ck, surrogateKeys := s.surrogateStorage.PurgeMatched(tags)
+			if tags := providers.NonEmpty(surrogateKeys); len(tags) > 0 {
+				w.Header().Set("Surrogate-Key", strings.Join(tags, ", "))
+			}
 			for _, k := range ck {
 				s.BulkDelete(k, true)
 			}
 			for _, k := range surrogateKeys {
 				s.BulkDelete("SURROGATE_"+k, true)
 			}
+			s.bus.Publish(bus.Event{Type: bus.PurgeEvent, Tags: providers.EscapeWildcards(surrogateKeys), Purge: true})
 		}
 		w.WriteHeader(http.StatusNoContent)
 	default:
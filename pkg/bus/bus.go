@@ -0,0 +1,296 @@
+package bus
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/darkweak/souin/configurationtypes"
+	"github.com/darkweak/storages/core"
+	"github.com/google/uuid"
+)
+
+const (
+	// PurgeEvent purges the Tags from the surrogate index and deletes the
+	// associated cache keys with the Keys.
+	PurgeEvent = "purge"
+	// DeleteEvent deletes the Keys and their mapping when Purge is set.
+	DeleteEvent = "delete"
+	// PatternEvent deletes the keys matching the Pattern regexp.
+	PatternEvent = "pattern"
+	// FlushEvent clears the storers and the surrogate index.
+	FlushEvent = "flush"
+	// GroupEvent invalidates the Tags received in a Cache-Group-Invalidation
+	// response header.
+	GroupEvent = "group"
+
+	defaultChannel = "souin-invalidations"
+	seenRetention  = 10 * time.Minute
+	seenPruneSize  = 1024
+	dialTimeout    = 5 * time.Second
+	maxReconnect   = 30 * time.Second
+)
+
+// Event is an invalidation applied by an instance and propagated to the
+// other ones.
+type Event struct {
+	ID      string   `json:"id"`
+	Origin  string   `json:"origin"`
+	Type    string   `json:"type"`
+	Tags    []string `json:"tags,omitempty"`
+	Keys    []string `json:"keys,omitempty"`
+	Pattern string   `json:"pattern,omitempty"`
+	Purge   bool     `json:"purge,omitempty"`
+}
+
+// Transport delivers the encoded events to the other instances and the
+// events published by them to the given receiver.
+type Transport interface {
+	Publish(payload []byte) error
+	Subscribe(receive func(payload []byte)) error
+	Close() error
+}
+
+// Bus publishes the local invalidations and applies the remote ones once,
+// the events already seen or published by this instance are skipped.
+type Bus struct {
+	key       string
+	origin    string
+	transport Transport
+	logger    core.Logger
+	// refs counts the owners of the bus, guarded by busesMu.
+	refs int
+
+	mu          sync.Mutex
+	seen        map[string]time.Time
+	handlers    map[int]func(Event)
+	nextHandler int
+}
+
+var (
+	busesMu sync.Mutex
+	buses   = map[string]*Bus{}
+)
+
+// Get returns the bus of the configuration. It's created once per process so
+// the middleware and the API publish on the same bus, each caller releases it
+// with Close. It returns nil when no transport is configured.
+func Get(c configurationtypes.InvalidationBus, logger core.Logger) *Bus {
+	if c.Transport == "" {
+		return nil
+	}
+	if c.Channel == "" {
+		c.Channel = defaultChannel
+	}
+
+	id, _ := json.Marshal(c)
+	busesMu.Lock()
+	defer busesMu.Unlock()
+	if b, ok := buses[string(id)]; ok {
+		b.refs++
+
+		return b
+	}
+
+	var transport Transport
+	var err error
+	switch c.Transport {
+	case "redis":
+		transport, err = newRedisTransport(c, logger)
+	case "nats":
+		transport, err = newNatsTransport(c, logger)
+	case "http":
+		transport, err = newHTTPTransport(c, logger)
+	default:
+		logger.Errorf("Unknown invalidation bus transport %s", c.Transport)
+
+		return nil
+	}
+	if err != nil {
+		logger.Errorf("Impossible to create the %s invalidation bus: %v", c.Transport, err)
+
+		return nil
+	}
+
+	b := New(transport, logger)
+	b.key = string(id)
+	buses[b.key] = b
+
+	return b
+}
+
+// New creates a bus on the given transport and subscribes to it.
+func New(transport Transport, logger core.Logger) *Bus {
+	b := &Bus{
+		origin:    uuid.NewString(),
+		transport: transport,
+		logger:    logger,
+		refs:      1,
+		seen:      make(map[string]time.Time),
+		handlers:  make(map[int]func(Event)),
+	}
+	if err := transport.Subscribe(b.Receive); err != nil {
+		logger.Errorf("Impossible to subscribe to the invalidation bus: %v", err)
+	}
+
+	return b
+}
+
+// Handle adds a function applying the remote events, each API sharing the
+// bus registers its own. The returned function removes it.
+func (b *Bus) Handle(handler func(Event)) func() {
+	if b == nil {
+		return func() {}
+	}
+
+	b.mu.Lock()
+	id := b.nextHandler
+	b.nextHandler++
+	b.handlers[id] = handler
+	b.mu.Unlock()
+
+	return func() {
+		b.mu.Lock()
+		delete(b.handlers, id)
+		b.mu.Unlock()
+	}
+}
+
+// Publish sends the event applied locally to the other instances.
+func (b *Bus) Publish(event Event) {
+	if b == nil {
+		return
+	}
+
+	event.ID = uuid.NewString()
+	event.Origin = b.origin
+	b.markSeen(event.ID)
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	if err = b.transport.Publish(payload); err != nil {
+		b.logger.Errorf("Impossible to publish the %s invalidation event: %v", event.Type, err)
+	}
+}
+
+// Receive decodes and applies a remote event unless it was already seen.
+func (b *Bus) Receive(payload []byte) {
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil || event.ID == "" {
+		b.logger.Debugf("Ignore the invalid invalidation event %s", payload)
+
+		return
+	}
+	if event.Origin == b.origin || !b.markSeen(event.ID) {
+		return
+	}
+
+	b.mu.Lock()
+	handlers := make([]func(Event), 0, len(b.handlers))
+	for _, handler := range b.handlers {
+		handlers = append(handlers, handler)
+	}
+	b.mu.Unlock()
+
+	if len(handlers) > 0 {
+		b.logger.Debugf("Apply the %s invalidation event %s from %s", event.Type, event.ID, event.Origin)
+	}
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// markSeen returns false when the event was already seen.
+func (b *Bus) markSeen(id string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if _, ok := b.seen[id]; ok {
+		return false
+	}
+	if len(b.seen) >= seenPruneSize {
+		for seenID, at := range b.seen {
+			if now.Sub(at) > seenRetention {
+				delete(b.seen, seenID)
+			}
+		}
+	}
+	b.seen[id] = now
+
+	return true
+}
+
+// ServeHTTP receives the events sent by the peers of the http transport.
+func (b *Bus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if b == nil {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	handler, ok := b.transport.(http.Handler)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// Close releases the bus, the transport is stopped once the last owner
+// released it.
+func (b *Bus) Close() error {
+	if b == nil {
+		return nil
+	}
+
+	busesMu.Lock()
+	if b.refs == 0 {
+		busesMu.Unlock()
+
+		return nil
+	}
+	b.refs--
+	last := b.refs == 0
+	if last && buses[b.key] == b {
+		delete(buses, b.key)
+	}
+	busesMu.Unlock()
+
+	if !last {
+		return nil
+	}
+
+	return b.transport.Close()
+}
+
+// keepSubscribed runs the subscription until the transport is closed and
+// re-establishes it with an exponential backoff.
+func keepSubscribed(closed chan struct{}, logger core.Logger, address string, subscribe func() error) {
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := subscribe()
+		select {
+		case <-closed:
+			return
+		default:
+		}
+
+		if time.Since(started) > maxReconnect {
+			backoff = time.Second
+		}
+		logger.Errorf("The invalidation bus subscription to %s failed, retry in %v: %v", address, backoff, err)
+		select {
+		case <-closed:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxReconnect)
+	}
+}
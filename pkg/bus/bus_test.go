@@ -0,0 +1,179 @@
+package bus
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/darkweak/souin/configurationtypes"
+	"go.uber.org/zap"
+)
+
+type recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *recorder) handle(event Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, event)
+}
+
+func (r *recorder) wait(t *testing.T, count int) []Event {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		r.mu.Lock()
+		if len(r.events) >= count {
+			events := append([]Event{}, r.events...)
+			r.mu.Unlock()
+
+			return events
+		}
+		r.mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Expected %d events in time, %+v given.", count, r.events)
+
+	return nil
+}
+
+type memoryTransport struct {
+	published [][]byte
+	closed    int
+}
+
+func (m *memoryTransport) Publish(payload []byte) error {
+	m.published = append(m.published, payload)
+
+	return nil
+}
+
+func (*memoryTransport) Subscribe(func([]byte)) error {
+	return nil
+}
+
+func (m *memoryTransport) Close() error {
+	m.closed++
+
+	return nil
+}
+
+func TestBus(t *testing.T) {
+	transport := &memoryTransport{}
+	b := New(transport, zap.NewNop().Sugar())
+	r := &recorder{}
+	b.Handle(r.handle)
+
+	b.Publish(Event{Type: PurgeEvent, Tags: []string{"tag"}})
+	if len(transport.published) != 1 {
+		t.Fatalf("The event must be published, %d given.", len(transport.published))
+	}
+	b.Receive(transport.published[0])
+	if len(r.events) != 0 {
+		t.Errorf("The bus must not apply its own events, %+v given.", r.events)
+	}
+
+	other := &recorder{}
+	remove := b.Handle(other.handle)
+	remote, _ := json.Marshal(Event{ID: "remote", Origin: "other", Type: FlushEvent})
+	b.Receive(remote)
+	b.Receive(remote)
+	b.Receive([]byte("invalid"))
+	if len(r.events) != 1 || r.events[0].Type != FlushEvent || len(other.events) != 1 {
+		t.Errorf("The remote event must be applied once by each handler, %+v and %+v given.", r.events, other.events)
+	}
+	remove()
+	remote, _ = json.Marshal(Event{ID: "removed", Origin: "other", Type: FlushEvent})
+	b.Receive(remote)
+	if len(r.events) != 2 || len(other.events) != 1 {
+		t.Errorf("The removed handler must not apply the remote events, %+v and %+v given.", r.events, other.events)
+	}
+	_ = b.Close()
+	_ = b.Close()
+	if transport.closed != 1 {
+		t.Errorf("The transport must be closed once, %d given.", transport.closed)
+	}
+
+	var nilBus *Bus
+	nilBus.Publish(Event{Type: FlushEvent})
+	nilBus.Handle(r.handle)
+	if Get(configurationtypes.InvalidationBus{}, zap.NewNop().Sugar()) != nil {
+		t.Error("The bus must be nil without transport.")
+	}
+	shared := configurationtypes.InvalidationBus{Transport: "http", Secret: "secret"}
+	a, b := Get(shared, zap.NewNop().Sugar()), Get(shared, zap.NewNop().Sugar())
+	if a == nil || a != b {
+		t.Error("The same configuration must share the bus.")
+	}
+	_ = a.Close()
+	if Get(shared, zap.NewNop().Sugar()) != b {
+		t.Error("The bus must be shared until its last owner released it.")
+	}
+	_ = b.Close()
+	_ = b.Close()
+	if Get(shared, zap.NewNop().Sugar()) == b {
+		t.Error("The released bus must not be shared anymore.")
+	}
+	for _, c := range []configurationtypes.InvalidationBus{
+		{Transport: "http"},
+		{Transport: "redis", URL: "rediss://redis:6380"},
+		{Transport: "nats", URL: "tls://nats:4222"},
+	} {
+		if Get(c, zap.NewNop().Sugar()) != nil {
+			t.Errorf("The unsupported %s bus %+v must be refused.", c.Transport, c)
+		}
+	}
+}
+
+func TestHTTPTransport(t *testing.T) {
+	r := &recorder{}
+	var receiver *Bus
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+		receiver.ServeHTTP(w, rq)
+	}))
+	defer server.Close()
+
+	c := configurationtypes.InvalidationBus{Transport: "http", Peers: []string{server.URL}, Secret: "secret"}
+	receiverTransport, _ := newHTTPTransport(configurationtypes.InvalidationBus{Secret: "secret"}, zap.NewNop().Sugar())
+	receiver = New(receiverTransport, zap.NewNop().Sugar())
+	receiver.Handle(r.handle)
+	senderTransport, _ := newHTTPTransport(c, zap.NewNop().Sugar())
+	sender := New(senderTransport, zap.NewNop().Sugar())
+
+	sender.Publish(Event{Type: DeleteEvent, Keys: []string{"GET-http-example.com-/"}, Purge: true})
+	if events := r.wait(t, 1); events[0].Type != DeleteEvent || events[0].Keys[0] != "GET-http-example.com-/" || !events[0].Purge {
+		t.Errorf("Unexpected received event %+v.", events[0])
+	}
+
+	res, _ := http.Post(server.URL, "application/json", strings.NewReader(`{"id":"forged","origin":"other","type":"flush"}`))
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("The unsigned event must be rejected, %d given.", res.StatusCode)
+	}
+
+	payload := []byte(`{"id":"replayed","origin":"other","type":"flush"}`)
+	for _, sent := range []time.Time{time.Now().Add(-maxClockSkew - time.Minute), time.Now().Add(maxClockSkew + time.Minute)} {
+		timestamp := strconv.FormatInt(sent.Unix(), 10)
+		rq, _ := http.NewRequest(http.MethodPost, server.URL, bytes.NewReader(payload))
+		rq.Header.Set(TimestampHeader, timestamp)
+		rq.Header.Set(SignatureHeader, senderTransport.sign(timestamp, payload))
+		if res, _ = http.DefaultClient.Do(rq); res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("The event sent at %v must be rejected, %d given.", sent, res.StatusCode)
+		}
+	}
+	rq, _ := http.NewRequest(http.MethodPost, server.URL, bytes.NewReader(payload))
+	rq.Header.Set(TimestampHeader, strconv.FormatInt(time.Now().Unix(), 10))
+	rq.Header.Set(SignatureHeader, senderTransport.sign(strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10), payload))
+	if res, _ = http.DefaultClient.Do(rq); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("The event with a timestamp other than the signed one must be rejected, %d given.", res.StatusCode)
+	}
+}
@@ -0,0 +1,147 @@
+package bus
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/darkweak/souin/configurationtypes"
+	"github.com/darkweak/storages/core"
+)
+
+const (
+	// SignatureHeader carries the hex HMAC-SHA256 of the timestamp and the
+	// event signed with the shared secret.
+	SignatureHeader = "Souin-Bus-Signature"
+	// TimestampHeader carries the unix time the event was sent at.
+	TimestampHeader = "Souin-Bus-Timestamp"
+
+	httpTimeout    = 5 * time.Second
+	maxHTTPPayload = 1 << 20
+	// maxClockSkew bounds the age of the accepted events, the ones replayed
+	// within it are skipped as already seen.
+	maxClockSkew = 5 * time.Minute
+)
+
+// httpTransport sends the events to each peer bus endpoint and receives the
+// ones sent by the peers through the API. The events are signed with the
+// shared secret, required as the endpoint is exposed with the API, and
+// rejected once older than maxClockSkew.
+type httpTransport struct {
+	peers   []string
+	secret  []byte
+	client  *http.Client
+	logger  core.Logger
+	receive func([]byte)
+}
+
+func newHTTPTransport(c configurationtypes.InvalidationBus, logger core.Logger) (*httpTransport, error) {
+	if c.Secret == "" {
+		return nil, errors.New("the http transport requires a secret to sign the events")
+	}
+
+	return &httpTransport{
+		peers:  c.Peers,
+		secret: []byte(c.Secret),
+		client: &http.Client{Timeout: httpTimeout},
+		logger: logger,
+	}, nil
+}
+
+func (t *httpTransport) sign(timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, t.secret)
+	_, _ = mac.Write([]byte(timestamp + "."))
+	_, _ = mac.Write(payload)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Publish sends the event to the peers in background, an unreachable peer
+// doesn't delay the others.
+func (t *httpTransport) Publish(payload []byte) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := t.sign(timestamp, payload)
+	for _, peer := range t.peers {
+		go func(peer string) {
+			if err := t.send(peer, payload, timestamp, signature); err != nil {
+				t.logger.Errorf("Impossible to send the invalidation event to %s: %v", peer, err)
+			}
+		}(peer)
+	}
+
+	return nil
+}
+
+func (t *httpTransport) send(peer string, payload []byte, timestamp string, signature string) error {
+	rq, err := http.NewRequest(http.MethodPost, peer, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	rq.Header.Set("Content-Type", "application/json")
+	rq.Header.Set(TimestampHeader, timestamp)
+	rq.Header.Set(SignatureHeader, signature)
+
+	res, err := t.client.Do(rq)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+func (t *httpTransport) Subscribe(receive func([]byte)) error {
+	t.receive = receive
+
+	return nil
+}
+
+// ServeHTTP verifies the signature and the age of the received event and
+// applies it.
+func (t *httpTransport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(r.Body, maxHTTPPayload))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+	timestamp := r.Header.Get(TimestampHeader)
+	if len(t.secret) == 0 || !hmac.Equal([]byte(r.Header.Get(SignatureHeader)), []byte(t.sign(timestamp, payload))) {
+		w.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if age := time.Since(time.Unix(sent, 0)); err != nil || age > maxClockSkew || age < -maxClockSkew {
+		w.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	t.receive(payload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (*httpTransport) Close() error {
+	return nil
+}
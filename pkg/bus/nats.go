@@ -0,0 +1,236 @@
+package bus
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/darkweak/souin/configurationtypes"
+	"github.com/darkweak/storages/core"
+)
+
+const natsSubscriptionID = "1"
+
+// natsTransport publishes the events on a NATS subject. It speaks the NATS
+// client protocol directly with one connection to publish and one to
+// subscribe, both re-established on failure. It connects in plain TCP and
+// authenticates with the user and password or the token of the URL only, TLS,
+// NKeys and credentials files aren't supported.
+type natsTransport struct {
+	address  string
+	user     string
+	password string
+	token    string
+	subject  string
+	logger   core.Logger
+
+	mu     sync.Mutex
+	conn   net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+type natsConnect struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	Name     string `json:"name"`
+	Lang     string `json:"lang"`
+	Version  string `json:"version"`
+	Protocol int    `json:"protocol"`
+	User     string `json:"user,omitempty"`
+	Pass     string `json:"pass,omitempty"`
+	Token    string `json:"auth_token,omitempty"`
+}
+
+func newNatsTransport(c configurationtypes.InvalidationBus, logger core.Logger) (*natsTransport, error) {
+	t := &natsTransport{
+		address: c.URL,
+		subject: c.Channel,
+		logger:  logger,
+		closed:  make(chan struct{}),
+	}
+
+	if u, err := url.Parse(c.URL); err == nil && u.Host != "" {
+		if u.Scheme != "nats" {
+			return nil, fmt.Errorf("unsupported nats scheme %s, only plain nats:// connections are supported", u.Scheme)
+		}
+		t.address = u.Host
+		if u.User != nil {
+			if password, ok := u.User.Password(); ok {
+				t.user, t.password = u.User.Username(), password
+			} else {
+				t.token = u.User.Username()
+			}
+		}
+	}
+	if t.address == "" {
+		t.address = "127.0.0.1:4222"
+	}
+
+	return t, nil
+}
+
+// dial opens a connection, sends the CONNECT and waits for the PONG
+// confirming it was accepted.
+func (t *natsTransport) dial() (net.Conn, *bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", t.address, dialTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	if line, err := reader.ReadString('\n'); err != nil || !strings.HasPrefix(line, "INFO ") {
+		_ = conn.Close()
+
+		return nil, nil, fmt.Errorf("unexpected NATS greeting %q: %v", line, err)
+	}
+
+	connect, _ := json.Marshal(natsConnect{
+		Name:     "souin",
+		Lang:     "go",
+		Version:  "1",
+		Protocol: 1,
+		User:     t.user,
+		Pass:     t.password,
+		Token:    t.token,
+	})
+	if _, err = fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", connect); err == nil {
+		var line string
+		if line, err = reader.ReadString('\n'); err == nil && !strings.HasPrefix(line, "PONG") {
+			err = errors.New(strings.TrimSpace(line))
+		}
+	}
+	if err != nil {
+		_ = conn.Close()
+
+		return nil, nil, err
+	}
+
+	return conn, reader, nil
+}
+
+func (t *natsTransport) Publish(payload []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if t.conn == nil {
+			var reader *bufio.Reader
+			if t.conn, reader, err = t.dial(); err != nil {
+				return err
+			}
+			go t.discard(t.conn, reader)
+		}
+
+		if _, err = fmt.Fprintf(t.conn, "PUB %s %d\r\n%s\r\n", t.subject, len(payload), payload); err == nil {
+			return nil
+		}
+
+		_ = t.conn.Close()
+		t.conn = nil
+	}
+
+	return err
+}
+
+// discard answers the server PINGs on the publishing connection.
+func (t *natsTransport) discard(conn net.Conn, reader *bufio.Reader) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.mu.Lock()
+			if t.conn == conn {
+				_ = conn.Close()
+				t.conn = nil
+			}
+			t.mu.Unlock()
+
+			return
+		}
+		if strings.HasPrefix(line, "PING") {
+			t.mu.Lock()
+			_, _ = io.WriteString(conn, "PONG\r\n")
+			t.mu.Unlock()
+		}
+	}
+}
+
+func (t *natsTransport) Subscribe(receive func([]byte)) error {
+	go keepSubscribed(t.closed, t.logger, t.address, func() error {
+		return t.subscribe(receive)
+	})
+
+	return nil
+}
+
+func (t *natsTransport) subscribe(receive func([]byte)) error {
+	conn, reader, err := t.dial()
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-t.closed:
+		case <-done:
+		}
+		_ = conn.Close()
+	}()
+
+	if _, err = fmt.Fprintf(conn, "SUB %s %s\r\n", t.subject, natsSubscriptionID); err != nil {
+		return err
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(line, "MSG "):
+			// MSG <subject> <sid> [reply-to] <size>
+			fields := strings.Fields(line)
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil || size < 0 {
+				return fmt.Errorf("unexpected NATS message %q", line)
+			}
+			payload := make([]byte, size+2)
+			if _, err = io.ReadFull(reader, payload); err != nil {
+				return err
+			}
+			receive(payload[:size])
+		case strings.HasPrefix(line, "PING"):
+			if _, err = io.WriteString(conn, "PONG\r\n"); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New(line)
+		}
+	}
+}
+
+func (t *natsTransport) Close() error {
+	t.once.Do(func() {
+		close(t.closed)
+	})
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn != nil {
+		_ = t.conn.Close()
+		t.conn = nil
+	}
+
+	return nil
+}
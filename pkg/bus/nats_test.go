@@ -0,0 +1,143 @@
+package bus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/darkweak/souin/configurationtypes"
+	"go.uber.org/zap"
+)
+
+type natsSubscriber struct {
+	conn net.Conn
+	sid  string
+}
+
+// natsStandIn is a minimal NATS server supporting CONNECT, PING, SUB and PUB.
+type natsStandIn struct {
+	listener    net.Listener
+	mu          sync.Mutex
+	subscribers map[string][]natsSubscriber
+	connects    []string
+}
+
+func newNatsStandIn(t *testing.T) *natsStandIn {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &natsStandIn{listener: listener, subscribers: map[string][]natsSubscriber{}}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *natsStandIn) serve(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	_, _ = io.WriteString(conn, "INFO {\"server_id\":\"stand-in\"}\r\n")
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		s.mu.Lock()
+		switch fields[0] {
+		case "CONNECT":
+			s.connects = append(s.connects, strings.TrimSpace(strings.TrimPrefix(line, "CONNECT")))
+		case "PING":
+			_, _ = io.WriteString(conn, "PONG\r\n")
+		case "SUB":
+			s.subscribers[fields[1]] = append(s.subscribers[fields[1]], natsSubscriber{conn: conn, sid: fields[2]})
+		case "PUB":
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			s.mu.Unlock()
+			_, _ = io.ReadFull(reader, payload)
+			s.mu.Lock()
+			for _, subscriber := range s.subscribers[fields[1]] {
+				_, _ = fmt.Fprintf(subscriber.conn, "MSG %s %s %d\r\n%s", fields[1], subscriber.sid, size, payload)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *natsStandIn) waitSubscribers(t *testing.T, subject string, count int) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		subscribed := len(s.subscribers[subject])
+		s.mu.Unlock()
+		if subscribed >= count {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Expected %d subscribers on %s in time.", count, subject)
+}
+
+func TestNatsTransport(t *testing.T) {
+	server := newNatsStandIn(t)
+	c := configurationtypes.InvalidationBus{Transport: "nats", URL: "nats://user:password@" + server.listener.Addr().String(), Channel: "invalidations"}
+
+	firstTransport, _ := newNatsTransport(c, zap.NewNop().Sugar())
+	secondTransport, _ := newNatsTransport(c, zap.NewNop().Sugar())
+	first := New(firstTransport, zap.NewNop().Sugar())
+	second := New(secondTransport, zap.NewNop().Sugar())
+	defer func() {
+		_ = first.Close()
+		_ = second.Close()
+	}()
+	firstEvents, secondEvents := &recorder{}, &recorder{}
+	first.Handle(firstEvents.handle)
+	second.Handle(secondEvents.handle)
+	server.waitSubscribers(t, "invalidations", 2)
+
+	first.Publish(Event{Type: GroupEvent, Tags: []string{"group"}})
+	if events := secondEvents.wait(t, 1); events[0].Type != GroupEvent || events[0].Tags[0] != "group" {
+		t.Errorf("Unexpected received event %+v.", events[0])
+	}
+
+	second.Publish(Event{Type: FlushEvent})
+	if events := firstEvents.wait(t, 1); len(events) != 1 || events[0].Type != FlushEvent {
+		t.Errorf("The publisher must not apply its own event, %+v given.", events)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.connects) == 0 || !strings.Contains(server.connects[0], `"user":"user","pass":"password"`) {
+		t.Errorf("The transport must authenticate, %v given.", server.connects)
+	}
+}
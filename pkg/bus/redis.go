@@ -0,0 +1,239 @@
+package bus
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/darkweak/souin/configurationtypes"
+	"github.com/darkweak/storages/core"
+)
+
+const redisDefaultDB = "0"
+
+// redisTransport publishes the events on a Redis pub/sub channel. It speaks
+// the RESP protocol directly with one connection to publish and one to
+// subscribe, both re-established on failure. It connects in plain TCP to a
+// single server, TLS, Cluster and Sentinel aren't supported.
+type redisTransport struct {
+	address  string
+	username string
+	password string
+	db       string
+	channel  string
+	logger   core.Logger
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newRedisTransport(c configurationtypes.InvalidationBus, logger core.Logger) (*redisTransport, error) {
+	t := &redisTransport{
+		address: c.URL,
+		db:      redisDefaultDB,
+		channel: c.Channel,
+		logger:  logger,
+		closed:  make(chan struct{}),
+	}
+
+	if u, err := url.Parse(c.URL); err == nil && u.Host != "" {
+		if u.Scheme != "redis" {
+			return nil, fmt.Errorf("unsupported redis scheme %s, only plain redis:// connections are supported", u.Scheme)
+		}
+		t.address = u.Host
+		if u.User != nil {
+			t.username = u.User.Username()
+			t.password, _ = u.User.Password()
+		}
+		if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+			t.db = db
+		}
+	}
+	if t.address == "" {
+		t.address = "127.0.0.1:6379"
+	}
+
+	return t, nil
+}
+
+func writeRESPCommand(w *bufio.Writer, args ...string) error {
+	_, _ = fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		_, _ = fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	return w.Flush()
+}
+
+// readRESP reads a reply as string, int64, []interface{} or nil.
+func readRESP(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty RESP reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+
+		return string(buf[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, size)
+		for i := range items {
+			if items[i], err = readRESP(r); err != nil {
+				return nil, err
+			}
+		}
+
+		return items, nil
+	}
+
+	return nil, fmt.Errorf("unexpected RESP reply %q", line)
+}
+
+// dial opens an authenticated connection on the configured database.
+func (t *redisTransport) dial() (net.Conn, *bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", t.address, dialTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	commands := [][]string{}
+	if t.password != "" {
+		if t.username != "" {
+			commands = append(commands, []string{"AUTH", t.username, t.password})
+		} else {
+			commands = append(commands, []string{"AUTH", t.password})
+		}
+	}
+	if t.db != redisDefaultDB {
+		commands = append(commands, []string{"SELECT", t.db})
+	}
+	for _, command := range commands {
+		if err = writeRESPCommand(writer, command...); err == nil {
+			_, err = readRESP(reader)
+		}
+		if err != nil {
+			_ = conn.Close()
+
+			return nil, nil, err
+		}
+	}
+
+	return conn, reader, nil
+}
+
+func (t *redisTransport) Publish(payload []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if t.conn == nil {
+			if t.conn, t.reader, err = t.dial(); err != nil {
+				return err
+			}
+		}
+
+		if err = writeRESPCommand(bufio.NewWriter(t.conn), "PUBLISH", t.channel, string(payload)); err == nil {
+			if _, err = readRESP(t.reader); err == nil {
+				return nil
+			}
+		}
+
+		_ = t.conn.Close()
+		t.conn = nil
+	}
+
+	return err
+}
+
+func (t *redisTransport) Subscribe(receive func([]byte)) error {
+	go keepSubscribed(t.closed, t.logger, t.address, func() error {
+		return t.subscribe(receive)
+	})
+
+	return nil
+}
+
+func (t *redisTransport) subscribe(receive func([]byte)) error {
+	conn, reader, err := t.dial()
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-t.closed:
+		case <-done:
+		}
+		_ = conn.Close()
+	}()
+
+	if err = writeRESPCommand(bufio.NewWriter(conn), "SUBSCRIBE", t.channel); err != nil {
+		return err
+	}
+
+	for {
+		reply, err := readRESP(reader)
+		if err != nil {
+			return err
+		}
+
+		items, ok := reply.([]interface{})
+		if !ok || len(items) != 3 || items[0] != "message" {
+			continue
+		}
+		if payload, ok := items[2].(string); ok {
+			receive([]byte(payload))
+		}
+	}
+}
+
+func (t *redisTransport) Close() error {
+	t.once.Do(func() {
+		close(t.closed)
+	})
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn != nil {
+		_ = t.conn.Close()
+		t.conn = nil
+	}
+
+	return nil
+}
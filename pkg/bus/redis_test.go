@@ -0,0 +1,138 @@
+package bus
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/darkweak/souin/configurationtypes"
+	"go.uber.org/zap"
+)
+
+// redisStandIn is a minimal Redis server supporting AUTH, SUBSCRIBE and
+// PUBLISH.
+type redisStandIn struct {
+	listener    net.Listener
+	mu          sync.Mutex
+	subscribers map[string][]net.Conn
+	auth        []string
+}
+
+func newRedisStandIn(t *testing.T) *redisStandIn {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &redisStandIn{listener: listener, subscribers: map[string][]net.Conn{}}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *redisStandIn) serve(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	for {
+		reply, err := readRESP(reader)
+		if err != nil {
+			return
+		}
+		items, _ := reply.([]interface{})
+		args := make([]string, 0, len(items))
+		for _, item := range items {
+			args = append(args, item.(string))
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		s.mu.Lock()
+		switch args[0] {
+		case "AUTH":
+			s.auth = args[1:]
+			_, _ = writer.WriteString("+OK\r\n")
+		case "SUBSCRIBE":
+			s.subscribers[args[1]] = append(s.subscribers[args[1]], conn)
+			_, _ = writer.WriteString("*3\r\n$9\r\nsubscribe\r\n$" + strconv.Itoa(len(args[1])) + "\r\n" + args[1] + "\r\n:1\r\n")
+		case "PUBLISH":
+			for _, subscriber := range s.subscribers[args[1]] {
+				w := bufio.NewWriter(subscriber)
+				_ = writeRESPCommand(w, "message", args[1], args[2])
+			}
+			_, _ = writer.WriteString(":" + strconv.Itoa(len(s.subscribers[args[1]])) + "\r\n")
+		}
+		s.mu.Unlock()
+		_ = writer.Flush()
+	}
+}
+
+func (s *redisStandIn) waitSubscribers(t *testing.T, channel string, count int) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		subscribed := len(s.subscribers[channel])
+		s.mu.Unlock()
+		if subscribed >= count {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Expected %d subscribers on %s in time.", count, channel)
+}
+
+func TestRedisTransport(t *testing.T) {
+	server := newRedisStandIn(t)
+	c := configurationtypes.InvalidationBus{Transport: "redis", URL: "redis://:password@" + server.listener.Addr().String(), Channel: "invalidations"}
+
+	firstTransport, _ := newRedisTransport(c, zap.NewNop().Sugar())
+	secondTransport, _ := newRedisTransport(c, zap.NewNop().Sugar())
+	first := New(firstTransport, zap.NewNop().Sugar())
+	second := New(secondTransport, zap.NewNop().Sugar())
+	defer func() {
+		_ = first.Close()
+		_ = second.Close()
+	}()
+	firstEvents, secondEvents := &recorder{}, &recorder{}
+	first.Handle(firstEvents.handle)
+	second.Handle(secondEvents.handle)
+	server.waitSubscribers(t, "invalidations", 2)
+
+	first.Publish(Event{Type: PurgeEvent, Tags: []string{"first", "second"}})
+	if events := secondEvents.wait(t, 1); events[0].Type != PurgeEvent || len(events[0].Tags) != 2 {
+		t.Errorf("Unexpected received event %+v.", events[0])
+	}
+
+	second.Publish(Event{Type: PatternEvent, Pattern: ".+"})
+	if events := firstEvents.wait(t, 1); len(events) != 1 || events[0].Pattern != ".+" {
+		t.Errorf("The publisher must not apply its own event, %+v given.", events)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.auth) != 1 || server.auth[0] != "password" {
+		t.Errorf("The transport must authenticate, %v given.", server.auth)
+	}
+}
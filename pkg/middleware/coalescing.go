@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/darkweak/souin/pkg/api/prometheus"
+)
+
+var (
+	errCoalescingWaitExceeded  = errors.New("the coalescing wait is exceeded")
+	errCoalescingLeaderAborted = errors.New("the coalescing leader aborted")
+)
+
+// coalescingStaleDetail returns the Cache-Status detail of the stale response
+// served instead of the coalesced one, empty when the error doesn't allow it.
+func coalescingStaleDetail(err error) string {
+	switch err {
+	case errCoalescingWaitExceeded:
+		return "COALESCING-WAIT-EXCEEDED"
+	case errCoalescingLeaderAborted:
+		return "COALESCING-LEADER-ABORTED"
+	}
+
+	return ""
+}
+
+type coalescedCall struct {
+	done    chan struct{}
+	value   interface{}
+	err     error
+	waiters int
+}
+
+// coalescer runs once the concurrent calls sharing the same key. Unlike
+// singleflight, the followers stop waiting for the leader once the given
+// wait is exceeded.
+type coalescer struct {
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+// Do runs fn for the first caller of the key and shares its result with the
+// concurrent callers. A follower gets errCoalescingWaitExceeded when the
+// leader didn't complete within the wait (0 waits indefinitely), and
+// errCoalescingLeaderAborted when the leader panicked.
+func (c *coalescer) Do(key string, wait time.Duration, fn func() (interface{}, error)) (value interface{}, err error, shared bool) {
+	c.mu.Lock()
+	if c.calls == nil {
+		c.calls = make(map[string]*coalescedCall)
+	}
+	if call, ok := c.calls[key]; ok {
+		call.waiters++
+		c.mu.Unlock()
+
+		return c.wait(call, wait)
+	}
+
+	call := &coalescedCall{done: make(chan struct{})}
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	c.run(key, call, fn)
+
+	return call.value, call.err, false
+}
+
+func (c *coalescer) run(key string, call *coalescedCall, fn func() (interface{}, error)) {
+	completed := false
+	defer func() {
+		if !completed {
+			// The panic keeps propagating to the leader caller.
+			call.value, call.err = nil, errCoalescingLeaderAborted
+		}
+
+		c.mu.Lock()
+		delete(c.calls, key)
+		waiters := call.waiters
+		c.mu.Unlock()
+		close(call.done)
+
+		if waiters > 0 {
+			prometheus.Add(prometheus.CoalescingWaiters, float64(waiters))
+		}
+	}()
+
+	call.value, call.err = fn()
+	completed = true
+}
+
+func (*coalescer) wait(call *coalescedCall, wait time.Duration) (interface{}, error, bool) {
+	var timeout <-chan time.Time
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-call.done:
+		if call.err != errCoalescingLeaderAborted {
+			prometheus.Increment(prometheus.CoalescedResponseCounter)
+		}
+
+		return call.value, call.err, true
+	case <-timeout:
+		prometheus.Increment(prometheus.CoalescingTimeoutCounter)
+
+		return nil, errCoalescingWaitExceeded, true
+	}
+}
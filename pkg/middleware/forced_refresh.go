@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/darkweak/souin/configurationtypes"
+	"github.com/darkweak/storages/core"
+)
+
+const (
+	forcedRefreshHeader     = "Souin-Refresh"
+	forcedRefreshQueryParam = "souin_refresh"
+)
+
+// forcedRefresh checks the Souin-Refresh header and query parameter against
+// the configured secret and allowed IPs.
+type forcedRefresh struct {
+	secret        []byte
+	allowed       []*net.IPNet
+	purgeVariants bool
+}
+
+// newForcedRefresh returns nil when neither a secret nor an allowed IP is
+// configured.
+func newForcedRefresh(c configurationtypes.ForcedRefresh, logger core.Logger) *forcedRefresh {
+	f := &forcedRefresh{
+		secret:        []byte(c.Secret),
+		purgeVariants: c.PurgeVariants,
+	}
+	for _, allowed := range c.AllowedIPs {
+		if !strings.Contains(allowed, "/") {
+			if ip := net.ParseIP(allowed); ip != nil && ip.To4() != nil {
+				allowed += "/32"
+			} else {
+				allowed += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(allowed)
+		if err != nil {
+			logger.Warnf("Ignore the invalid forced refresh allowed IP %s: %v", allowed, err)
+			continue
+		}
+		f.allowed = append(f.allowed, network)
+	}
+
+	if len(f.secret) == 0 && len(f.allowed) == 0 {
+		return nil
+	}
+
+	return f
+}
+
+func signRefresh(secret []byte, expires int64, host, path string) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = fmt.Fprintf(mac, "%d:%s%s", expires, host, path)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewRefreshToken returns a Souin-Refresh token for the host and path signed
+// with the secret, valid until expires.
+func NewRefreshToken(secret, host, path string, expires time.Time) string {
+	return fmt.Sprintf("%d.%s", expires.Unix(), signRefresh([]byte(secret), expires.Unix(), host, path))
+}
+
+func (f *forcedRefresh) validToken(rq *http.Request, token string) bool {
+	if len(f.secret) == 0 {
+		return false
+	}
+
+	rawExpires, signature, found := strings.Cut(token, ".")
+	if !found {
+		return false
+	}
+
+	expires, err := strconv.ParseInt(rawExpires, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+
+	return hmac.Equal([]byte(signature), []byte(signRefresh(f.secret, expires, rq.Host, rq.URL.Path)))
+}
+
+func (f *forcedRefresh) allowedIP(rq *http.Request) bool {
+	host, _, err := net.SplitHostPort(rq.RemoteAddr)
+	if err != nil {
+		host = rq.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range f.allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authorize removes the Souin-Refresh header and query parameter from the
+// request to keep them out of the cache key and the upstream request. It
+// returns true when the refresh is authorized.
+func (f *forcedRefresh) authorize(rq *http.Request) (*http.Request, bool) {
+	if f == nil {
+		return rq, false
+	}
+
+	token := rq.Header.Get(forcedRefreshHeader)
+	query := rq.URL.Query()
+	if token == "" {
+		token = query.Get(forcedRefreshQueryParam)
+	}
+	if token == "" {
+		return rq, false
+	}
+
+	rq = rq.Clone(rq.Context())
+	rq.Header.Del(forcedRefreshHeader)
+	if query.Has(forcedRefreshQueryParam) {
+		query.Del(forcedRefreshQueryParam)
+		rq.URL.RawQuery = query.Encode()
+		rq.RequestURI = rq.URL.RequestURI()
+	}
+
+	return rq, f.validToken(rq, token) || f.allowedIP(rq)
+}
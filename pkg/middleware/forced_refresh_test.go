@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/darkweak/souin/configurationtypes"
+	"go.uber.org/zap"
+)
+
+func TestForcedRefreshAuthorize(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	if newForcedRefresh(configurationtypes.ForcedRefresh{}, logger) != nil {
+		t.Fatal("The forced refresh must be disabled without secret nor allowed IP.")
+	}
+
+	f := newForcedRefresh(configurationtypes.ForcedRefresh{
+		Secret:     "secret",
+		AllowedIPs: []string{"10.0.0.0/8", "::1", "invalid"},
+	}, logger)
+	if len(f.allowed) != 2 {
+		t.Fatalf("The invalid allowed IP must be ignored, %d networks given.", len(f.allowed))
+	}
+
+	valid := NewRefreshToken("secret", "example.com", "/page", time.Now().Add(time.Minute))
+	for name, tc := range map[string]struct {
+		target, token, remote string
+		expected              bool
+	}{
+		"valid token":          {target: "http://example.com/page", token: valid, expected: true},
+		"valid query token":    {target: "http://example.com/page?a=b&souin_refresh=" + url.QueryEscape(valid), expected: true},
+		"other path":           {target: "http://example.com/other", token: valid},
+		"other secret":         {target: "http://example.com/page", token: NewRefreshToken("other", "example.com", "/page", time.Now().Add(time.Minute))},
+		"expired token":        {target: "http://example.com/page", token: NewRefreshToken("secret", "example.com", "/page", time.Now().Add(-time.Minute))},
+		"malformed token":      {target: "http://example.com/page", token: "malformed"},
+		"allowed network":      {target: "http://example.com/page", token: "1", remote: "10.1.2.3:1234", expected: true},
+		"allowed IP":           {target: "http://example.com/page", token: "1", remote: "[::1]:1234", expected: true},
+		"not allowed IP":       {target: "http://example.com/page", token: "1", remote: "192.0.2.1:1234"},
+		"missing refresh flag": {target: "http://example.com/page", remote: "10.1.2.3:1234"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rq := httptest.NewRequest(http.MethodGet, tc.target, nil)
+			if tc.token != "" {
+				rq.Header.Set("Souin-Refresh", tc.token)
+			}
+			if tc.remote != "" {
+				rq.RemoteAddr = tc.remote
+			}
+
+			authorized, ok := f.authorize(rq)
+			if ok != tc.expected {
+				t.Errorf("Expected the refresh authorization to be %v, %v given.", tc.expected, ok)
+			}
+			if authorized.Header.Get("Souin-Refresh") != "" || authorized.URL.Query().Has("souin_refresh") || strings.Contains(authorized.RequestURI, "souin_refresh") {
+				t.Errorf("The refresh token must be removed from the request, %s given.", authorized.RequestURI)
+			}
+		})
+	}
+}
+
+func TestForcedRefresh(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.DefaultCache.Mode = "bypass_request"
+	cfg.DefaultCache.ForcedRefresh = configurationtypes.ForcedRefresh{
+		Secret:        "secret",
+		PurgeVariants: true,
+	}
+	handler := NewHTTPCacheHandler(cfg)
+	for _, storer := range handler.Storers {
+		_ = storer.Reset()
+	}
+
+	body := "FIRST"
+	next := func(w http.ResponseWriter, r *http.Request) error {
+		if r.Header.Get("Souin-Refresh") != "" || r.URL.Query().Has("souin_refresh") {
+			t.Error("The refresh token must not be sent to the upstream.")
+		}
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		_, _ = w.Write([]byte(body + "-" + r.Header.Get("Accept-Language")))
+
+		return nil
+	}
+	serve := func(target, language string, headers map[string]string) *httptest.ResponseRecorder {
+		rq := httptest.NewRequest(http.MethodGet, target, nil)
+		rq.Header.Set("Accept-Language", language)
+		for name, value := range headers {
+			rq.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		_ = handler.ServeHTTP(rec, rq, next)
+
+		return rec
+	}
+
+	serve("http://example.com/refresh", "en", nil)
+	serve("http://example.com/refresh", "fr", nil)
+	body = "SECOND"
+
+	if rec := serve("http://example.com/refresh", "en", map[string]string{"Cache-Control": "no-cache"}); rec.Body.String() != "FIRST-en" {
+		t.Errorf("The no-cache request directive must be ignored in bypass_request mode, %q given.", rec.Body.String())
+	}
+	if rec := serve("http://example.com/refresh", "en", map[string]string{"Souin-Refresh": "invalid"}); rec.Body.String() != "FIRST-en" {
+		t.Errorf("The invalid refresh token must be ignored, %q given.", rec.Body.String())
+	}
+
+	token := NewRefreshToken("secret", "example.com", "/refresh", time.Now().Add(time.Minute))
+	rec := serve("http://example.com/refresh?souin_refresh="+url.QueryEscape(token), "en", nil)
+	if rec.Body.String() != "SECOND-en" || !strings.Contains(rec.Header().Get("Cache-Status"), "fwd=request") {
+		t.Errorf("The authorized refresh must skip the lookup, %q given with Cache-Status %q.", rec.Body.String(), rec.Header().Get("Cache-Status"))
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	body = "THIRD"
+	if rec = serve("http://example.com/refresh", "en", nil); rec.Body.String() != "SECOND-en" {
+		t.Errorf("The refreshed response must be stored, %q given.", rec.Body.String())
+	}
+	if rec = serve("http://example.com/refresh", "fr", nil); rec.Body.String() != "THIRD-fr" {
+		t.Errorf("The other variants must be purged, %q given.", rec.Body.String())
+	}
+}
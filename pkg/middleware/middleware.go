@@ -7,10 +7,14 @@ import (
 	"fmt"
 	"io"
 	"maps"
+	"math"
+	"math/rand/v2"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -24,7 +28,9 @@ import (
 	"github.com/darkweak/souin/helpers"
 	"github.com/darkweak/souin/pkg/api"
 	"github.com/darkweak/souin/pkg/api/prometheus"
+	"github.com/darkweak/souin/pkg/bus"
 	"github.com/darkweak/souin/pkg/rfc"
+	"github.com/darkweak/souin/pkg/rules"
 	"github.com/darkweak/souin/pkg/storage"
 	"github.com/darkweak/souin/pkg/storage/types"
 	"github.com/darkweak/souin/pkg/surrogate"
@@ -34,7 +40,6 @@ import (
 	"github.com/pquerna/cachecontrol/cacheobject"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
-	"golang.org/x/sync/singleflight"
 )
 
 func reorderStorers(storers []types.Storer, expectedStorers []string) []types.Storer {
@@ -187,7 +192,6 @@ func NewHTTPCacheHandler(c configurationtypes.AbstractConfigurationInterface) *S
 	}
 
 	c.GetLogger().Debugf("Storer initialized: %#v.", storers)
-	regexpUrls := helpers.InitializeRegexp(c)
 	surrogateStorage := surrogate.InitializeSurrogate(c, fmt.Sprintf("%s-%s", storers[0].Name(), storers[0].Uuid()))
 	c.GetLogger().Debug("Surrogate storage initialized.")
 	var excludedRegexp *regexp.Regexp = nil
@@ -203,10 +207,23 @@ func NewHTTPCacheHandler(c configurationtypes.AbstractConfigurationInterface) *S
 			return new(bytes.Buffer)
 		},
 	}
+	serveStaleOnTimeout := c.GetDefaultCache().GetServeStaleOnTimeout()
 	defaultMatchedUrl := configurationtypes.URL{
 		TTL:                 configurationtypes.Duration{Duration: c.GetDefaultCache().GetTTL()},
+		Stale:               configurationtypes.Duration{Duration: c.GetDefaultCache().GetStale()},
 		Headers:             c.GetDefaultCache().GetHeaders(),
 		DefaultCacheControl: c.GetDefaultCache().GetDefaultCacheControl(),
+		MaxBodyBytes:        c.GetDefaultCache().GetMaxBodyBytes(),
+		ServeStaleOnTimeout: &serveStaleOnTimeout,
+	}
+	for pattern, u := range c.GetUrls() {
+		if len(u.Storers) != 0 && len(reorderStorers(storers, u.Storers)) == 0 {
+			c.GetLogger().Warnf("None of the storers %v declared for the url %s is loaded, fallback to the default ones", u.Storers, pattern)
+		}
+	}
+	targetedCacheControl := make([]string, 0, len(c.GetDefaultCache().GetTargetedCacheControl()))
+	for _, target := range c.GetDefaultCache().GetTargetedCacheControl() {
+		targetedCacheControl = append(targetedCacheControl, http.CanonicalHeaderKey(target))
 	}
 	c.GetLogger().Info("Souin configuration is now loaded.")
 	c.GetLogger().Debugf("Configuration: %#v.", c.GetDefaultCache())
@@ -219,13 +236,18 @@ func NewHTTPCacheHandler(c configurationtypes.AbstractConfigurationInterface) *S
 		Storers:                  storers,
 		InternalEndpointHandlers: api.GenerateHandlerMap(c, storers, surrogateStorage),
 		ExcludeRegex:             excludedRegexp,
-		RegexpUrls:               regexpUrls,
+		RegexpUrls:               helpers.InitializeRegexp(c),
 		DefaultMatchedUrl:        defaultMatchedUrl,
 		SurrogateKeyStorer:       surrogateStorage,
+		Rules:                    rules.NewEngine(c.GetDefaultCache().GetRules(), c.GetLogger()),
+		targetedCacheControl:     targetedCacheControl,
 		context:                  ctx,
 		bufPool:                  bufPool,
 		storersLen:               len(storers),
-		singleflightPool:         singleflight.Group{},
+		revalidations:            newRevalidationPool(c.GetDefaultCache().GetRevalidation()),
+		originLimiter:            newOriginLimiter(c.GetDefaultCache().GetOriginLimit()),
+		forcedRefresh:            newForcedRefresh(c.GetDefaultCache().GetForcedRefresh(), c.GetLogger()),
+		invalidationBus:          bus.Get(c.GetDefaultCache().GetInvalidationBus(), c.GetLogger()),
 	}
 }
 
@@ -234,14 +256,23 @@ type SouinBaseHandler struct {
 	Storers                  []types.Storer
 	InternalEndpointHandlers *api.MapHandler
 	ExcludeRegex             *regexp.Regexp
-	RegexpUrls               regexp.Regexp
 	SurrogateKeys            configurationtypes.SurrogateKeys
 	SurrogateKeyStorer       providers.SurrogateInterface
 	DefaultMatchedUrl        configurationtypes.URL
+	Rules                    *rules.Engine
 	context                  *context.Context
-	singleflightPool         singleflight.Group
+	targetedCacheControl     []string
+	coalescer                coalescer
+	revalidations            *revalidationPool
+	originLimiter            *originLimiter
+	forcedRefresh            *forcedRefresh
+	invalidationBus          *bus.Bus
 	bufPool                  *sync.Pool
 	storersLen               int
+
+	// Deprecated: RegexpUrls isn't used anymore, the urls rule of the request
+	// is resolved in its context, see context.GetMatchedURL.
+	RegexpUrls regexp.Regexp
 }
 
 var Upstream50xError = upstream50xError{}
@@ -290,6 +321,133 @@ func (s *SouinBaseHandler) hasAllowedAdditionalStatusCodesToCache(code int) bool
 	return false
 }
 
+// matchedURL returns the default values overridden by the urls rule
+// resolved for the request in the context and by the matching cache rules.
+func (s *SouinBaseHandler) matchedURL(rq *http.Request) configurationtypes.URL {
+	currentMatchedURL := s.DefaultMatchedUrl
+	if result := rules.FromContext(rq.Context()); result != nil {
+		defer func() {
+			if result.Stale != 0 {
+				currentMatchedURL.Stale = configurationtypes.Duration{Duration: result.Stale}
+			}
+			if len(result.Storers) != 0 {
+				currentMatchedURL.Storers = result.Storers
+			}
+		}()
+	}
+
+	rule := context.GetMatchedURL(rq.Context())
+	if rule == nil {
+		return currentMatchedURL
+	}
+
+	if rule.TTL.Duration != 0 {
+		currentMatchedURL.TTL = rule.TTL
+	}
+	if rule.Stale.Duration != 0 {
+		currentMatchedURL.Stale = rule.Stale
+	}
+	if len(rule.Headers) != 0 {
+		currentMatchedURL.Headers = rule.Headers
+	}
+	if rule.DefaultCacheControl != "" {
+		currentMatchedURL.DefaultCacheControl = rule.DefaultCacheControl
+	}
+	if len(rule.Storers) != 0 {
+		currentMatchedURL.Storers = rule.Storers
+	}
+	if rule.MaxBodyBytes != 0 {
+		currentMatchedURL.MaxBodyBytes = rule.MaxBodyBytes
+	}
+	if rule.ServeStaleOnTimeout != nil {
+		currentMatchedURL.ServeStaleOnTimeout = rule.ServeStaleOnTimeout
+	}
+
+	return currentMatchedURL
+}
+
+// storersFor returns the storers to use for the given url rule.
+func (s *SouinBaseHandler) storersFor(u configurationtypes.URL) []types.Storer {
+	if len(u.Storers) == 0 {
+		return s.Storers
+	}
+
+	if storers := reorderStorers(s.Storers, u.Storers); len(storers) > 0 {
+		return storers
+	}
+
+	return s.Storers
+}
+
+// responseCacheControl parses the directives of the cache control field
+// selected by the surrogate provider, a valid targeted field (RFC 9213)
+// takes precedence to keep the freshness and staleness decisions consistent.
+func (s *SouinBaseHandler) responseCacheControl(header http.Header) (*cacheobject.ResponseCacheDirectives, error) {
+	headerName, value := s.SurrogateKeyStorer.GetSurrogateControl(header)
+	if !slices.Contains(s.targetedCacheControl, headerName) {
+		value = rfc.HeaderAllCommaSepValuesString(header, headerName)
+	}
+
+	return cacheobject.ParseResponseCacheControl(value)
+}
+
+// staleOverride returns the stale duration declared by the matched url or
+// by the matching cache rules, 0 when none is declared.
+func staleOverride(rq *http.Request) time.Duration {
+	var stale time.Duration
+	if rule := context.GetMatchedURL(rq.Context()); rule != nil {
+		stale = rule.Stale.Duration
+	}
+	if result := rules.FromContext(rq.Context()); result != nil && result.Stale != 0 {
+		stale = result.Stale
+	}
+
+	return stale
+}
+
+// exceedsStaleWindow checks if the stale response is older than its stored
+// TTL plus the given stale duration.
+func exceedsStaleWindow(response *http.Response, stale time.Duration) bool {
+	storedDuration, err := time.ParseDuration(response.Header.Get(rfc.StoredTTLHeader))
+	if err != nil {
+		return false
+	}
+
+	date, err := http.ParseTime(response.Header.Get("Date"))
+	if err != nil {
+		return false
+	}
+
+	return time.Since(date) > storedDuration+stale
+}
+
+// refreshEarly implements the probabilistic early expiration (XFetch): it
+// reports whether the fresh response must be refreshed now, given the
+// upstream latency stored with it and a random value in (0, 1].
+func refreshEarly(response *http.Response, beta float64, random float64) bool {
+	storedDuration, err := time.ParseDuration(response.Header.Get(rfc.StoredTTLHeader))
+	if err != nil {
+		return false
+	}
+
+	delta, err := time.ParseDuration(response.Header.Get(rfc.StoredDeltaHeader))
+	if err != nil || delta <= 0 {
+		return false
+	}
+
+	date, err := http.ParseTime(response.Header.Get("Date"))
+	if err != nil {
+		return false
+	}
+
+	if beta <= 0 {
+		beta = 1
+	}
+	gap := time.Duration(float64(delta) * beta * -math.Log(random))
+
+	return time.Now().Add(gap).After(date.Add(storedDuration))
+}
+
 func dumpResponse(statusCode int, headers http.Header, body []byte) ([]byte, error) {
 	var buf bytes.Buffer
 	buf.Grow(256 + len(body))
@@ -324,9 +482,7 @@ func (s *SouinBaseHandler) Store(
 ) error {
 	statusCode := customWriter.GetStatusCode()
 	if !isCacheableCode(statusCode) && !s.hasAllowedAdditionalStatusCodesToCache(statusCode) {
-		cacheName := rq.Context().Value(context.CacheName).(string)
-		cacheKey := rfc.GetCacheKeyFromCtx(rq.Context())
-		customWriter.Header().Set("Cache-Status", cacheName+"; fwd=uri-miss; key="+cacheKey+"; detail=UNCACHEABLE-STATUS-CODE")
+		rfc.SetCacheStatus(customWriter.Header(), forwardedCacheStatus(rq).Key(rfc.GetCacheKeyFromCtx(rq.Context())).Detail("UNCACHEABLE-STATUS-CODE"))
 
 		switch statusCode {
 		case 500, 502, 503, 504:
@@ -336,41 +492,52 @@ func (s *SouinBaseHandler) Store(
 		return nil
 	}
 
+	if rq.Method == http.MethodHead && cachedKey == s.headGetKey(rq) {
+		// The HEAD response has no content and must not replace the GET one.
+		rfc.SetCacheStatus(customWriter.Header(), forwardedCacheStatus(rq).Key(rfc.GetCacheKeyFromCtx(rq.Context())).Detail("HEAD-SHARES-GET-KEY"))
+		return nil
+	}
+
+	var rulesResult *rules.Result
+	if s.Rules != nil {
+		rulesResult = s.Rules.EvaluateResponse(rq, statusCode, customWriter.Header())
+		customWriter.Req = rules.WithResult(customWriter.Req, rulesResult)
+		for _, h := range rulesResult.StripHeaders {
+			customWriter.Header().Del(h)
+		}
+
+		if rulesResult.Bypass {
+			rfc.SetCacheStatus(customWriter.Header(), forwardedCacheStatus(rq).Key(rfc.GetCacheKeyFromCtx(rq.Context())).Detail("RULE-BYPASS"))
+			return nil
+		}
+	}
+
+	currentMatchedURL := s.matchedURL(rq)
 	headerName, cacheControl := s.SurrogateKeyStorer.GetSurrogateControl(customWriter.Header())
 	if cacheControl == "" {
 		// TODO see with @mnot if mandatory to not store the response when no Cache-Control given.
-		// if s.DefaultMatchedUrl.DefaultCacheControl == "" {
+		// if currentMatchedURL.DefaultCacheControl == "" {
 		// 	customWriter.Header().Set("Cache-Status", fmt.Sprintf("%s; fwd=uri-miss; key=%s; detail=EMPTY-RESPONSE-CACHE-CONTROL", rq.Context().Value(context.CacheName), rfc.GetCacheKeyFromCtx(rq.Context())))
 		// 	return nil
 		// }
-		customWriter.Header().Set(headerName, s.DefaultMatchedUrl.DefaultCacheControl)
+		customWriter.Header().Set(headerName, currentMatchedURL.DefaultCacheControl)
 	}
 
-	responseCc, _ := cacheobject.ParseResponseCacheControl(rfc.HeaderAllCommaSepValuesString(customWriter.Header(), headerName))
+	responseCc, _ := s.responseCacheControl(customWriter.Header())
 	s.Configuration.GetLogger().Debugf("Response cache-control %+v", responseCc)
 	if responseCc == nil {
-		customWriter.Header().Set("Cache-Status", fmt.Sprintf("%s; fwd=uri-miss; key=%s; detail=INVALID-RESPONSE-CACHE-CONTROL", rq.Context().Value(context.CacheName), rfc.GetCacheKeyFromCtx(rq.Context())))
+		rfc.SetCacheStatus(customWriter.Header(), forwardedCacheStatus(rq).Key(rfc.GetCacheKeyFromCtx(rq.Context())).Detail("INVALID-RESPONSE-CACHE-CONTROL"))
 		return nil
 	}
 
 	modeContext := rq.Context().Value(context.Mode).(*context.ModeContext)
 	if !modeContext.Bypass_request && (responseCc.PrivatePresent || rq.Header.Get("Authorization") != "") && !canBypassAuthorizationRestriction(customWriter.Header(), rq.Context().Value(context.IgnoredHeaders).([]string)) {
-		customWriter.Header().Set("Cache-Status", fmt.Sprintf("%s; fwd=uri-miss; key=%s; detail=PRIVATE-OR-AUTHENTICATED-RESPONSE", rq.Context().Value(context.CacheName), rfc.GetCacheKeyFromCtx(rq.Context())))
+		rfc.SetCacheStatus(customWriter.Header(), forwardedCacheStatus(rq).Key(rfc.GetCacheKeyFromCtx(rq.Context())).Detail("PRIVATE-OR-AUTHENTICATED-RESPONSE"))
 		return nil
 	}
 
-	currentMatchedURL := s.DefaultMatchedUrl
-	if regexpURL := s.RegexpUrls.FindString(rq.Host + rq.URL.Path); regexpURL != "" {
-		u := s.Configuration.GetUrls()[regexpURL]
-		if u.TTL.Duration != 0 {
-			currentMatchedURL.TTL = u.TTL
-		}
-		if len(u.Headers) != 0 {
-			currentMatchedURL.Headers = u.Headers
-		}
-	}
-
 	hasFreshness := false
+	isHeuristic := false
 	ma := currentMatchedURL.TTL.Duration
 	if !modeContext.Bypass_response {
 		if responseCc.SMaxAge >= 0 {
@@ -395,15 +562,25 @@ func (s *SouinBaseHandler) Store(
 
 			ma = duration
 			hasFreshness = true
+		} else if heuristic := s.Configuration.GetDefaultCache().GetHeuristicFreshness(); heuristic.Enable {
+			if lifetime, ok := rfc.HeuristicFreshness(statusCode, customWriter.Header(), heuristic.Fraction, heuristic.Min.Duration, heuristic.Max.Duration); ok {
+				ma = lifetime
+				isHeuristic = true
+			}
 		}
 	}
 
+	if rulesResult != nil && rulesResult.TTL != 0 {
+		ma = rulesResult.TTL
+		isHeuristic = false
+	}
+
 	now := rq.Context().Value(context.Now).(time.Time)
 	date, _ := http.ParseTime(now.Format(http.TimeFormat))
 	customWriter.Header().Set(rfc.StoredTTLHeader, ma.String())
 	ma = ma - time.Since(date)
 
-	status := fmt.Sprintf("%s; fwd=uri-miss", rq.Context().Value(context.CacheName))
+	status := forwardedCacheStatus(rq)
 	if (modeContext.Bypass_request || !requestCc.NoStore) &&
 		(modeContext.Bypass_response || !responseCc.NoStore || hasFreshness) {
 		headers := customWriter.Header().Clone()
@@ -427,22 +604,30 @@ func (s *SouinBaseHandler) Store(
 		if res.Header.Get("Date") == "" {
 			res.Header.Set("Date", now.Format(http.TimeFormat))
 		}
+		if generated := s.Configuration.GetDefaultCache().GetGeneratedETag(); generated.Enable && res.Header.Get("Etag") == "" && res.Header.Get("Last-Modified") == "" {
+			etag := rfc.GenerateETag(b, res.Header, generated.Weak, generated.ExcludeHeaders)
+			res.Header.Set("Etag", etag)
+			customWriter.Header().Set("Etag", etag)
+		}
 		if res.Header.Get("Content-Length") == "" {
 			res.Header.Set("Content-Length", fmt.Sprint(bLen))
 		}
-		respBodyMaxSize := int(s.Configuration.GetDefaultCache().GetMaxBodyBytes())
+		respBodyMaxSize := int(currentMatchedURL.MaxBodyBytes)
 		if respBodyMaxSize > 0 && bLen > respBodyMaxSize {
-			customWriter.Header().Set("Cache-Status", status+"; detail=UPSTREAM-RESPONSE-TOO-LARGE; key="+rfc.GetCacheKeyFromCtx(rq.Context()))
+			rfc.SetCacheStatus(customWriter.Header(), status.Detail("UPSTREAM-RESPONSE-TOO-LARGE").Key(rfc.GetCacheKeyFromCtx(rq.Context())))
 
 			return nil
 		}
 		res.Header.Set(rfc.StoredLengthHeader, res.Header.Get("Content-Length"))
+		if latency, ok := rq.Context().Value(upstreamLatencyCtx).(time.Duration); ok {
+			res.Header.Set(rfc.StoredDeltaHeader, latency.String())
+		}
 		response, err := dumpResponse(res.StatusCode, res.Header, b)
 		if err == nil && (bLen > 0 || rq.Method == http.MethodHead || canStatusCodeEmptyContent(statusCode) || s.hasAllowedAdditionalStatusCodesToCache(statusCode)) {
 			variedHeaders, isVaryStar := rfc.VariedHeaderAllCommaSepValues(res.Header)
 			if isVaryStar {
 				// "Implies that the response is uncacheable"
-				status += "; detail=UPSTREAM-VARY-STAR"
+				status.Detail("UPSTREAM-VARY-STAR")
 			} else {
 				variedKey := cachedKey + rfc.GetVariedCacheKey(rq, variedHeaders)
 				if rq.Context().Value(context.Hashed).(bool) {
@@ -451,12 +636,13 @@ func (s *SouinBaseHandler) Store(
 				}
 				s.Configuration.GetLogger().Debugf("Store the response for %s with duration %v", variedKey, ma)
 
+				storers := s.storersFor(currentMatchedURL)
 				var wg sync.WaitGroup
 				mu := sync.Mutex{}
 				fails := []string{}
 				select {
 				case <-rq.Context().Done():
-					status += "; detail=REQUEST-CANCELED-OR-UPSTREAM-BROKEN-PIPE"
+					status.Detail("REQUEST-CANCELED-OR-UPSTREAM-BROKEN-PIPE")
 				default:
 					vhs := http.Header{}
 					for _, hname := range variedHeaders {
@@ -467,7 +653,7 @@ func (s *SouinBaseHandler) Store(
 						res.Header.Del("X-Souin-Storer")
 
 						var overridedStorer types.Storer
-						for _, storer := range s.Storers {
+						for _, storer := range storers {
 							if strings.Contains(strings.ToLower(storer.Name()), strings.ToLower(upstreamStorerTarget)) {
 								overridedStorer = storer
 							}
@@ -484,10 +670,10 @@ func (s *SouinBaseHandler) Store(
 							s.Configuration.GetLogger().Debugf("Stored the key %s in the %s provider", variedKey, overridedStorer.Name())
 							res.Request = rq
 						} else {
-							fails = append(fails, fmt.Sprintf("; detail=%s-INSERTION-ERROR", overridedStorer.Name()))
+							fails = append(fails, overridedStorer.Name()+"-INSERTION-ERROR")
 						}
 					} else {
-						for _, storer := range s.Storers {
+						for _, storer := range storers {
 							wg.Add(1)
 							go func(currentStorer types.Storer, currentRes http.Response) {
 								defer wg.Done()
@@ -503,7 +689,7 @@ func (s *SouinBaseHandler) Store(
 									currentRes.Request = rq
 								} else {
 									mu.Lock()
-									fails = append(fails, fmt.Sprintf("; detail=%s-INSERTION-ERROR", currentStorer.Name()))
+									fails = append(fails, currentStorer.Name()+"-INSERTION-ERROR")
 									mu.Unlock()
 								}
 							}(storer, res)
@@ -511,29 +697,44 @@ func (s *SouinBaseHandler) Store(
 					}
 
 					wg.Wait()
-					if len(fails) < s.storersLen {
+					if len(fails) < len(storers) {
 						if !s.Configuration.IsSurrogateDisabled() {
+							// The buffer returns to the pool once served, the
+							// body keys extraction reads its own copy.
+							surrogateRes := res
+							surrogateRes.Body = http.NoBody
+							if limit := s.SurrogateKeyStorer.BodyKeysLimit(); limit > 0 && bLen <= limit {
+								surrogateRes.Body = io.NopCloser(bytes.NewReader(bytes.Clone(b)))
+							}
 							go func(rs http.Response, key string) {
+								rs.Request = rq
+								if rulesResult != nil && len(rulesResult.SurrogateKeys) > 0 {
+									rs.Header = rs.Header.Clone()
+									s.SurrogateKeyStorer.AppendSurrogateKeys(rs.Header, rulesResult.SurrogateKeys...)
+								}
 								_ = s.SurrogateKeyStorer.Store(&rs, key, uri)
-							}(res, variedKey)
+							}(surrogateRes, variedKey)
 						}
 
-						status += "; stored"
+						status.Stored()
+						if isHeuristic {
+							status.Detail("HEURISTIC")
+						}
 					}
 
 					if len(fails) > 0 {
-						status += strings.Join(fails, "")
+						status.Detail(strings.Join(fails, " "))
 					}
 				}
 			}
 
 		} else {
-			status += "; detail=UPSTREAM-ERROR-OR-EMPTY-RESPONSE"
+			status.Detail("UPSTREAM-ERROR-OR-EMPTY-RESPONSE")
 		}
 	} else {
-		status += "; detail=NO-STORE-DIRECTIVE"
+		status.Detail("NO-STORE-DIRECTIVE")
 	}
-	customWriter.Header().Set("Cache-Status", status+"; key="+rfc.GetCacheKeyFromCtx(rq.Context()))
+	rfc.SetCacheStatus(customWriter.Header(), status.Key(rfc.GetCacheKeyFromCtx(rq.Context())))
 
 	return nil
 }
@@ -577,20 +778,31 @@ func (s *SouinBaseHandler) Upstream(
 	if s.Configuration.GetDefaultCache().IsCoalescingDisable() || disableCoalescing {
 		singleflightCacheKey += uuid.NewString()
 	}
-	sfValue, err, shared := s.singleflightPool.Do(singleflightCacheKey, func() (interface{}, error) {
-		if e := next(customWriter, rq); e != nil {
+	upstream := func() (interface{}, error) {
+		start := time.Now()
+		e := s.callOrigin(customWriter, rq, next)
+		latency := time.Since(start)
+		if errors.Is(e, errOriginOverloaded) {
+			return nil, e
+		}
+		rfc.SaveUpstreamCacheStatus(customWriter.Header())
+		if e != nil {
 			s.Configuration.GetLogger().Warnf("%#v", e)
-			customWriter.Header().Set("Cache-Status", fmt.Sprintf("%s; fwd=uri-miss; key=%s; detail=SERVE-HTTP-ERROR", rq.Context().Value(context.CacheName), rfc.GetCacheKeyFromCtx(rq.Context())))
+			rfc.SetCacheStatus(customWriter.Header(), forwardedCacheStatus(rq).Key(rfc.GetCacheKeyFromCtx(rq.Context())).Detail("SERVE-HTTP-ERROR"))
 			return nil, e
 		}
 
 		if !s.Configuration.IsSurrogateDisabled() {
-			s.SurrogateKeyStorer.Invalidate(rq.Method, customWriter.Header())
+			s.invalidateGroups(rq.Method, customWriter.Header())
 		}
 
 		statusCode := customWriter.GetStatusCode()
+		if !isSafeMethod(rq.Method) && statusCode >= http.StatusOK && statusCode < http.StatusBadRequest {
+			s.invalidateTargets(rq, customWriter.Header())
+		}
+
 		if !isCacheableCode(statusCode) && !s.hasAllowedAdditionalStatusCodesToCache(statusCode) {
-			customWriter.Header().Set("Cache-Status", fmt.Sprintf("%s; fwd=uri-miss; key=%s; detail=UNCACHEABLE-STATUS-CODE", rq.Context().Value(context.CacheName), rfc.GetCacheKeyFromCtx(rq.Context())))
+			rfc.SetCacheStatus(customWriter.Header(), forwardedCacheStatus(rq).Key(rfc.GetCacheKeyFromCtx(rq.Context())).Detail("UNCACHEABLE-STATUS-CODE"))
 
 			switch statusCode {
 			case 500, 502, 503, 504:
@@ -600,10 +812,10 @@ func (s *SouinBaseHandler) Upstream(
 
 		headerName, cacheControl := s.SurrogateKeyStorer.GetSurrogateControl(customWriter.Header())
 		if cacheControl == "" {
-			customWriter.Header().Set(headerName, s.DefaultMatchedUrl.DefaultCacheControl)
+			customWriter.Header().Set(headerName, s.matchedURL(rq).DefaultCacheControl)
 		}
 
-		err := s.Store(customWriter, rq, requestCc, cachedKey, uri)
+		err := s.Store(customWriter, withUpstreamLatency(rq, latency), requestCc, cachedKey, uri)
 
 		// Copy the buffer bytes so the returned value is independent of the
 		// underlying buffer, which may be reset or returned to the pool.
@@ -621,7 +833,18 @@ func (s *SouinBaseHandler) Upstream(
 			code:              statusCode,
 			disableCoalescing: strings.Contains(cacheControl, "private") || customWriter.Header().Get("Set-Cookie") != "",
 		}, err
-	})
+	}
+	coalescing := s.Configuration.GetDefaultCache().GetCoalescing()
+	sfValue, err, shared := s.coalescer.Do(singleflightCacheKey, coalescing.Wait.Duration, upstream)
+	if coalescingStaleDetail(err) != "" && coalescing.ServeStale && forwardReason(rq, "") == rfc.FwdStale {
+		// The caller serves the stale response it found.
+		return err
+	}
+	if err == errCoalescingWaitExceeded || err == errCoalescingLeaderAborted {
+		s.Configuration.GetLogger().Debugf("Stop waiting for the concurrent request with the key %s: %v", cachedKey, err)
+		sfValue, err = upstream()
+		shared = false
+	}
 	if recoveredFromErr != nil {
 		panic(recoveredFromErr)
 	}
@@ -653,6 +876,11 @@ func (s *SouinBaseHandler) Upstream(
 		_, _ = customWriter.Write(sfWriter.body)
 		maps.Copy(customWriter.Header(), sfWriter.headers)
 		customWriter.WriteHeader(sfWriter.code)
+		if shared {
+			rfc.UpdateCacheStatus(customWriter.Header(), func(status *rfc.CacheStatus) {
+				status.Collapsed()
+			})
+		}
 	}
 
 	return nil
@@ -666,11 +894,17 @@ func (s *SouinBaseHandler) Revalidate(validator *core.Revalidator, next handlerF
 	if s.Configuration.GetDefaultCache().IsCoalescingDisable() {
 		singleflightCacheKey += uuid.NewString()
 	}
-	sfValue, err, shared := s.singleflightPool.Do(singleflightCacheKey, func() (interface{}, error) {
-		err := next(customWriter, rq)
+	revalidate := func() (interface{}, error) {
+		start := time.Now()
+		err := s.callOrigin(customWriter, rq, next)
+		latency := time.Since(start)
+		if errors.Is(err, errOriginOverloaded) {
+			return nil, err
+		}
+		rfc.SaveUpstreamCacheStatus(customWriter.Header())
 
 		if !s.Configuration.IsSurrogateDisabled() {
-			s.SurrogateKeyStorer.Invalidate(rq.Method, customWriter.Header())
+			s.invalidateGroups(rq.Method, customWriter.Header())
 		}
 
 		statusCode := customWriter.GetStatusCode()
@@ -679,6 +913,7 @@ func (s *SouinBaseHandler) Revalidate(validator *core.Revalidator, next handlerF
 				customWriter.handleBuffer(func(b *bytes.Buffer) {
 					b.Reset()
 				})
+				rfc.MergeUpstreamCacheStatus(customWriter.Header())
 				customWriter.Rw.WriteHeader(http.StatusPreconditionFailed)
 
 				return nil, errors.New("")
@@ -689,6 +924,7 @@ func (s *SouinBaseHandler) Revalidate(validator *core.Revalidator, next handlerF
 					customWriter.handleBuffer(func(b *bytes.Buffer) {
 						b.Reset()
 					})
+					rfc.MergeUpstreamCacheStatus(customWriter.Header())
 					customWriter.Rw.WriteHeader(http.StatusNotModified)
 
 					return nil, errors.New("")
@@ -696,18 +932,17 @@ func (s *SouinBaseHandler) Revalidate(validator *core.Revalidator, next handlerF
 			}
 
 			if statusCode != http.StatusNotModified {
-				err = s.Store(customWriter, rq, requestCc, cachedKey, uri)
+				err = s.Store(customWriter, withUpstreamLatency(rq, latency), requestCc, cachedKey, uri)
 			}
 		}
 
-		customWriter.Header().Set(
-			"Cache-Status",
-			fmt.Sprintf(
-				"%s; fwd=request; fwd-status=%d; key=%s; detail=REQUEST-REVALIDATION",
-				rq.Context().Value(context.CacheName),
-				statusCode,
-				rfc.GetCacheKeyFromCtx(rq.Context()),
-			),
+		rfc.SetCacheStatus(
+			customWriter.Header(),
+			rfc.NewCacheStatus(rq.Context().Value(context.CacheName).(string)).
+				Fwd(forwardReason(rq, rfc.FwdRequest)).
+				FwdStatus(statusCode).
+				Key(rfc.GetCacheKeyFromCtx(rq.Context())).
+				Detail("REQUEST-REVALIDATION"),
 		)
 
 		// Copy the buffer bytes so the returned value is independent of the
@@ -723,7 +958,18 @@ func (s *SouinBaseHandler) Revalidate(validator *core.Revalidator, next handlerF
 			headers: customWriter.Header().Clone(),
 			code:    statusCode,
 		}, err
-	})
+	}
+	coalescing := s.Configuration.GetDefaultCache().GetCoalescing()
+	sfValue, err, shared := s.coalescer.Do(singleflightCacheKey, coalescing.Wait.Duration, revalidate)
+	if coalescingStaleDetail(err) != "" && coalescing.ServeStale {
+		// The caller serves the cached response it revalidates.
+		return err
+	}
+	if err == errCoalescingWaitExceeded || err == errCoalescingLeaderAborted {
+		s.Configuration.GetLogger().Debugf("Stop waiting for the concurrent revalidation with the key %s: %v", cachedKey, err)
+		sfValue, err = revalidate()
+		shared = false
+	}
 
 	if sfWriter, ok := sfValue.(singleflightValue); ok {
 		if shared {
@@ -733,6 +979,11 @@ func (s *SouinBaseHandler) Revalidate(validator *core.Revalidator, next handlerF
 		_, _ = customWriter.Write(sfWriter.body)
 		maps.Copy(customWriter.Header(), sfWriter.headers)
 		customWriter.WriteHeader(sfWriter.code)
+		if shared {
+			rfc.UpdateCacheStatus(customWriter.Header(), func(status *rfc.CacheStatus) {
+				status.Collapsed()
+			})
+		}
 	}
 
 	return err
@@ -751,6 +1002,295 @@ func (s *SouinBaseHandler) HandleInternally(r *http.Request) (bool, http.Handler
 }
 
 type handlerFunc = func(http.ResponseWriter, *http.Request) error
+
+type ctxKey string
+
+const (
+	forwardReasonCtx   ctxKey = "souin_ctx.FORWARD_REASON"
+	upstreamLatencyCtx ctxKey = "souin_ctx.UPSTREAM_LATENCY"
+)
+
+func withForwardReason(rq *http.Request, reason string) *http.Request {
+	return rq.WithContext(baseCtx.WithValue(rq.Context(), forwardReasonCtx, reason))
+}
+
+// withUpstreamLatency keeps the upstream response duration stored with the
+// response.
+func withUpstreamLatency(rq *http.Request, latency time.Duration) *http.Request {
+	return rq.WithContext(baseCtx.WithValue(rq.Context(), upstreamLatencyCtx, latency))
+}
+
+// forwardReason returns the Cache-Status fwd value of the request.
+func forwardReason(rq *http.Request, fallback string) string {
+	if reason, ok := rq.Context().Value(forwardReasonCtx).(string); ok {
+		return reason
+	}
+
+	return fallback
+}
+
+// forwardedCacheStatus returns the Cache-Status member of a request sent to
+// the upstream because the cache had no usable response.
+func forwardedCacheStatus(rq *http.Request) *rfc.CacheStatus {
+	return rfc.NewCacheStatus(rq.Context().Value(context.CacheName).(string)).Fwd(forwardReason(rq, rfc.FwdURIMiss))
+}
+
+// callOrigin sends the request to the upstream once the origin limiter
+// grants a slot.
+func (s *SouinBaseHandler) callOrigin(customWriter *CustomWriter, rq *http.Request, next handlerFunc) error {
+	release, err := s.originLimiter.Acquire(rq.Context(), rq.Host, getOriginPriority(rq))
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return next(customWriter, rq)
+}
+
+// serveOriginOverloaded sends the cached response when the origin limiter
+// rejected the upstream request, otherwise a 503 with Retry-After.
+func (s *SouinBaseHandler) serveOriginOverloaded(customWriter *CustomWriter, cached *http.Response, storerName string) error {
+	if cached != nil {
+		return s.serveStaleFallback(customWriter, cached, storerName, "ORIGIN-OVERLOADED")
+	}
+
+	retryAfter := s.Configuration.GetDefaultCache().GetOriginLimit().RetryAfter.Duration
+	if retryAfter <= 0 {
+		retryAfter = time.Second
+	}
+	customWriter.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	rfc.SetCacheStatus(customWriter.Header(), forwardedCacheStatus(customWriter.Req).Key(rfc.GetCacheKeyFromCtx(customWriter.Req.Context())).Detail("ORIGIN-OVERLOADED"))
+	customWriter.WriteHeader(http.StatusServiceUnavailable)
+	customWriter.handleBuffer(func(b *bytes.Buffer) {
+		b.Reset()
+	})
+	s.Configuration.GetLogger().Infof("The origin concurrency limit is reached for the endpoint %s", customWriter.Req.URL)
+	_, err := customWriter.Send()
+
+	return err
+}
+
+// serveStaleFallback sends the stale response instead of the upstream one
+// when the backend timeout or the coalescing wait is exceeded.
+func (s *SouinBaseHandler) serveStaleFallback(customWriter *CustomWriter, stale *http.Response, storerName string, detail string) error {
+	rq := customWriter.Req.WithContext(baseCtx.WithoutCancel(customWriter.Req.Context()))
+	staleWriter := NewCustomWriter(rq, customWriter.Rw, customWriter.Buf)
+	staleWriter.Headers = customWriter.Headers
+	staleWriter.hiddenHeaders = customWriter.hiddenHeaders
+
+	// The stored TTL header is removed once the Age and Cache-Status are set.
+	if stale.Header.Get(rfc.StoredTTLHeader) != "" {
+		rfc.SetCacheStatusHeader(stale, storerName)
+	}
+	rfc.UpdateCacheStatus(stale.Header, func(status *rfc.CacheStatus) {
+		status.Fwd(rfc.FwdStale).Detail(detail)
+	})
+	maps.Copy(staleWriter.Header(), stale.Header)
+	staleWriter.WriteHeader(stale.StatusCode)
+	staleWriter.handleBuffer(func(b *bytes.Buffer) {
+		b.Reset()
+		_, _ = io.Copy(b, stale.Body)
+		_ = stale.Body.Close()
+	})
+	s.Configuration.GetLogger().Infof("Serve the stale response (%s) for the endpoint %s", detail, rq.URL)
+	_, err := staleWriter.Send()
+
+	return err
+}
+
+// revalidateInBackground queues the revalidation of the stale response
+// served to the client, with its own copies of the request and validator.
+func (s *SouinBaseHandler) revalidateInBackground(validator *core.Revalidator, next handlerFunc, rq *http.Request, requestCc *cacheobject.RequestCacheDirectives, cachedKey string, uri string) {
+	backgroundValidator := *validator
+	s.runInBackground(rq.Clone(rq.Context()), cachedKey, func(customWriter *CustomWriter, revalidationRq *http.Request) error {
+		return s.Revalidate(&backgroundValidator, next, customWriter, revalidationRq, requestCc, cachedKey, uri)
+	})
+}
+
+// refreshInBackground queues the upstream request refreshing the fresh
+// response served to the client before it expires.
+func (s *SouinBaseHandler) refreshInBackground(next handlerFunc, rq *http.Request, requestCc *cacheobject.RequestCacheDirectives, cachedKey string, uri string) {
+	refreshRq := rq.Clone(rq.Context())
+	// The refreshed response must be a full one.
+	for _, h := range []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since", "If-Range", "Range"} {
+		refreshRq.Header.Del(h)
+	}
+
+	s.runInBackground(refreshRq, cachedKey, func(customWriter *CustomWriter, upstreamRq *http.Request) error {
+		prometheus.Increment(prometheus.EarlyRefreshCounter)
+
+		return s.Upstream(customWriter, upstreamRq, next, requestCc, cachedKey, uri, false)
+	})
+}
+
+// runInBackground queues the given upstream call for the key through the
+// revalidation pool. It runs detached from the client request, within the
+// remaining backend timeout.
+func (s *SouinBaseHandler) runInBackground(rq *http.Request, cachedKey string, run func(*CustomWriter, *http.Request) error) {
+	detached := withOriginPriority(rq.WithContext(baseCtx.WithoutCancel(rq.Context())), originPriorityBackground)
+	deadline, hasDeadline := rq.Context().Deadline()
+	timeout := time.Until(deadline)
+
+	queued := s.revalidations.Submit(cachedKey, func(poolCtx baseCtx.Context) {
+		var ctx baseCtx.Context
+		var cancel baseCtx.CancelFunc
+		if hasDeadline {
+			ctx, cancel = baseCtx.WithTimeout(detached.Context(), timeout)
+		} else {
+			ctx, cancel = baseCtx.WithCancel(detached.Context())
+		}
+		defer cancel()
+		stop := baseCtx.AfterFunc(poolCtx, cancel)
+		defer stop()
+
+		backgroundRq := detached.WithContext(ctx)
+		customWriter := NewCustomWriter(backgroundRq, &detachedWriter{header: http.Header{}}, new(bytes.Buffer))
+		if err := run(customWriter, backgroundRq); err != nil {
+			s.Configuration.GetLogger().Debugf("The background request of the key %s failed: %v", cachedKey, err)
+		}
+	})
+	if !queued {
+		s.Configuration.GetLogger().Debugf("Skip the background request of the key %s", cachedKey)
+	}
+}
+
+// Cleanup stops the background revalidations and releases the invalidation
+// bus.
+func (s *SouinBaseHandler) Cleanup() error {
+	s.revalidations.Close()
+
+	return errors.Join(s.InternalEndpointHandlers.Close(), s.invalidationBus.Close())
+}
+
+// headGetKey returns the cache key of the GET request equivalent to the
+// HEAD one.
+func (s *SouinBaseHandler) headGetKey(rq *http.Request) string {
+	getRq := rq.Clone(rq.Context())
+	getRq.Method = http.MethodGet
+	getRq = s.context.SetContext(getRq, getRq)
+
+	key := getRq.Context().Value(context.Key).(string)
+	if result := rules.FromContext(rq.Context()); result != nil && len(result.KeyComponents) > 0 {
+		key += "-" + strings.Join(result.KeyComponents, "-")
+	}
+
+	return key
+}
+
+// hasOtherVariant reports whether a storer still holds a response for the
+// key with different varied headers than the request ones.
+func hasOtherVariant(storers []types.Storer, key string) bool {
+	for _, storer := range storers {
+		b := storer.Get(core.MappingKeyPrefix + key)
+		if len(b) == 0 {
+			continue
+		}
+
+		mapping, err := core.DecodeMapping(b)
+		if err != nil {
+			continue
+		}
+
+		for _, item := range mapping.GetMapping() {
+			if time.Now().Before(item.GetStaleTime().AsTime()) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+
+	return false
+}
+
+// invalidationTargets returns the request URI and the same origin URIs
+// declared in the Location and Content-Location response headers.
+func invalidationTargets(rq *http.Request, headers http.Header) []*url.URL {
+	base := &url.URL{Scheme: "http", Host: rq.Host, Path: rq.URL.Path, RawPath: rq.URL.RawPath, RawQuery: rq.URL.RawQuery}
+	if rq.TLS != nil {
+		base.Scheme = "https"
+	}
+
+	targets := []*url.URL{base}
+	for _, name := range []string{"Location", "Content-Location"} {
+		value := headers.Get(name)
+		if value == "" {
+			continue
+		}
+
+		target, err := base.Parse(value)
+		if err != nil || target.Scheme != base.Scheme || target.Host != base.Host {
+			continue
+		}
+
+		target.Fragment = ""
+		targets = append(targets, target)
+	}
+
+	return targets
+}
+
+// invalidateGroups invalidates the groups listed in the
+// Cache-Group-Invalidation response header of an unsafe request and
+// propagates them to the other instances.
+func (s *SouinBaseHandler) invalidateGroups(method string, headers http.Header) {
+	s.SurrogateKeyStorer.Invalidate(method, headers)
+	if groups := headers["Cache-Group-Invalidation"]; len(groups) > 0 && !isSafeMethod(method) {
+		s.invalidationBus.Publish(bus.Event{Type: bus.GroupEvent, Tags: groups})
+	}
+}
+
+// invalidateTargets removes the GET responses stored for the invalidation
+// targets of an unsafe request with every varied response from all the
+// storers (RFC 9111 section 4.4).
+func (s *SouinBaseHandler) invalidateTargets(rq *http.Request, headers http.Header) {
+	keys := []string{}
+	for _, target := range invalidationTargets(rq, headers) {
+		getRq := rq.Clone(baseCtx.WithValue(rq.Context(), context.MatchedURL, (*context.URLRule)(nil)))
+		getRq.Method = http.MethodGet
+		getRq.URL = target
+		getRq.Host = target.Host
+		getRq.RequestURI = target.RequestURI()
+		getRq.Body = nil
+		getRq = s.context.SetContext(s.context.URL.SetContext(getRq), getRq)
+
+		key := getRq.Context().Value(context.Key).(string)
+		if getRq.Context().Value(context.Hashed).(bool) {
+			key = fmt.Sprint(xxhash.Sum64String(key))
+		}
+
+		s.Configuration.GetLogger().Debugf("Invalidate the key %s", key)
+		for _, storer := range s.Storers {
+			invalidateKey(storer, key)
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) > 0 {
+		s.invalidationBus.Publish(bus.Event{Type: bus.DeleteEvent, Keys: keys, Purge: true})
+	}
+}
+
+// invalidateKey deletes the mapping of the key and the varied responses it references.
+func invalidateKey(storer types.Storer, key string) {
+	if b := storer.Get(core.MappingKeyPrefix + key); len(b) > 0 {
+		if mapping, err := core.DecodeMapping(b); err == nil {
+			for variedKey := range mapping.GetMapping() {
+				storer.Delete(variedKey)
+			}
+		}
+	}
+
+	storer.Delete(core.MappingKeyPrefix + key)
+}
+
 type statusCodeLogger struct {
 	http.ResponseWriter
 	statusCode int
@@ -761,7 +1301,7 @@ func (s *statusCodeLogger) WriteHeader(code int) {
 	s.ResponseWriter.WriteHeader(code)
 }
 
-func (s *SouinBaseHandler) backfillStorers(idx int, cachedKey string, rq *http.Request, response *http.Response) {
+func (s *SouinBaseHandler) backfillStorers(storers []types.Storer, idx int, cachedKey string, rq *http.Request, response *http.Response) {
 	if idx == 0 {
 		return
 	}
@@ -801,7 +1341,7 @@ func (s *SouinBaseHandler) backfillStorers(idx int, cachedKey string, rq *http.R
 	response.Body = io.NopCloser(bytes.NewReader(bodyResponse.Bytes()))
 	res, _ := dumpResponse(response.StatusCode, response.Header, bodyResponse.Bytes())
 
-	for _, currentStorer := range s.Storers[:idx] {
+	for _, currentStorer := range storers[:idx] {
 		err = currentStorer.SetMultiLevel(
 			cachedKey,
 			variedKey,
@@ -827,6 +1367,7 @@ func (s *SouinBaseHandler) ServeHTTP(rw http.ResponseWriter, rq *http.Request, n
 		return nil
 	}
 
+	rq, refresh := s.forcedRefresh.authorize(rq)
 	req := s.context.SetBaseContext(rq)
 	defer func() {
 		toCancel := req.Context().Value(context.TimeoutCancel)
@@ -839,30 +1380,29 @@ func (s *SouinBaseHandler) ServeHTTP(rw http.ResponseWriter, rq *http.Request, n
 	cacheName := req.Context().Value(context.CacheName).(string)
 
 	if rq.Header.Get("Upgrade") == "websocket" || rq.Header.Get("Accept") == "text/event-stream" || (s.ExcludeRegex != nil && s.ExcludeRegex.MatchString(rq.RequestURI)) {
-		rw.Header().Set("Cache-Status", cacheName+"; fwd=bypass; detail=EXCLUDED-REQUEST-URI")
-		return next(rw, req)
+		crw := newCacheStatusWriter(rw, rfc.NewCacheStatus(cacheName).Fwd(rfc.FwdBypass).Detail("EXCLUDED-REQUEST-URI"))
+		defer crw.writeCacheStatus()
+
+		return next(crw, req)
 	}
 
 	if !req.Context().Value(context.SupportedMethod).(bool) {
-		rw.Header().Set("Cache-Status", cacheName+"; fwd=bypass; detail=UNSUPPORTED-METHOD")
+		crw := newCacheStatusWriter(rw, rfc.NewCacheStatus(cacheName).Fwd(rfc.FwdMethod).Detail("UNSUPPORTED-METHOD"))
 		nrw := &statusCodeLogger{
-			ResponseWriter: rw,
+			ResponseWriter: crw,
 			statusCode:     0,
 		}
 
 		err := next(nrw, req)
+		crw.writeCacheStatus()
 
 		if !s.Configuration.IsSurrogateDisabled() {
-			s.SurrogateKeyStorer.Invalidate(req.Method, rw.Header())
+			s.invalidateGroups(req.Method, rw.Header())
 		}
 
-		if err == nil && req.Method != http.MethodGet && nrw.statusCode < http.StatusBadRequest {
+		if err == nil && !isSafeMethod(req.Method) && nrw.statusCode < http.StatusBadRequest {
 			// Invalidate related GET keys when the method is not allowed and the response is valid
-			req.Method = http.MethodGet
-			keyname := s.context.SetContext(req, rq).Context().Value(context.Key).(string)
-			for _, storer := range s.Storers {
-				storer.Delete(core.MappingKeyPrefix + keyname)
-			}
+			s.invalidateTargets(req, rw.Header())
 		}
 
 		return err
@@ -872,12 +1412,12 @@ func (s *SouinBaseHandler) ServeHTTP(rw http.ResponseWriter, rq *http.Request, n
 
 	modeContext := req.Context().Value(context.Mode).(*context.ModeContext)
 	if !modeContext.Bypass_request && (coErr != nil || requestCc == nil) {
-		rw.Header().Set("Cache-Status", cacheName+"; fwd=bypass; detail=CACHE-CONTROL-EXTRACTION-ERROR")
-
-		err := next(rw, req)
+		crw := newCacheStatusWriter(rw, rfc.NewCacheStatus(cacheName).Fwd(rfc.FwdBypass).Detail("CACHE-CONTROL-EXTRACTION-ERROR"))
+		err := next(crw, req)
+		crw.writeCacheStatus()
 
 		if !s.Configuration.IsSurrogateDisabled() {
-			s.SurrogateKeyStorer.Invalidate(req.Method, rw.Header())
+			s.invalidateGroups(req.Method, rw.Header())
 		}
 
 		return err
@@ -885,35 +1425,44 @@ func (s *SouinBaseHandler) ServeHTTP(rw http.ResponseWriter, rq *http.Request, n
 
 	req = s.context.SetContext(req, rq)
 	if req.Context().Value(context.IsMutationRequest).(bool) {
-		rw.Header().Set("Cache-Status", cacheName+"; fwd=bypass; detail=IS-MUTATION-REQUEST")
-
-		err := next(rw, req)
+		crw := newCacheStatusWriter(rw, rfc.NewCacheStatus(cacheName).Fwd(rfc.FwdBypass).Detail("IS-MUTATION-REQUEST"))
+		err := next(crw, req)
+		crw.writeCacheStatus()
 
 		if !s.Configuration.IsSurrogateDisabled() {
-			s.SurrogateKeyStorer.Invalidate(req.Method, rw.Header())
+			s.invalidateGroups(req.Method, rw.Header())
 		}
 
 		return err
 	}
+	if s.Rules != nil {
+		result := s.Rules.EvaluateRequest(req)
+		req = rules.WithResult(req, result)
+		if result.Bypass {
+			crw := newCacheStatusWriter(rw, rfc.NewCacheStatus(cacheName).Fwd(rfc.FwdBypass).Detail("RULE-BYPASS").Set("rule", result.MatchedNames()))
+			defer crw.writeCacheStatus()
+
+			return next(crw, req)
+		}
+
+		if len(result.KeyComponents) > 0 {
+			key := req.Context().Value(context.Key).(string) + "-" + strings.Join(result.KeyComponents, "-")
+			req = req.WithContext(baseCtx.WithValue(req.Context(), context.Key, key))
+		}
+	}
 	cachedKey := req.Context().Value(context.Key).(string)
 
 	// Need to copy URL path before calling next because it can alter the URI
 	uri := req.URL.Path
 	bufPool := s.bufPool.Get().(*bytes.Buffer)
 	bufPool.Reset()
-	// Track whether the buffer ownership has been handed off to the background
-	// goroutine. If so, we must not return it to the pool on exit because the
-	// goroutine may still be writing to it.
-	var bufPoolOwned atomic.Bool
-	bufPoolOwned.Store(true)
 	defer func() {
-		if bufPoolOwned.Load() {
-			bufPool.Reset()
-			s.bufPool.Put(bufPool)
-		}
+		bufPool.Reset()
+		s.bufPool.Put(bufPool)
 	}()
 
 	customWriter := NewCustomWriter(req, rw, bufPool)
+	customWriter.hiddenHeaders = s.targetedCacheControl
 	customWriter.Headers.Add("Range", req.Header.Get("Range"))
 	req.Header.Del("Range")
 
@@ -925,34 +1474,86 @@ func (s *SouinBaseHandler) ServeHTTP(rw http.ResponseWriter, rq *http.Request, n
 	// }
 
 	backfillIds := 0
+	currentMatchedURL := s.matchedURL(req)
+	storers := s.storersFor(currentMatchedURL)
 
 	s.Configuration.GetLogger().Debugf("Request cache-control %+v", requestCc)
-	if modeContext.Bypass_request || !requestCc.NoCache {
+	if refresh {
+		s.Configuration.GetLogger().Debugf("Forced refresh of the key %s", cachedKey)
+		req = withForwardReason(req, rfc.FwdRequest)
+		if s.forcedRefresh.purgeVariants {
+			for _, storer := range storers {
+				invalidateKey(storer, cachedKey)
+			}
+		}
+	} else if !modeContext.Bypass_request && requestCc.NoCache {
+		req = withForwardReason(req, rfc.FwdRequest)
+	}
+	var fallbackStale *http.Response
+	var fallbackStaleStorer string
+	if !refresh && (modeContext.Bypass_request || !requestCc.NoCache) {
 		validator := rfc.ParseRequest(req)
 		var fresh, stale *http.Response
-		var storerName string
-		finalKey := cachedKey
-		if req.Context().Value(context.Hashed).(bool) {
-			finalKey = fmt.Sprint(xxhash.Sum64String(finalKey))
+		var storerName, finalKey string
+		lookupKey := cachedKey
+		lookupKeys := []string{cachedKey}
+		if req.Method == http.MethodHead {
+			if getKey := s.headGetKey(req); getKey != cachedKey {
+				// The GET response holds the representation requested by HEAD.
+				lookupKeys = []string{getKey, cachedKey}
+			}
 		}
-		for _, currentStorer := range s.Storers {
-			fresh, stale = currentStorer.GetMultiLevel(finalKey, req, validator)
+		for _, lookupKey = range lookupKeys {
+			finalKey = lookupKey
+			if req.Context().Value(context.Hashed).(bool) {
+				finalKey = fmt.Sprint(xxhash.Sum64String(finalKey))
+			}
+
+			backfillIds = 0
+			for _, currentStorer := range storers {
+				fresh, stale = currentStorer.GetMultiLevel(finalKey, req, validator)
+
+				if fresh != nil || stale != nil {
+					storerName = currentStorer.Name()
+					s.Configuration.GetLogger().Debugf("Found at least one valid response in the %s storage", storerName)
+					break
+				}
+
+				backfillIds++
+			}
 
 			if fresh != nil || stale != nil {
-				storerName = currentStorer.Name()
-				s.Configuration.GetLogger().Debugf("Found at least one valid response in the %s storage", storerName)
 				break
 			}
+		}
+
+		// The storers keep the stale responses for the default_cache stale
+		// duration, a shorter url or rule stale duration is enforced here.
+		if staleDuration := staleOverride(req); stale != nil && staleDuration != 0 && exceedsStaleWindow(stale, staleDuration) {
+			s.Configuration.GetLogger().Debugf("The stale response exceeds the stale duration %v", staleDuration)
+			stale = nil
+		}
 
-			backfillIds++
+		switch {
+		case fresh != nil:
+			req = withForwardReason(req, rfc.FwdRequest)
+		case stale != nil:
+			req = withForwardReason(req, rfc.FwdStale)
+		case hasOtherVariant(storers, finalKey):
+			req = withForwardReason(req, rfc.FwdVaryMiss)
+		}
+		fallbackStale, fallbackStaleStorer = stale, storerName
+
+		if earlyExpiration := s.Configuration.GetDefaultCache().GetEarlyExpiration(); earlyExpiration.Enable && fresh != nil && lookupKey == cachedKey && refreshEarly(fresh, earlyExpiration.Beta, 1-rand.Float64()) {
+			s.Configuration.GetLogger().Debugf("Refresh the key %s before its expiry", cachedKey)
+			s.refreshInBackground(next, req, requestCc, cachedKey, uri)
 		}
 
-		headerName, _ := s.SurrogateKeyStorer.GetSurrogateControl(customWriter.Header())
 		if fresh != nil && (!modeContext.Strict || rfc.ValidateCacheControl(fresh, requestCc)) {
 			freshClone := *fresh
 			freshClone.Header = fresh.Header.Clone()
 
-			go s.backfillStorers(backfillIds, cachedKey, req.Clone(req.Context()), &freshClone)
+			go s.backfillStorers(storers, backfillIds, lookupKey, req.Clone(req.Context()), &freshClone)
 
 			response := fresh
 
@@ -983,19 +1584,32 @@ func (s *SouinBaseHandler) ServeHTTP(rw http.ResponseWriter, rq *http.Request, n
 
 			if !modeContext.Bypass_request && validator.NeedRevalidation {
 				err := s.Revalidate(validator, next, customWriter, req, requestCc, cachedKey, uri)
+				if errors.Is(err, errOriginOverloaded) {
+					return s.serveOriginOverloaded(customWriter, response, storerName)
+				}
+				if detail := coalescingStaleDetail(err); detail != "" {
+					return s.serveStaleFallback(customWriter, response, storerName, detail)
+				}
 				_, _ = customWriter.Send()
 
 				return err
 			}
-			if resCc, _ := cacheobject.ParseResponseCacheControl(rfc.HeaderAllCommaSepValuesString(response.Header, headerName)); !modeContext.Bypass_response && resCc.NoCachePresent {
+			resCc, _ := s.responseCacheControl(response.Header)
+			if !modeContext.Bypass_response && resCc != nil && resCc.NoCachePresent {
 				prometheus.Increment(prometheus.NoCachedResponseCounter)
 				err := s.Revalidate(validator, next, customWriter, req, requestCc, cachedKey, uri)
+				if errors.Is(err, errOriginOverloaded) {
+					return s.serveOriginOverloaded(customWriter, response, storerName)
+				}
+				if detail := coalescingStaleDetail(err); detail != "" {
+					return s.serveStaleFallback(customWriter, response, storerName, detail)
+				}
 				_, _ = customWriter.Send()
 
 				return err
 			}
 			rfc.SetCacheStatusHeader(response, storerName)
-			if !modeContext.Strict || rfc.ValidateMaxAgeCachedResponse(requestCc, response) != nil {
+			if !modeContext.Strict || rfc.ValidateMaxAgeCachedResponseDirectives(requestCc, resCc, response) != nil {
 				for h, v := range response.Header {
 					customWriter.Header()[h] = v
 				}
@@ -1017,22 +1631,19 @@ func (s *SouinBaseHandler) ServeHTTP(rw http.ResponseWriter, rq *http.Request, n
 				addTime, _ := time.ParseDuration(response.Header.Get(rfc.StoredTTLHeader))
 				rfc.SetCacheStatusHeader(response, storerName)
 
-				responseCc, _ := cacheobject.ParseResponseCacheControl(rfc.HeaderAllCommaSepValuesString(response.Header, "Cache-Control"))
+				responseCc, _ := s.responseCacheControl(response.Header)
 				if responseCc.StaleWhileRevalidate > 0 {
+					rfc.HitStaleCache(&response.Header)
 					for h, v := range response.Header {
 						customWriter.Header()[h] = v
 					}
 					customWriter.WriteHeader(response.StatusCode)
-					rfc.HitStaleCache(&response.Header)
 					customWriter.handleBuffer(func(b *bytes.Buffer) {
 						_, _ = io.Copy(b, response.Body)
 						_ = response.Body.Close()
 					})
 					_, err := customWriter.Send()
-					customWriter = NewCustomWriter(req, rw, new(bytes.Buffer))
-					go func(v *core.Revalidator, goCw *CustomWriter, goRq *http.Request, goNext func(http.ResponseWriter, *http.Request) error, goCc *cacheobject.RequestCacheDirectives, goCk string, goUri string) {
-						_ = s.Revalidate(v, goNext, goCw, goRq, goCc, goCk, goUri)
-					}(validator, customWriter, req, next, requestCc, cachedKey, uri)
+					s.revalidateInBackground(validator, next, req, requestCc, cachedKey, uri)
 
 					return err
 				}
@@ -1040,12 +1651,19 @@ func (s *SouinBaseHandler) ServeHTTP(rw http.ResponseWriter, rq *http.Request, n
 				if modeContext.Bypass_response || responseCc.MustRevalidate || responseCc.NoCachePresent || validator.NeedRevalidation {
 					req.Header["If-None-Match"] = append(req.Header["If-None-Match"], validator.ResponseETag)
 					err := s.Revalidate(validator, next, customWriter, req, requestCc, cachedKey, uri)
+					if errors.Is(err, errOriginOverloaded) {
+						return s.serveOriginOverloaded(customWriter, response, storerName)
+					}
+					if detail := coalescingStaleDetail(err); detail != "" {
+						return s.serveStaleFallback(customWriter, response, storerName, detail)
+					}
 					statusCode := customWriter.GetStatusCode()
 					if err != nil {
 						if responseCc.StaleIfError > -1 || requestCc.StaleIfError > 0 {
-							code := fmt.Sprintf("; fwd-status=%d", statusCode)
 							rfc.HitStaleCache(&response.Header)
-							response.Header.Set("Cache-Status", response.Header.Get("Cache-Status")+code)
+							rfc.UpdateCacheStatus(response.Header, func(status *rfc.CacheStatus) {
+								status.FwdStatus(statusCode)
+							})
 							maps.Copy(customWriter.Header(), response.Header)
 							customWriter.WriteHeader(response.StatusCode)
 							customWriter.handleBuffer(func(b *bytes.Buffer) {
@@ -1134,21 +1752,28 @@ func (s *SouinBaseHandler) ServeHTTP(rw http.ResponseWriter, rq *http.Request, n
 			}
 
 			addTime, _ := time.ParseDuration(response.Header.Get(rfc.StoredTTLHeader))
-			responseCc, _ := cacheobject.ParseResponseCacheControl(rfc.HeaderAllCommaSepValuesString(response.Header, "Cache-Control"))
+			responseCc, _ := s.responseCacheControl(response.Header)
 
 			if !modeContext.Strict || rfc.ValidateMaxAgeCachedStaleResponse(requestCc, responseCc, response, int(addTime.Seconds())) != nil {
 				_, _ = time.ParseDuration(response.Header.Get(rfc.StoredTTLHeader))
 				rfc.SetCacheStatusHeader(response, storerName)
 
-				responseCc, _ := cacheobject.ParseResponseCacheControl(rfc.HeaderAllCommaSepValuesString(response.Header, "Cache-Control"))
+				responseCc, _ := s.responseCacheControl(response.Header)
 
 				if responseCc.StaleIfError > -1 || requestCc.StaleIfError > 0 {
 					err := s.Revalidate(validator, next, customWriter, req, requestCc, cachedKey, uri)
+					if errors.Is(err, errOriginOverloaded) {
+						return s.serveOriginOverloaded(customWriter, response, storerName)
+					}
+					if detail := coalescingStaleDetail(err); detail != "" {
+						return s.serveStaleFallback(customWriter, response, storerName, detail)
+					}
 					statusCode := customWriter.GetStatusCode()
 					if err != nil {
-						code := fmt.Sprintf("; fwd-status=%d", statusCode)
 						rfc.HitStaleCache(&response.Header)
-						response.Header.Set("Cache-Status", response.Header.Get("Cache-Status")+code)
+						rfc.UpdateCacheStatus(response.Header, func(status *rfc.CacheStatus) {
+							status.FwdStatus(statusCode)
+						})
 						maps.Copy(customWriter.Header(), response.Header)
 						customWriter.WriteHeader(response.StatusCode)
 						customWriter.handleBuffer(func(b *bytes.Buffer) {
@@ -1168,25 +1793,54 @@ func (s *SouinBaseHandler) ServeHTTP(rw http.ResponseWriter, rq *http.Request, n
 
 	errorCacheCh := make(chan error, 1)
 
+	upstreamRq := req
+	upstreamCancel := baseCtx.CancelFunc(func() {})
+	serveStale := fallbackStale != nil && currentMatchedURL.ServeStaleOnTimeout != nil && *currentMatchedURL.ServeStaleOnTimeout
+	if serveStale {
+		// The upstream call outlives the client deadline to refresh the stale
+		// response, for one more backend timeout once the stale one is served.
+		timeout, _ := req.Context().Value(context.TimeoutBackend).(time.Duration)
+		deadline, hasDeadline := req.Context().Deadline()
+		if !hasDeadline {
+			deadline = time.Now()
+		}
+		var upstreamCtx baseCtx.Context
+		upstreamCtx, upstreamCancel = baseCtx.WithDeadline(baseCtx.WithoutCancel(req.Context()), deadline.Add(timeout))
+		upstreamRq = req.WithContext(upstreamCtx)
+	}
+
+	// The upstream call writes to its own headers and buffer, copied to the
+	// client writer once it returns, so it never races with the response sent
+	// on timeout. The buffer goes back to the pool from the goroutine when the
+	// request already returned.
+	upstreamBuf := s.bufPool.Get().(*bytes.Buffer)
+	upstreamBuf.Reset()
+	var upstreamBufOwned atomic.Bool
+	upstreamBufOwned.Store(true)
+	upstreamWriter := NewCustomWriter(upstreamRq, &detachedWriter{header: http.Header{}}, upstreamBuf)
+
 	go func(vr *http.Request, cw *CustomWriter) {
+		defer upstreamCancel()
 		prometheus.Increment(prometheus.NoCachedResponseCounter)
 		err := s.Upstream(cw, vr, next, requestCc, cachedKey, uri, false)
-		// If the parent already returned (context timeout), we own the buffer now.
-		if !bufPoolOwned.Load() {
-			bufPool.Reset()
-			s.bufPool.Put(bufPool)
+		if !upstreamBufOwned.Load() {
+			upstreamBuf.Reset()
+			s.bufPool.Put(upstreamBuf)
 		}
 		errorCacheCh <- err
-	}(req, customWriter)
+	}(upstreamRq, upstreamWriter)
 
 	select {
 	case <-req.Context().Done():
-		// Transfer buffer ownership to the goroutine so it can return the
-		// buffer to the pool once Upstream finishes.
-		bufPoolOwned.Store(false)
+		// Transfer the upstream buffer ownership to the goroutine.
+		upstreamBufOwned.Store(false)
+		if serveStale && req.Context().Err() == baseCtx.DeadlineExceeded {
+			return s.serveStaleFallback(customWriter, fallbackStale, fallbackStaleStorer, "DEADLINE-EXCEEDED")
+		}
+
 		switch req.Context().Err() {
 		case baseCtx.DeadlineExceeded:
-			rw.Header().Set("Cache-Status", cacheName+"; fwd=bypass; detail=DEADLINE-EXCEEDED")
+			rfc.SetCacheStatus(rw.Header(), rfc.NewCacheStatus(cacheName).Fwd(rfc.FwdBypass).Detail("DEADLINE-EXCEEDED"))
 			customWriter.Rw.WriteHeader(http.StatusGatewayTimeout)
 			_, _ = customWriter.Rw.Write([]byte("Internal server error"))
 			s.Configuration.GetLogger().Infof("Internal server error on endpoint %s: %v", req.URL, s.Storers)
@@ -1197,6 +1851,22 @@ func (s *SouinBaseHandler) ServeHTTP(rw http.ResponseWriter, rq *http.Request, n
 			return nil
 		}
 	case v := <-errorCacheCh:
+		defer func() {
+			upstreamBuf.Reset()
+			s.bufPool.Put(upstreamBuf)
+		}()
+		if v == errOriginOverloaded {
+			return s.serveOriginOverloaded(customWriter, fallbackStale, fallbackStaleStorer)
+		}
+		if detail := coalescingStaleDetail(v); detail != "" && fallbackStale != nil {
+			return s.serveStaleFallback(customWriter, fallbackStale, fallbackStaleStorer, detail)
+		}
+		maps.Copy(customWriter.Header(), upstreamWriter.Header())
+		customWriter.WriteHeader(upstreamWriter.GetStatusCode())
+		upstreamWriter.handleBuffer(func(b *bytes.Buffer) {
+			_, _ = customWriter.Write(b.Bytes())
+		})
+
 		switch v {
 		case nil:
 			_, _ = customWriter.Send()
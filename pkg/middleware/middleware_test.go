@@ -3,14 +3,17 @@ package middleware
 import (
 	"bytes"
 	baseCtx "context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/darkweak/souin/configurationtypes"
+	"github.com/darkweak/souin/pkg/rfc"
 	"github.com/darkweak/souin/pkg/storage/types"
 )
 
@@ -351,3 +354,600 @@ func TestBufferBytesSliceCorruption(t *testing.T) {
 		t.Errorf("safe copy was corrupted — should never happen: got %q", string(safeCopy))
 	}
 }
+
+func TestURLOverridesMaxBodyBytesAndDefaultCacheControl(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.URLs = map[string]configurationtypes.URL{
+		"example.com/url-overrides/small": {
+			MaxBodyBytes: 4,
+		},
+		"example.com/url-overrides/private": {
+			DefaultCacheControl: "no-store",
+		},
+	}
+	handler := NewHTTPCacheHandler(cfg)
+	next := slowNext("HELLO_WORLD", 0)
+
+	rec := httptest.NewRecorder()
+	if err := handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/url-overrides/small", nil), next); err != nil {
+		t.Fatalf("ServeHTTP failed: %v", err)
+	}
+	if !strings.Contains(rec.Header().Get("Cache-Status"), "detail=UPSTREAM-RESPONSE-TOO-LARGE") {
+		t.Errorf("The response must not be stored for the small url, Cache-Status %q given.", rec.Header().Get("Cache-Status"))
+	}
+
+	rec = httptest.NewRecorder()
+	if err := handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/url-overrides/private", nil), next); err != nil {
+		t.Fatalf("ServeHTTP failed: %v", err)
+	}
+	if !strings.Contains(rec.Header().Get("Cache-Status"), "detail=NO-STORE-DIRECTIVE") {
+		t.Errorf("The url default_cache_control must be applied, Cache-Status %q given.", rec.Header().Get("Cache-Status"))
+	}
+
+	rec = httptest.NewRecorder()
+	if err := handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/url-overrides/other", nil), next); err != nil {
+		t.Fatalf("ServeHTTP failed: %v", err)
+	}
+	if !strings.Contains(rec.Header().Get("Cache-Status"), "stored") {
+		t.Errorf("The response must be stored for the other urls, Cache-Status %q given.", rec.Header().Get("Cache-Status"))
+	}
+}
+
+func TestRulesBypassAndKeyComponents(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.DefaultCache.Rules = []configurationtypes.Rule{
+		{
+			Name:      "session",
+			Condition: "'session' in request.cookies",
+			Bypass:    true,
+		},
+		{
+			Name:          "tenant",
+			Condition:     "'x-tenant' in request.headers",
+			KeyComponents: []string{"request.headers['x-tenant']"},
+		},
+	}
+	handler := NewHTTPCacheHandler(cfg)
+	next := slowNext("HELLO_WORLD", 0)
+
+	rq := httptest.NewRequest(http.MethodGet, "http://example.com/rules/bypass", nil)
+	rq.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+	rec := httptest.NewRecorder()
+	if err := handler.ServeHTTP(rec, rq, next); err != nil {
+		t.Fatalf("ServeHTTP failed: %v", err)
+	}
+	if cs := rec.Header().Get("Cache-Status"); !strings.Contains(cs, "detail=RULE-BYPASS") || !strings.Contains(cs, `rule="session"`) {
+		t.Errorf("The session rule must bypass the cache, Cache-Status %q given.", cs)
+	}
+
+	for _, tenant := range []string{"acme", "globex"} {
+		rq = httptest.NewRequest(http.MethodGet, "http://example.com/rules/tenant", nil)
+		rq.Header.Set("X-Tenant", tenant)
+		rec = httptest.NewRecorder()
+		if err := handler.ServeHTTP(rec, rq, next); err != nil {
+			t.Fatalf("ServeHTTP failed: %v", err)
+		}
+		if cs := rec.Header().Get("Cache-Status"); !strings.Contains(cs, "stored") || !strings.Contains(cs, `rule="tenant"`) {
+			t.Errorf("Each tenant must have its own cache entry, Cache-Status %q given.", cs)
+		}
+	}
+}
+
+func TestHeuristicFreshness(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.DefaultCache.HeuristicFreshness = configurationtypes.HeuristicFreshness{
+		Enable: true,
+		Max:    configurationtypes.Duration{Duration: time.Hour},
+	}
+	handler := NewHTTPCacheHandler(cfg)
+	next := func(w http.ResponseWriter, _ *http.Request) error {
+		w.Header().Set("Last-Modified", time.Now().Add(-24*time.Hour).UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("HELLO_WORLD"))
+		return nil
+	}
+
+	rec := httptest.NewRecorder()
+	if err := handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/heuristic", nil), next); err != nil {
+		t.Fatalf("ServeHTTP failed: %v", err)
+	}
+	if cs := rec.Header().Get("Cache-Status"); !strings.Contains(cs, "stored; detail=HEURISTIC") {
+		t.Errorf("The response must be stored with the heuristic lifetime, Cache-Status %q given.", cs)
+	}
+
+	rec = httptest.NewRecorder()
+	if err := handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/heuristic-without-validator", nil), slowNext("HELLO_WORLD", 0)); err != nil {
+		t.Fatalf("ServeHTTP failed: %v", err)
+	}
+	if cs := rec.Header().Get("Cache-Status"); strings.Contains(cs, "HEURISTIC") {
+		t.Errorf("The default TTL must be used without Last-Modified, Cache-Status %q given.", cs)
+	}
+}
+
+func TestUnsafeMethodInvalidatesLocationTargets(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.DefaultCache.AllowedHTTPVerbs = []string{http.MethodGet, http.MethodHead, http.MethodPatch}
+	handler := NewHTTPCacheHandler(cfg)
+	next := slowNext("HELLO_WORLD", 0)
+
+	serve := func(method, target string, next handlerFunc) string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		if err := handler.ServeHTTP(rec, httptest.NewRequest(method, target, nil), next); err != nil {
+			t.Fatalf("ServeHTTP failed: %v", err)
+		}
+
+		return rec.Header().Get("Cache-Status")
+	}
+	warmup := func(targets ...string) {
+		t.Helper()
+		for _, target := range targets {
+			serve(http.MethodGet, target, next)
+			if cs := serve(http.MethodGet, target, next); !strings.Contains(cs, "hit") {
+				t.Fatalf("The %s response must be served from the cache, Cache-Status %q given.", target, cs)
+			}
+		}
+	}
+	withLocation := func(location, contentLocation string) handlerFunc {
+		return func(w http.ResponseWriter, _ *http.Request) error {
+			w.Header().Set("Location", location)
+			w.Header().Set("Content-Location", contentLocation)
+			w.WriteHeader(http.StatusCreated)
+			return nil
+		}
+	}
+
+	warmup("http://example.com/invalidation", "http://example.com/invalidation/1", "http://example.com/invalidation/2", "http://example.com/invalidation/3")
+	serve(http.MethodPost, "http://example.com/invalidation", withLocation("/invalidation/1", "http://other.com/invalidation/2"))
+
+	for target, invalidated := range map[string]bool{
+		"http://example.com/invalidation":   true,
+		"http://example.com/invalidation/1": true,
+		"http://example.com/invalidation/2": false,
+		"http://example.com/invalidation/3": false,
+	} {
+		if cs := serve(http.MethodGet, target, next); strings.Contains(cs, "hit") == invalidated {
+			t.Errorf("Unexpected invalidation state for %s, Cache-Status %q given.", target, cs)
+		}
+	}
+
+	warmup("http://example.com/invalidation/3")
+	serve(http.MethodPatch, "http://example.com/invalidation/patch", withLocation("", "http://example.com/invalidation/3"))
+	if cs := serve(http.MethodGet, "http://example.com/invalidation/3", next); strings.Contains(cs, "hit") {
+		t.Errorf("The PATCH Content-Location target must be invalidated, Cache-Status %q given.", cs)
+	}
+}
+
+func TestGeneratedETag(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.DefaultCache.GeneratedETag = configurationtypes.GeneratedETag{
+		Enable: true,
+		Weak:   true,
+	}
+	handler := NewHTTPCacheHandler(cfg)
+	next := slowNext("HELLO_WORLD", 0)
+
+	rec := httptest.NewRecorder()
+	if err := handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/generated-etag", nil), next); err != nil {
+		t.Fatalf("ServeHTTP failed: %v", err)
+	}
+	etag := rec.Header().Get("Etag")
+	if !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("The response must contain a generated weak ETag, %q given.", etag)
+	}
+
+	rq := httptest.NewRequest(http.MethodGet, "http://example.com/generated-etag", nil)
+	rq.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	if err := handler.ServeHTTP(rec, rq, next); err != nil {
+		t.Fatalf("ServeHTTP failed: %v", err)
+	}
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("The cache must answer the matching If-None-Match with a 304, %d given.", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	if err := handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/generated-etag-with-validator", nil), func(w http.ResponseWriter, _ *http.Request) error {
+		w.Header().Set("Etag", `"origin"`)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("HELLO_WORLD"))
+		return nil
+	}); err != nil {
+		t.Fatalf("ServeHTTP failed: %v", err)
+	}
+	if rec.Header().Get("Etag") != `"origin"` {
+		t.Errorf("The origin ETag must be kept, %q given.", rec.Header().Get("Etag"))
+	}
+}
+
+func TestTargetedCacheControl(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.DefaultCache.TargetedCacheControl = []string{"MyCache-Cache-Control", "CDN-Cache-Control"}
+	handler := NewHTTPCacheHandler(cfg)
+
+	withHeaders := func(headers map[string]string) handlerFunc {
+		return func(w http.ResponseWriter, _ *http.Request) error {
+			for name, value := range headers {
+				w.Header().Set(name, value)
+			}
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte("HELLO_WORLD"))
+			return nil
+		}
+	}
+	serve := func(target string, next handlerFunc) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		if err := handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil), next); err != nil {
+			t.Fatalf("ServeHTTP failed: %v", err)
+		}
+
+		return rec
+	}
+
+	next := withHeaders(map[string]string{
+		"Cache-Control":         "no-store",
+		"CDN-Cache-Control":     "no-store",
+		"MyCache-Cache-Control": "max-age=60, must-revalidate=?1",
+	})
+	for i := 0; i < 2; i++ {
+		rec := serve("http://example.com/targeted", next)
+		if i == 0 && !strings.Contains(rec.Header().Get("Cache-Status"), "stored") {
+			t.Errorf("The first targeted field must be used, Cache-Status %q given.", rec.Header().Get("Cache-Status"))
+		}
+		if i == 1 && !strings.Contains(rec.Header().Get("Cache-Status"), "hit") {
+			t.Errorf("The response must be served from the cache, Cache-Status %q given.", rec.Header().Get("Cache-Status"))
+		}
+		if rec.Header().Get("MyCache-Cache-Control") != "" || rec.Header().Get("CDN-Cache-Control") != "" {
+			t.Errorf("The targeted fields must be stripped, %v given.", rec.Header())
+		}
+		if rec.Header().Get("Cache-Control") != "no-store" {
+			t.Errorf("The Cache-Control header must be kept, %q given.", rec.Header().Get("Cache-Control"))
+		}
+	}
+
+	rec := serve("http://example.com/targeted-invalid", withHeaders(map[string]string{
+		"Cache-Control":         "max-age=60",
+		"CDN-Cache-Control":     "no-store",
+		"MyCache-Cache-Control": "Max-Age=60",
+	}))
+	if !strings.Contains(rec.Header().Get("Cache-Status"), "detail=NO-STORE-DIRECTIVE") {
+		t.Errorf("The invalid targeted field must be ignored, Cache-Status %q given.", rec.Header().Get("Cache-Status"))
+	}
+}
+
+func TestDownstreamCacheControl(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.URLs = map[string]configurationtypes.URL{
+		"example.com/downstream": {
+			DownstreamCacheControl: &configurationtypes.DownstreamCacheControl{
+				MaxAge:          configurationtypes.Duration{Duration: time.Minute},
+				CDNCacheControl: "max-age=600",
+			},
+		},
+	}
+	handler := NewHTTPCacheHandler(cfg)
+	next := func(w http.ResponseWriter, _ *http.Request) error {
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("HELLO_WORLD"))
+		return nil
+	}
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		if err := handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/downstream", nil), next); err != nil {
+			t.Fatalf("ServeHTTP failed: %v", err)
+		}
+		if cc := rec.Header().Get("Cache-Control"); cc != "public, max-age=60" {
+			t.Errorf("The downstream Cache-Control must be rewritten, %q given.", cc)
+		}
+		if rec.Header().Get("CDN-Cache-Control") != "max-age=600" || rec.Header().Get("Expires") == "" {
+			t.Errorf("Unexpected CDN-Cache-Control %q or Expires %q.", rec.Header().Get("CDN-Cache-Control"), rec.Header().Get("Expires"))
+		}
+		if i == 1 && !strings.Contains(rec.Header().Get("Cache-Status"), "hit; ttl=") {
+			t.Errorf("The stored policy must be kept, Cache-Status %q given.", rec.Header().Get("Cache-Status"))
+		}
+	}
+}
+
+func TestCacheStatusMembers(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	serve := func(rq *http.Request, next handlerFunc) string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		if err := handler.ServeHTTP(rec, rq, next); err != nil {
+			t.Fatalf("ServeHTTP failed: %v", err)
+		}
+
+		return strings.Join(rec.Header().Values("Cache-Status"), ", ")
+	}
+	withUpstream := func(w http.ResponseWriter, _ *http.Request) error {
+		w.Header().Set("Cache-Status", "OriginCache; hit")
+		w.Header().Set("Vary", "Accept-Language")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("HELLO_WORLD"))
+		return nil
+	}
+
+	if cs := serve(httptest.NewRequest(http.MethodGet, "http://example.com/members", nil), withUpstream); cs != "OriginCache; hit, Souin; fwd=uri-miss; stored; key=GET-http-example.com-/members" {
+		t.Errorf("The cache member must follow the upstream one, Cache-Status %q given.", cs)
+	}
+	if cs := serve(httptest.NewRequest(http.MethodGet, "http://example.com/members", nil), withUpstream); !strings.HasPrefix(cs, "OriginCache; hit, Souin; hit; ttl=") {
+		t.Errorf("The stored upstream member must be kept on hit, Cache-Status %q given.", cs)
+	}
+
+	rq := httptest.NewRequest(http.MethodGet, "http://example.com/members", nil)
+	rq.Header.Set("Accept-Language", "fr")
+	if cs := serve(rq, withUpstream); !strings.Contains(cs, "Souin; fwd=vary-miss; stored") {
+		t.Errorf("The request must be a vary-miss, Cache-Status %q given.", cs)
+	}
+
+	rq = httptest.NewRequest(http.MethodGet, "http://example.com/members", nil)
+	rq.Header.Set("Cache-Control", "no-cache")
+	if cs := serve(rq, withUpstream); !strings.Contains(cs, "Souin; fwd=request; stored") {
+		t.Errorf("The request must be forwarded because of its directives, Cache-Status %q given.", cs)
+	}
+
+	if cs := serve(httptest.NewRequest(http.MethodPost, "http://example.com/members", nil), withUpstream); cs != "OriginCache; hit, Souin; fwd=method; detail=UNSUPPORTED-METHOD" {
+		t.Errorf("The unsupported method must be reported, Cache-Status %q given.", cs)
+	}
+
+	var wg sync.WaitGroup
+	statuses := make([]string, 2)
+	for i := range statuses {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			statuses[idx] = serve(httptest.NewRequest(http.MethodGet, "http://example.com/collapsed", nil), slowNext("HELLO_WORLD", 100*time.Millisecond))
+		}(i)
+	}
+	wg.Wait()
+	collapsed := 0
+	for _, cs := range statuses {
+		if strings.HasSuffix(cs, "; collapsed") {
+			collapsed++
+		}
+	}
+	if collapsed != 1 {
+		t.Errorf("The coalesced follower must be marked as collapsed, Cache-Status %q given.", statuses)
+	}
+}
+
+func TestHeadServedFromGet(t *testing.T) {
+	serve := func(handler *SouinBaseHandler, method, target string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		if err := handler.ServeHTTP(rec, httptest.NewRequest(method, target, nil), slowNext("HELLO_WORLD", 0)); err != nil {
+			t.Fatalf("ServeHTTP failed: %v", err)
+		}
+
+		return rec
+	}
+
+	handler, _ := newTestHandler(t)
+	serve(handler, http.MethodGet, "http://example.com/head")
+	rec := serve(handler, http.MethodHead, "http://example.com/head")
+	if cs := rec.Header().Get("Cache-Status"); !strings.Contains(cs, "hit") {
+		t.Errorf("The HEAD request must be served from the GET response, Cache-Status %q given.", cs)
+	}
+	if rec.Header().Get("Content-Length") != "11" || rec.Body.Len() != 0 {
+		t.Errorf("The HEAD response must have the GET Content-Length without body, %q and %q given.", rec.Header().Get("Content-Length"), rec.Body.String())
+	}
+
+	serve(handler, http.MethodHead, "http://example.com/head-miss")
+	if cs := serve(handler, http.MethodGet, "http://example.com/head-miss").Header().Get("Cache-Status"); !strings.Contains(cs, "fwd=uri-miss; stored") {
+		t.Errorf("The HEAD response must not be served to GET requests, Cache-Status %q given.", cs)
+	}
+
+	cfg := newTestConfig()
+	cfg.DefaultCache.Key.DisableMethod = true
+	handler = NewHTTPCacheHandler(cfg)
+	if cs := serve(handler, http.MethodHead, "http://example.com/head-shared").Header().Get("Cache-Status"); !strings.Contains(cs, "detail=HEAD-SHARES-GET-KEY") {
+		t.Errorf("The HEAD response must not be stored under the GET key, Cache-Status %q given.", cs)
+	}
+	if rec := serve(handler, http.MethodGet, "http://example.com/head-shared"); !strings.Contains(rec.Header().Get("Cache-Status"), "stored") || rec.Body.String() != "HELLO_WORLD" {
+		t.Errorf("The GET response must be stored, Cache-Status %q given.", rec.Header().Get("Cache-Status"))
+	}
+	if rec := serve(handler, http.MethodHead, "http://example.com/head-shared"); !strings.Contains(rec.Header().Get("Cache-Status"), "hit") || rec.Body.Len() != 0 {
+		t.Errorf("The HEAD request must be served from the GET response, Cache-Status %q given.", rec.Header().Get("Cache-Status"))
+	}
+}
+
+func TestServeStaleOnTimeout(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.DefaultCache.Timeout.Backend = configurationtypes.Duration{Duration: 50 * time.Millisecond}
+	cfg.DefaultCache.ServeStaleOnTimeout = true
+	handler := NewHTTPCacheHandler(cfg)
+	withBody := func(body string, delay time.Duration) handlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) error {
+			w.Header().Set("Cache-Control", "max-age=0")
+			if body != "FIRST" {
+				w.Header().Set("Cache-Control", "max-age=60")
+			}
+			return slowNext(body, delay)(w, r)
+		}
+	}
+	serve := func(next handlerFunc) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		_ = handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/stale-on-timeout", nil), next)
+
+		return rec
+	}
+
+	serve(withBody("FIRST", 0))
+
+	rec := serve(withBody("SECOND", 80*time.Millisecond))
+	if rec.Code != http.StatusOK || rec.Body.String() != "FIRST" {
+		t.Fatalf("The stale response must be served on timeout, %d %q given.", rec.Code, rec.Body.String())
+	}
+	if cs := rec.Header().Get("Cache-Status"); !strings.Contains(cs, "detail=DEADLINE-EXCEEDED; fwd=stale") {
+		t.Errorf("unexpected Cache-Status %q", cs)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if rec = serve(withBody("THIRD", 0)); rec.Body.String() != "SECOND" || !strings.Contains(rec.Header().Get("Cache-Status"), "hit") {
+		t.Errorf("The background upstream call must refresh the cache, %q given with Cache-Status %q.", rec.Body.String(), rec.Header().Get("Cache-Status"))
+	}
+
+	bounded := "http://example.com/stale-on-timeout-bounded"
+	_ = handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, bounded, nil), withBody("FIRST", 0))
+	upstreamErr := make(chan error, 1)
+	rec = httptest.NewRecorder()
+	_ = handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, bounded, nil), func(w http.ResponseWriter, r *http.Request) error {
+		<-r.Context().Done()
+		upstreamErr <- r.Context().Err()
+		return r.Context().Err()
+	})
+	if rec.Body.String() != "FIRST" {
+		t.Errorf("The stale response must be served on timeout, %q given.", rec.Body.String())
+	}
+	select {
+	case err := <-upstreamErr:
+		if err != baseCtx.DeadlineExceeded {
+			t.Errorf("The background upstream call must be bounded by the backend timeout, %v given.", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("The background upstream call must be bounded by the backend timeout.")
+	}
+
+	cfg.DefaultCache.ServeStaleOnTimeout = false
+	handler = NewHTTPCacheHandler(cfg)
+	for _, storer := range handler.Storers {
+		_ = storer.Reset()
+	}
+	serve(withBody("FIRST", 0))
+	if rec = serve(withBody("SECOND", 200*time.Millisecond)); rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("The timeout must be returned when disabled, %d given.", rec.Code)
+	}
+}
+
+func TestCoalescingWait(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.DefaultCache.Coalescing.Wait = configurationtypes.Duration{Duration: 50 * time.Millisecond}
+	handler := NewHTTPCacheHandler(cfg)
+	for _, storer := range handler.Storers {
+		_ = storer.Reset()
+	}
+	var calls atomic.Int32
+	withBody := func(body, cacheControl string, delay time.Duration) handlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) error {
+			calls.Add(1)
+			w.Header().Set("Cache-Control", cacheControl)
+			return slowNext(body, delay)(w, r)
+		}
+	}
+	serve := func(target string, next handlerFunc) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		_ = handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil), next)
+
+		return rec
+	}
+	concurrently := func(target string, leader, follower handlerFunc) *httptest.ResponseRecorder {
+		t.Helper()
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serve(target, leader)
+		}()
+		time.Sleep(10 * time.Millisecond)
+		start := time.Now()
+		rec := serve(target, follower)
+		if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+			t.Errorf("The follower must stop waiting for the leader, %v elapsed.", elapsed)
+		}
+		wg.Wait()
+
+		return rec
+	}
+
+	rec := concurrently("http://example.com/coalescing-wait", withBody("LEADER", "max-age=60", 300*time.Millisecond), withBody("FOLLOWER", "max-age=60", 0))
+	if rec.Body.String() != "FOLLOWER" || strings.Contains(rec.Header().Get("Cache-Status"), "collapsed") {
+		t.Errorf("The follower must request the upstream itself, %q given with Cache-Status %q.", rec.Body.String(), rec.Header().Get("Cache-Status"))
+	}
+	if calls.Load() != 2 {
+		t.Errorf("The upstream must be called by both requests, %d calls given.", calls.Load())
+	}
+
+	cfg.DefaultCache.Coalescing.ServeStale = true
+	serve("http://example.com/coalescing-stale", withBody("FIRST", "max-age=1", 0))
+	time.Sleep(1100 * time.Millisecond)
+	rec = concurrently("http://example.com/coalescing-stale", withBody("SECOND", "max-age=60", 300*time.Millisecond), withBody("THIRD", "max-age=60", 0))
+	if rec.Body.String() != "FIRST" || !strings.Contains(rec.Header().Get("Cache-Status"), "detail=COALESCING-WAIT-EXCEEDED; fwd=stale") {
+		t.Errorf("The follower must get the stale response, %q given with Cache-Status %q.", rec.Body.String(), rec.Header().Get("Cache-Status"))
+	}
+
+	serve("http://example.com/coalescing-revalidation", withBody("FIRST", "no-cache", 0))
+	rec = concurrently("http://example.com/coalescing-revalidation", withBody("SECOND", "no-cache", 300*time.Millisecond), withBody("THIRD", "no-cache", 0))
+	if rec.Body.String() != "FIRST" || !strings.Contains(rec.Header().Get("Cache-Status"), "detail=COALESCING-WAIT-EXCEEDED; fwd=stale") {
+		t.Errorf("The follower revalidation must get the cached response, %q given with Cache-Status %q.", rec.Body.String(), rec.Header().Get("Cache-Status"))
+	}
+}
+
+func TestRefreshEarly(t *testing.T) {
+	response := &http.Response{Header: http.Header{}}
+	response.Header.Set("Date", time.Now().Format(http.TimeFormat))
+	response.Header.Set(rfc.StoredTTLHeader, "10s")
+	if refreshEarly(response, 1, 0.0000001) {
+		t.Error("The response must not be refreshed without the stored upstream latency.")
+	}
+
+	response.Header.Set(rfc.StoredDeltaHeader, "1s")
+	if refreshEarly(response, 1, 1) {
+		t.Error("The fresh response must not be refreshed when the random gap is null.")
+	}
+	if !refreshEarly(response, 1, 0.0000001) {
+		t.Error("The fresh response must be refreshed when the random gap exceeds the expiry.")
+	}
+
+	// The Date header has a second precision, the response expires in 1 to 2 seconds.
+	response.Header.Set("Date", time.Now().Add(-8*time.Second).Format(http.TimeFormat))
+	if !refreshEarly(response, 4, 0.5) {
+		t.Error("The response close to its expiry must be refreshed.")
+	}
+	if refreshEarly(response, 0.01, 0.5) {
+		t.Error("A lower beta must delay the refresh.")
+	}
+}
+
+func TestEarlyExpirationRefreshInBackground(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.DefaultCache.EarlyExpiration = configurationtypes.EarlyExpiration{Enable: true, Beta: 10000}
+	handler := NewHTTPCacheHandler(cfg)
+	defer func() { _ = handler.Cleanup() }()
+	for _, storer := range handler.Storers {
+		_ = storer.Reset()
+	}
+
+	var calls atomic.Int32
+	next := func(w http.ResponseWriter, r *http.Request) error {
+		call := calls.Add(1)
+		w.Header().Set("Cache-Control", "max-age=5")
+		return slowNext(fmt.Sprintf("V%d", call), 20*time.Millisecond)(w, r)
+	}
+	serve := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		_ = handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/early-expiration", nil), next)
+
+		return rec
+	}
+
+	serve()
+	for i := 0; i < 10 && calls.Load() < 2; i++ {
+		if rec := serve(); rec.Body.String() != "V1" {
+			t.Fatalf("The fresh response must be served while refreshed, %q given.", rec.Body.String())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if calls.Load() != 2 {
+		t.Fatalf("The fresh response must be refreshed in background, %d upstream calls given.", calls.Load())
+	}
+	if rec := serve(); rec.Body.String() != "V2" || rec.Header().Get(rfc.StoredDeltaHeader) != "" {
+		t.Errorf("The refreshed response must be served, %q given with the headers %v.", rec.Body.String(), rec.Header())
+	}
+}
@@ -0,0 +1,200 @@
+package middleware
+
+import (
+	"container/list"
+	baseCtx "context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/darkweak/souin/configurationtypes"
+	"github.com/darkweak/souin/pkg/api/prometheus"
+)
+
+var errOriginOverloaded = errors.New("the origin concurrency limit is reached")
+
+type originPriority int
+
+const (
+	// originPriorityClient is used by the requests a client is waiting for.
+	originPriorityClient originPriority = iota
+	// originPriorityBackground is used by the background revalidations and
+	// refreshes, they are dispatched after the queued client requests.
+	originPriorityBackground
+)
+
+const originPriorityCtx ctxKey = "souin_ctx.ORIGIN_PRIORITY"
+
+const defaultOriginQueueSize = 1000
+
+func withOriginPriority(rq *http.Request, priority originPriority) *http.Request {
+	return rq.WithContext(baseCtx.WithValue(rq.Context(), originPriorityCtx, priority))
+}
+
+func getOriginPriority(rq *http.Request) originPriority {
+	if priority, ok := rq.Context().Value(originPriorityCtx).(originPriority); ok {
+		return priority
+	}
+
+	return originPriorityClient
+}
+
+type originWaiter struct {
+	host    string
+	ready   chan struct{}
+	granted bool
+}
+
+// originLimiter bounds the concurrent upstream requests, globally and per
+// host. The requests exceeding the limits wait in a bounded queue ordered
+// by priority.
+type originLimiter struct {
+	maxConcurrent, maxPerHost, queueSize int
+	queueTimeout                         time.Duration
+
+	mu      sync.Mutex
+	active  int
+	perHost map[string]int
+	queues  [originPriorityBackground + 1]*list.List
+	queued  int
+}
+
+// newOriginLimiter returns nil when no limit is configured. The queue size
+// defaults to defaultOriginQueueSize, a negative one rejects the requests
+// exceeding the limits without waiting.
+func newOriginLimiter(c configurationtypes.OriginLimit) *originLimiter {
+	if c.MaxConcurrent <= 0 && c.MaxConcurrentPerHost <= 0 {
+		return nil
+	}
+
+	queueSize := c.QueueSize
+	switch {
+	case queueSize == 0:
+		queueSize = defaultOriginQueueSize
+	case queueSize < 0:
+		queueSize = 0
+	}
+
+	l := &originLimiter{
+		maxConcurrent: c.MaxConcurrent,
+		maxPerHost:    c.MaxConcurrentPerHost,
+		queueSize:     queueSize,
+		queueTimeout:  c.QueueTimeout.Duration,
+		perHost:       make(map[string]int),
+	}
+	for i := range l.queues {
+		l.queues[i] = list.New()
+	}
+
+	return l
+}
+
+func (l *originLimiter) canRun(host string) bool {
+	return (l.maxConcurrent <= 0 || l.active < l.maxConcurrent) &&
+		(l.maxPerHost <= 0 || l.perHost[host] < l.maxPerHost)
+}
+
+func (l *originLimiter) take(host string) {
+	l.active++
+	l.perHost[host]++
+}
+
+// Acquire waits for a slot to request the host. The returned function
+// releases the slot. It returns errOriginOverloaded when the queue is full
+// or the queue timeout is exceeded, and the context error when it's done.
+func (l *originLimiter) Acquire(ctx baseCtx.Context, host string, priority originPriority) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	if l.canRun(host) {
+		l.take(host)
+		l.mu.Unlock()
+
+		return l.releaser(host), nil
+	}
+
+	if l.queued >= l.queueSize {
+		l.mu.Unlock()
+		prometheus.Increment(prometheus.OriginRejectedCounter)
+
+		return nil, errOriginOverloaded
+	}
+
+	waiter := &originWaiter{host: host, ready: make(chan struct{})}
+	element := l.queues[priority].PushBack(waiter)
+	l.queued++
+	prometheus.Set(prometheus.OriginQueueSize, float64(l.queued))
+	l.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if l.queueTimeout > 0 {
+		timer := time.NewTimer(l.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case <-waiter.ready:
+		return l.releaser(host), nil
+	case <-timeout:
+		err = errOriginOverloaded
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if waiter.granted {
+		// The slot was granted while giving up.
+		return l.releaser(host), nil
+	}
+
+	l.queues[priority].Remove(element)
+	l.queued--
+	prometheus.Set(prometheus.OriginQueueSize, float64(l.queued))
+	if err == errOriginOverloaded {
+		prometheus.Increment(prometheus.OriginRejectedCounter)
+	}
+
+	return nil, err
+}
+
+func (l *originLimiter) releaser(host string) func() {
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			l.release(host)
+		})
+	}
+}
+
+func (l *originLimiter) release(host string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.active--
+	if l.perHost[host]--; l.perHost[host] <= 0 {
+		delete(l.perHost, host)
+	}
+
+	for _, queue := range l.queues {
+		for element := queue.Front(); element != nil; {
+			next := element.Next()
+			waiter := element.Value.(*originWaiter)
+			if l.canRun(waiter.host) {
+				queue.Remove(element)
+				l.queued--
+				l.take(waiter.host)
+				waiter.granted = true
+				close(waiter.ready)
+			}
+			element = next
+		}
+	}
+	prometheus.Set(prometheus.OriginQueueSize, float64(l.queued))
+}
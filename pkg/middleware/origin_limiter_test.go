@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	baseCtx "context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/darkweak/souin/configurationtypes"
+)
+
+func TestOriginLimiter(t *testing.T) {
+	if newOriginLimiter(configurationtypes.OriginLimit{}) != nil {
+		t.Fatal("The limiter must be disabled without limit.")
+	}
+	if limiter := newOriginLimiter(configurationtypes.OriginLimit{MaxConcurrent: 1}); limiter.queueSize != defaultOriginQueueSize {
+		t.Errorf("The queue size must default to %d, %d given.", defaultOriginQueueSize, limiter.queueSize)
+	}
+	if limiter := newOriginLimiter(configurationtypes.OriginLimit{MaxConcurrent: 1, QueueSize: -1}); limiter.queueSize != 0 {
+		t.Errorf("A negative queue size must disable the queue, %d given.", limiter.queueSize)
+	}
+
+	limiter := newOriginLimiter(configurationtypes.OriginLimit{
+		MaxConcurrent:        2,
+		MaxConcurrentPerHost: 1,
+		QueueSize:            2,
+		QueueTimeout:         configurationtypes.Duration{Duration: 50 * time.Millisecond},
+	})
+	ctx := baseCtx.Background()
+
+	releaseA, err := limiter.Acquire(ctx, "a", originPriorityClient)
+	if err != nil {
+		t.Fatalf("The first request must be granted: %v", err)
+	}
+	if _, err = limiter.Acquire(ctx, "a", originPriorityClient); err != errOriginOverloaded {
+		t.Errorf("The request exceeding the host limit must wait until the queue timeout, %v given.", err)
+	}
+	releaseB, err := limiter.Acquire(ctx, "b", originPriorityClient)
+	if err != nil {
+		t.Fatalf("The request on another host must be granted: %v", err)
+	}
+
+	order := make(chan originPriority, 2)
+	var wg sync.WaitGroup
+	for _, priority := range []originPriority{originPriorityBackground, originPriorityClient} {
+		wg.Add(1)
+		go func(p originPriority) {
+			defer wg.Done()
+			release, err := limiter.Acquire(ctx, "c", p)
+			if err != nil {
+				t.Errorf("The queued request must be granted: %v", err)
+				return
+			}
+			order <- p
+			release()
+		}(priority)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if _, err = limiter.Acquire(ctx, "d", originPriorityClient); err != errOriginOverloaded {
+		t.Errorf("The request must be rejected when the queue is full, %v given.", err)
+	}
+
+	releaseA()
+	releaseA()
+	wg.Wait()
+	close(order)
+	if first := <-order; first != originPriorityClient {
+		t.Error("The client request must be dispatched before the background one.")
+	}
+
+	releaseB()
+	if limiter.active != 0 || limiter.queued != 0 {
+		t.Errorf("The limiter must be empty, %d active and %d queued given.", limiter.active, limiter.queued)
+	}
+}
+
+func TestOriginOverloaded(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.DefaultCache.OriginLimit = configurationtypes.OriginLimit{
+		MaxConcurrent: 1,
+		QueueSize:     -1,
+		RetryAfter:    configurationtypes.Duration{Duration: 2 * time.Second},
+	}
+	handler := NewHTTPCacheHandler(cfg)
+	for _, storer := range handler.Storers {
+		_ = storer.Reset()
+	}
+	withBody := func(body, cacheControl string, delay time.Duration) handlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) error {
+			w.Header().Set("Cache-Control", cacheControl)
+			return slowNext(body, delay)(w, r)
+		}
+	}
+	serve := func(target string, next handlerFunc) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		_ = handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil), next)
+
+		return rec
+	}
+	whileBusy := func(target string, next handlerFunc) *httptest.ResponseRecorder {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serve("http://example.com/origin-busy", withBody("BUSY", "no-store", 200*time.Millisecond))
+		}()
+		time.Sleep(20 * time.Millisecond)
+		defer wg.Wait()
+
+		return serve(target, next)
+	}
+
+	rec := whileBusy("http://example.com/origin-overloaded", withBody("NEW", "max-age=60", 0))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "2" {
+		t.Errorf("The overflow must return a 503 with Retry-After, %d %v given.", rec.Code, rec.Header())
+	}
+	if cs := rec.Header().Get("Cache-Status"); !strings.Contains(cs, "detail=ORIGIN-OVERLOADED") {
+		t.Errorf("unexpected Cache-Status %q", cs)
+	}
+
+	serve("http://example.com/origin-stale", withBody("FIRST", "max-age=1", 0))
+	time.Sleep(1100 * time.Millisecond)
+	rec = whileBusy("http://example.com/origin-stale", withBody("SECOND", "max-age=60", 0))
+	if rec.Code != http.StatusOK || rec.Body.String() != "FIRST" || !strings.Contains(rec.Header().Get("Cache-Status"), "detail=ORIGIN-OVERLOADED; fwd=stale") {
+		t.Errorf("The stale response must be served on overflow, %d %q given with Cache-Status %q.", rec.Code, rec.Body.String(), rec.Header().Get("Cache-Status"))
+	}
+}
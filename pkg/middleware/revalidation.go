@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	baseCtx "context"
+	"sync"
+	"time"
+
+	"github.com/darkweak/souin/configurationtypes"
+	"github.com/darkweak/souin/pkg/api/prometheus"
+)
+
+const (
+	defaultRevalidationWorkers   = 10
+	defaultRevalidationQueueSize = 1000
+	defaultRevalidationWindow    = time.Second
+)
+
+type revalidationTask struct {
+	key string
+	run func(baseCtx.Context)
+}
+
+// revalidationPool runs the background revalidations with a bounded number
+// of workers, started on the first submitted revalidation. A key is queued at
+// most once at a time and is skipped during the window following its last
+// revalidation.
+type revalidationPool struct {
+	queue   chan revalidationTask
+	workers int
+	window  time.Duration
+	ctx     baseCtx.Context
+	cancel  baseCtx.CancelFunc
+	wg      sync.WaitGroup
+
+	mu       sync.Mutex
+	started  bool
+	closed   bool
+	inFlight map[string]struct{}
+	recent   map[string]time.Time
+}
+
+func newRevalidationPool(c configurationtypes.Revalidation) *revalidationPool {
+	workers, queueSize, window := c.Workers, c.QueueSize, c.Window.Duration
+	if workers <= 0 {
+		workers = defaultRevalidationWorkers
+	}
+	if queueSize <= 0 {
+		queueSize = defaultRevalidationQueueSize
+	}
+	if window == 0 {
+		window = defaultRevalidationWindow
+	}
+
+	ctx, cancel := baseCtx.WithCancel(baseCtx.Background())
+	p := &revalidationPool{
+		queue:    make(chan revalidationTask, queueSize),
+		workers:  workers,
+		window:   window,
+		ctx:      ctx,
+		cancel:   cancel,
+		inFlight: make(map[string]struct{}),
+		recent:   make(map[string]time.Time),
+	}
+
+	return p
+}
+
+// start runs the workers, the caller must hold the lock.
+func (p *revalidationPool) start() {
+	if p.started {
+		return
+	}
+	p.started = true
+
+	p.wg.Add(p.workers)
+	for i := 0; i < p.workers; i++ {
+		go p.work()
+	}
+}
+
+// Submit queues the revalidation of the key. It returns false when the key
+// is already queued, recently revalidated, the queue is full or the pool is
+// closed. The run context is cancelled when the pool is closed.
+func (p *revalidationPool) Submit(key string, run func(baseCtx.Context)) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return false
+	}
+
+	if _, ok := p.inFlight[key]; ok {
+		prometheus.Increment(prometheus.RevalidationDedupCounter)
+		return false
+	}
+	if last, ok := p.recent[key]; ok && time.Since(last) < p.window {
+		prometheus.Increment(prometheus.RevalidationDedupCounter)
+		return false
+	}
+
+	p.start()
+	select {
+	case p.queue <- revalidationTask{key: key, run: run}:
+		p.inFlight[key] = struct{}{}
+		prometheus.Set(prometheus.RevalidationQueueSize, float64(len(p.queue)))
+
+		return true
+	default:
+		prometheus.Increment(prometheus.RevalidationDroppedCounter)
+		return false
+	}
+}
+
+func (p *revalidationPool) work() {
+	defer p.wg.Done()
+
+	for task := range p.queue {
+		prometheus.Set(prometheus.RevalidationQueueSize, float64(len(p.queue)))
+		if p.ctx.Err() == nil {
+			task.run(p.ctx)
+		}
+		p.done(task.key)
+	}
+}
+
+func (p *revalidationPool) done(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.inFlight, key)
+	now := time.Now()
+	p.recent[key] = now
+	time.AfterFunc(p.window, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		if p.recent[key].Equal(now) {
+			delete(p.recent, key)
+		}
+	})
+}
+
+// Close stops accepting revalidations, cancels the running ones, drops the
+// queued ones and waits for the workers to exit.
+func (p *revalidationPool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	p.cancel()
+	close(p.queue)
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
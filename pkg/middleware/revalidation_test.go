@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	baseCtx "context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/darkweak/souin/configurationtypes"
+)
+
+func TestRevalidationPool(t *testing.T) {
+	pool := newRevalidationPool(configurationtypes.Revalidation{
+		Workers:   1,
+		QueueSize: 1,
+		Window:    configurationtypes.Duration{Duration: 100 * time.Millisecond},
+	})
+	if pool.started {
+		t.Error("The workers must not run before the first revalidation.")
+	}
+
+	started, release := make(chan struct{}), make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	if !pool.Submit("a", func(baseCtx.Context) {
+		defer wg.Done()
+		close(started)
+		<-release
+	}) {
+		t.Fatal("The first revalidation must be queued.")
+	}
+	<-started
+
+	if pool.Submit("a", func(baseCtx.Context) {}) {
+		t.Error("The revalidation of an in flight key must be skipped.")
+	}
+	if !pool.Submit("b", func(baseCtx.Context) { wg.Done() }) {
+		t.Error("The revalidation of another key must be queued.")
+	}
+	if pool.Submit("c", func(baseCtx.Context) {}) {
+		t.Error("The revalidation must be dropped when the queue is full.")
+	}
+
+	close(release)
+	wg.Wait()
+	time.Sleep(10 * time.Millisecond)
+	if pool.Submit("a", func(baseCtx.Context) {}) {
+		t.Error("The recently revalidated key must be skipped.")
+	}
+	time.Sleep(150 * time.Millisecond)
+
+	cancelled := make(chan struct{})
+	if !pool.Submit("a", func(ctx baseCtx.Context) {
+		<-ctx.Done()
+		close(cancelled)
+	}) {
+		t.Error("The key must be revalidated again once the window is elapsed.")
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	pool.Close()
+	select {
+	case <-cancelled:
+	default:
+		t.Error("The running revalidation must be cancelled on close.")
+	}
+	if pool.Submit("d", func(baseCtx.Context) {}) {
+		t.Error("The revalidation must be rejected once the pool is closed.")
+	}
+}
+
+func TestRevalidationPool_CloseUnused(t *testing.T) {
+	pool := newRevalidationPool(configurationtypes.Revalidation{})
+	pool.Close()
+	if pool.started || pool.Submit("a", func(baseCtx.Context) {}) {
+		t.Error("The unused pool must be closed without starting its workers.")
+	}
+}
+
+func TestStaleWhileRevalidateDeduplication(t *testing.T) {
+	handler := NewHTTPCacheHandler(newTestConfig())
+	defer func() { _ = handler.Cleanup() }()
+	for _, storer := range handler.Storers {
+		_ = storer.Reset()
+	}
+
+	var calls atomic.Int32
+	next := func(w http.ResponseWriter, r *http.Request) error {
+		calls.Add(1)
+		w.Header().Set("Cache-Control", "max-age=1, stale-while-revalidate=60")
+		return slowNext("HELLO_WORLD", 50*time.Millisecond)(w, r)
+	}
+	serve := func() *httptest.ResponseRecorder {
+		rq := httptest.NewRequest(http.MethodGet, "http://example.com/swr", nil)
+		rq.Header.Set("Cache-Control", "max-stale=60")
+		rec := httptest.NewRecorder()
+		_ = handler.ServeHTTP(rec, rq, next)
+
+		return rec
+	}
+
+	serve()
+	time.Sleep(1100 * time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rec := serve(); rec.Body.String() != "HELLO_WORLD" {
+				t.Errorf("The stale response must be served, %q given.", rec.Body.String())
+			}
+		}()
+	}
+	wg.Wait()
+	time.Sleep(200 * time.Millisecond)
+
+	if c := calls.Load(); c != 2 {
+		t.Errorf("The stale key must be revalidated once in background, %d upstream calls given.", c)
+	}
+}
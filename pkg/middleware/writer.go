@@ -1,14 +1,18 @@
 package middleware
 
 import (
+	"bufio"
 	"bytes"
+	"net"
 	"net/http"
 	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/darkweak/souin/context"
 	"github.com/darkweak/souin/pkg/rfc"
+	"github.com/darkweak/souin/pkg/rules"
 )
 
 type SouinWriterInterface interface {
@@ -38,6 +42,8 @@ type CustomWriter struct {
 	mutex       sync.Mutex
 	statusCode  int
 	headersSent atomic.Bool
+	// hiddenHeaders are removed from the response sent downstream.
+	hiddenHeaders []string
 }
 
 func (r *CustomWriter) handleBuffer(callback func(*bytes.Buffer)) {
@@ -90,6 +96,15 @@ func (r *CustomWriter) Send() (int, error) {
 		b.Reset()
 	})
 
+	if names := rules.FromContext(r.Req.Context()).MatchedNames(); names != "" {
+		rfc.UpdateCacheStatus(r.Header(), func(status *rfc.CacheStatus) {
+			if status.Get("rule") == nil {
+				status.Set("rule", names)
+			}
+		})
+	}
+	rfc.MergeUpstreamCacheStatus(r.Header())
+
 	storedLength := r.Header().Get(rfc.StoredLengthHeader)
 	if storedLength != "" {
 		r.Header().Set("Content-Length", storedLength)
@@ -101,6 +116,13 @@ func (r *CustomWriter) Send() (int, error) {
 
 	r.Header().Del(rfc.StoredLengthHeader)
 	r.Header().Del(rfc.StoredTTLHeader)
+	r.Header().Del(rfc.StoredDeltaHeader)
+	for _, h := range r.hiddenHeaders {
+		r.Header().Del(h)
+	}
+	if rule := context.GetMatchedURL(r.Req.Context()); rule != nil {
+		rfc.ApplyDownstreamCacheControl(r.Header(), rule.DownstreamCacheControl, time.Now())
+	}
 
 	// When the client issued a range request, serve it from the fully cached
 	// body through the standard library. http.ServeContent implements RFC 7233
@@ -149,5 +171,83 @@ func (r *CustomWriter) Send() (int, error) {
 		r.headersSent.Store(true)
 	}
 
+	// HEAD responses keep the representation headers without the content.
+	if r.Req.Method == http.MethodHead {
+		return 0, nil
+	}
+
 	return r.Rw.Write(result)
 }
+
+// detachedWriter collects the headers of an upstream response that is not
+// sent to the client.
+type detachedWriter struct {
+	header http.Header
+}
+
+func (w *detachedWriter) Header() http.Header {
+	return w.header
+}
+
+func (*detachedWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (*detachedWriter) WriteHeader(int) {}
+
+// cacheStatusWriter adds the cache member after the upstream Cache-Status
+// members when the request bypasses the cache.
+type cacheStatusWriter struct {
+	http.ResponseWriter
+	status      *rfc.CacheStatus
+	wroteStatus bool
+}
+
+func newCacheStatusWriter(rw http.ResponseWriter, status *rfc.CacheStatus) *cacheStatusWriter {
+	return &cacheStatusWriter{
+		ResponseWriter: rw,
+		status:         status,
+	}
+}
+
+func (w *cacheStatusWriter) writeCacheStatus() {
+	if w.wroteStatus {
+		return
+	}
+
+	w.wroteStatus = true
+	h := w.Header()
+	rfc.SaveUpstreamCacheStatus(h)
+	rfc.SetCacheStatus(h, w.status)
+	rfc.MergeUpstreamCacheStatus(h)
+}
+
+func (w *cacheStatusWriter) WriteHeader(code int) {
+	w.writeCacheStatus()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cacheStatusWriter) Write(b []byte) (int, error) {
+	w.writeCacheStatus()
+
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *cacheStatusWriter) Flush() {
+	w.writeCacheStatus()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *cacheStatusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+
+	return nil, nil, http.ErrNotSupported
+}
+
+func (w *cacheStatusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
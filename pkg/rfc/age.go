@@ -19,6 +19,17 @@ func validateMaxAgeCachedResponse(res *http.Response, maxAge int, addTime int) *
 
 func ValidateMaxAgeCachedResponse(co *cacheobject.RequestCacheDirectives, res *http.Response) *http.Response {
 	responseCc, _ := cacheobject.ParseResponseCacheControl(HeaderAllCommaSepValuesString(res.Header, "Cache-Control"))
+
+	return ValidateMaxAgeCachedResponseDirectives(co, responseCc, res)
+}
+
+// ValidateMaxAgeCachedResponseDirectives validates the cached response age
+// against the given response directives instead of the Cache-Control ones.
+func ValidateMaxAgeCachedResponseDirectives(co *cacheobject.RequestCacheDirectives, responseCc *cacheobject.ResponseCacheDirectives, res *http.Response) *http.Response {
+	if responseCc == nil {
+		responseCc = &cacheobject.ResponseCacheDirectives{MaxAge: -1, SMaxAge: -1}
+	}
+
 	ma := co.MaxAge
 	if responseCc.MaxAge > -1 {
 		ma = responseCc.MaxAge
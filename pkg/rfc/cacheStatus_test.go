@@ -18,12 +18,12 @@ func TestSetRequestCacheStatus(t *testing.T) {
 		t.Errorf("The Cache-Status must match %s, %s given", "Souin; fwd=request; detail=AHeader", h.Get("Cache-Status"))
 	}
 	SetRequestCacheStatus(&h, "", "Souin")
-	if h.Get("Cache-Status") != "Souin; fwd=request; detail=" {
-		t.Errorf("The Cache-Status must match %s, %s given", "Souin; fwd=request; detail=", h.Get("Cache-Status"))
+	if h.Get("Cache-Status") != `Souin; fwd=request; detail=""` {
+		t.Errorf("The Cache-Status must match %s, %s given", `Souin; fwd=request; detail=""`, h.Get("Cache-Status"))
 	}
 	SetRequestCacheStatus(&h, "A very long header with spaces", "Souin")
-	if h.Get("Cache-Status") != "Souin; fwd=request; detail=A very long header with spaces" {
-		t.Errorf("The Cache-Status must match %s, %s given", "Souin; fwd=request; detail=A very long header with spaces", h.Get("Cache-Status"))
+	if h.Get("Cache-Status") != `Souin; fwd=request; detail="A very long header with spaces"` {
+		t.Errorf("The Cache-Status must match %s, %s given", `Souin; fwd=request; detail="A very long header with spaces"`, h.Get("Cache-Status"))
 	}
 }
 
@@ -61,10 +61,65 @@ func TestGetCacheKeyFromCtx(t *testing.T) {
 
 func TestHitStaleCache(t *testing.T) {
 	h := http.Header{
-		"Cache-Status": []string{"previous value"},
+		"Cache-Status": []string{"Souin; hit; ttl=-2"},
 	}
 	HitStaleCache(&h)
-	if h.Get("Cache-Status") != "previous value; fwd=stale" {
+	if h.Get("Cache-Status") != "Souin; hit; ttl=-2; fwd=stale" {
 		t.Error("HitStaleCache must append the stale directive in the Cache-Status HTTP header")
 	}
 }
+
+func TestCacheStatus(t *testing.T) {
+	status := NewCacheStatus("Souin").Fwd(FwdURIMiss).Stored().Key("GET-http-domain.com-/path?a=b").Detail("DEFAULT")
+	if status.String() != `Souin; fwd=uri-miss; stored; key="GET-http-domain.com-/path?a=b"; detail=DEFAULT` {
+		t.Errorf("unexpected serialized member %s", status.String())
+	}
+
+	status.Set("stored", false).Collapsed().FwdStatus(503)
+	if status.String() != `Souin; fwd=uri-miss; key="GET-http-domain.com-/path?a=b"; detail=DEFAULT; collapsed; fwd-status=503` {
+		t.Errorf("unexpected serialized member %s", status.String())
+	}
+
+	parsed, err := ParseCacheStatus(status.String())
+	if err != nil {
+		t.Fatalf("unexpected parse error %v", err)
+	}
+	if parsed.Name != "Souin" || parsed.Get("key") != "GET-http-domain.com-/path?a=b" || parsed.Get("fwd-status") != int64(503) || parsed.Get("collapsed") != true {
+		t.Errorf("unexpected parsed member %#v", parsed)
+	}
+	if parsed.String() != status.String() {
+		t.Errorf("the parsed member must serialize to %s, %s given", status.String(), parsed.String())
+	}
+
+	for _, invalid := range []string{"", "Souin; hit,", "Souin; Hit", `"Souin`} {
+		if _, err := ParseCacheStatus(invalid); err == nil {
+			t.Errorf("ParseCacheStatus must reject %q", invalid)
+		}
+	}
+	if s, _ := ParseCacheStatus(`"My cache"; hit`); s.String() != `"My cache"; hit` {
+		t.Errorf("unexpected serialized member %s", s.String())
+	}
+}
+
+func TestUpstreamCacheStatus(t *testing.T) {
+	h := http.Header{"Cache-Status": []string{"OriginCache; hit", "CDN; fwd=uri-miss"}}
+	SaveUpstreamCacheStatus(h)
+	if h.Get("Cache-Status") != "" || h.Get(UpstreamCacheStatusHeader) != "OriginCache; hit, CDN; fwd=uri-miss" {
+		t.Errorf("SaveUpstreamCacheStatus must move the upstream members aside, %v given", h)
+	}
+
+	SetCacheStatus(h, NewCacheStatus("Souin").Hit())
+	MergeUpstreamCacheStatus(h)
+	if h.Get("Cache-Status") != "OriginCache; hit, CDN; fwd=uri-miss, Souin; hit" {
+		t.Errorf("unexpected merged Cache-Status %s", h.Get("Cache-Status"))
+	}
+	if h.Get(UpstreamCacheStatusHeader) != "" {
+		t.Error("MergeUpstreamCacheStatus must remove the internal header")
+	}
+
+	h = http.Header{"Cache-Status": []string{"Souin; fwd=bypass"}}
+	MergeUpstreamCacheStatus(h)
+	if h.Get("Cache-Status") != "Souin; fwd=bypass" {
+		t.Errorf("MergeUpstreamCacheStatus must keep the member without upstream ones, %s given", h.Get("Cache-Status"))
+	}
+}
@@ -15,8 +15,176 @@ import (
 const (
 	StoredTTLHeader    = "X-Souin-Stored-TTL"
 	StoredLengthHeader = "X-Souin-Stored-Length"
+	// StoredDeltaHeader keeps the upstream latency measured when the
+	// response was stored.
+	StoredDeltaHeader = "X-Souin-Stored-Delta"
+	// UpstreamCacheStatusHeader keeps the Cache-Status members of the
+	// upstream caches until the response is sent.
+	UpstreamCacheStatusHeader = "X-Souin-Upstream-Cache-Status"
 )
 
+// Forward reasons of the Cache-Status fwd parameter (RFC 9211 section 2.2).
+const (
+	FwdBypass   = "bypass"
+	FwdMethod   = "method"
+	FwdURIMiss  = "uri-miss"
+	FwdVaryMiss = "vary-miss"
+	FwdMiss     = "miss"
+	FwdRequest  = "request"
+	FwdStale    = "stale"
+	FwdPartial  = "partial"
+)
+
+// CacheStatus is the Cache-Status list member describing how the cache
+// handled the request (RFC 9211).
+type CacheStatus struct {
+	Name   string
+	params []sfParam
+}
+
+// NewCacheStatus returns an empty member for the given cache name.
+func NewCacheStatus(name string) *CacheStatus {
+	return &CacheStatus{Name: name}
+}
+
+// ParseCacheStatus parses a single Cache-Status list member.
+func ParseCacheStatus(member string) (*CacheStatus, error) {
+	p := &sfParser{value: strings.TrimSpace(member)}
+	item, err := p.parseItem()
+	if err != nil {
+		return nil, err
+	}
+
+	var name string
+	switch v := item.(type) {
+	case Token:
+		name = string(v)
+	case string:
+		name = v
+	default:
+		return nil, errInvalidStructuredField
+	}
+
+	params, err := p.parseParameters()
+	if err != nil {
+		return nil, err
+	}
+	if p.skip(" "); !p.eof() {
+		return nil, errInvalidStructuredField
+	}
+
+	return &CacheStatus{Name: name, params: params}, nil
+}
+
+// Set sets the parameter value, a false value removes the parameter.
+func (c *CacheStatus) Set(key string, value any) *CacheStatus {
+	c.params = setParam(c.params, key, value)
+
+	return c
+}
+
+// Get returns the parameter value or nil when it's missing.
+func (c *CacheStatus) Get(key string) any {
+	for _, param := range c.params {
+		if param.key == key {
+			return param.value
+		}
+	}
+
+	return nil
+}
+
+// Hit marks the response as served from the cache.
+func (c *CacheStatus) Hit() *CacheStatus {
+	return c.Set("hit", true)
+}
+
+// Fwd sets the reason why the request went forward.
+func (c *CacheStatus) Fwd(reason string) *CacheStatus {
+	return c.Set("fwd", Token(reason))
+}
+
+// FwdStatus sets the status code returned by the next hop.
+func (c *CacheStatus) FwdStatus(code int) *CacheStatus {
+	return c.Set("fwd-status", code)
+}
+
+// TTL sets the remaining freshness lifetime in seconds.
+func (c *CacheStatus) TTL(seconds int) *CacheStatus {
+	return c.Set("ttl", seconds)
+}
+
+// Stored marks the response as stored by the cache.
+func (c *CacheStatus) Stored() *CacheStatus {
+	return c.Set("stored", true)
+}
+
+// Collapsed marks the request as collapsed with other requests.
+func (c *CacheStatus) Collapsed() *CacheStatus {
+	return c.Set("collapsed", true)
+}
+
+// Key sets the cache key. It's kept as a token when possible.
+func (c *CacheStatus) Key(key string) *CacheStatus {
+	return c.Set("key", Token(key))
+}
+
+// Detail sets the implementation specific detail.
+func (c *CacheStatus) Detail(detail string) *CacheStatus {
+	return c.Set("detail", Token(detail))
+}
+
+func (c *CacheStatus) String() string {
+	return serializeItem(Token(c.Name)) + serializeParameters(c.params)
+}
+
+// SetCacheStatus sets the member of the cache as the Cache-Status value.
+func SetCacheStatus(h http.Header, status *CacheStatus) {
+	h.Set("Cache-Status", status.String())
+}
+
+// UpdateCacheStatus applies the update to the member of the cache. Nothing
+// is done when the member is missing or invalid.
+func UpdateCacheStatus(h http.Header, update func(*CacheStatus)) {
+	status, err := ParseCacheStatus(h.Get("Cache-Status"))
+	if err != nil {
+		return
+	}
+
+	update(status)
+	SetCacheStatus(h, status)
+}
+
+// SaveUpstreamCacheStatus moves the Cache-Status members returned by the
+// upstream aside so the cache can set its own member.
+func SaveUpstreamCacheStatus(h http.Header) {
+	values := h.Values("Cache-Status")
+	if len(values) == 0 {
+		return
+	}
+
+	h.Del("Cache-Status")
+	if h.Get(UpstreamCacheStatusHeader) == "" {
+		h.Set(UpstreamCacheStatusHeader, strings.Join(values, ", "))
+	}
+}
+
+// MergeUpstreamCacheStatus appends the member of the cache to the upstream
+// ones. The members are ordered from the origin to the user, the cache
+// member is the last one (RFC 9211 section 2).
+func MergeUpstreamCacheStatus(h http.Header) {
+	upstream := h.Get(UpstreamCacheStatusHeader)
+	if upstream == "" {
+		return
+	}
+
+	h.Del(UpstreamCacheStatusHeader)
+	if member := h.Get("Cache-Status"); member != "" {
+		upstream += ", " + member
+	}
+	h.Set("Cache-Status", upstream)
+}
+
 var emptyHeaders = []string{"Expires", "Last-Modified"}
 
 func validateTimeHeader(headers *http.Header, h, t, cacheName string) bool {
@@ -39,7 +207,7 @@ func validateEmptyHeaders(headers *http.Header, cacheName string) {
 
 // SetRequestCacheStatus set the Cache-Status fwd=request
 func SetRequestCacheStatus(h *http.Header, header, cacheName string) {
-	h.Set("Cache-Status", cacheName+"; fwd=request; detail="+header)
+	SetCacheStatus(*h, NewCacheStatus(cacheName).Fwd(FwdRequest).Detail(header))
 }
 
 // ValidateCacheControl check the Cache-Control header
@@ -77,7 +245,9 @@ func GetCacheKeyFromCtx(currentCtx ctx.Context) string {
 
 // HitStaleCache set hit and stale in the Cache-Status header
 func HitStaleCache(h *http.Header) {
-	h.Set("Cache-Status", h.Get("Cache-Status")+"; fwd=stale")
+	UpdateCacheStatus(*h, func(status *CacheStatus) {
+		status.Fwd(FwdStale)
+	})
 }
 
 func manageAge(h *http.Header, ttl time.Duration, cacheName, key, storerName string) {
@@ -118,8 +288,7 @@ func manageAge(h *http.Header, ttl time.Duration, cacheName, key, storerName str
 	cage := int(math.Ceil(apparentAge.Seconds()))
 	age := strconv.Itoa(oldAge + cage)
 	h.Set("Age", age)
-	ttlValue := strconv.Itoa(int(ttl.Seconds()) - cage)
-	h.Set("Cache-Status", cacheName+"; hit; ttl="+ttlValue+"; key="+key+"; detail="+storerName)
+	SetCacheStatus(*h, NewCacheStatus(cacheName).Hit().TTL(int(ttl.Seconds())-cage).Key(key).Detail(storerName))
 }
 
 func setMalformedHeader(headers *http.Header, header, cacheName string) {
@@ -0,0 +1,176 @@
+package rfc
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/darkweak/souin/configurationtypes"
+)
+
+type directive struct {
+	name  string
+	value string
+}
+
+// splitDirectives splits a Cache-Control value on the commas that are not
+// part of a quoted string.
+func splitDirectives(value string) []directive {
+	directives := []directive{}
+	quoted := false
+	start := 0
+
+	add := func(raw string) {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			return
+		}
+
+		name, value, _ := strings.Cut(raw, "=")
+		directives = append(directives, directive{name: strings.ToLower(strings.TrimSpace(name)), value: strings.TrimSpace(value)})
+	}
+
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				add(value[start:i])
+				start = i + 1
+			}
+		}
+	}
+	add(value[start:])
+
+	return directives
+}
+
+func joinDirectives(directives []directive) string {
+	values := make([]string, 0, len(directives))
+	for _, d := range directives {
+		if d.value != "" {
+			values = append(values, d.name+"="+d.value)
+		} else {
+			values = append(values, d.name)
+		}
+	}
+
+	return strings.Join(values, ", ")
+}
+
+func findDirective(directives []directive, name string) int {
+	return slices.IndexFunc(directives, func(d directive) bool {
+		return d.name == name
+	})
+}
+
+func directiveSeconds(directives []directive, name string) (int, bool) {
+	if i := findDirective(directives, name); i >= 0 {
+		if seconds, err := strconv.Atoi(strings.Trim(directives[i].value, `"`)); err == nil {
+			return seconds, true
+		}
+	}
+
+	return 0, false
+}
+
+// capDirective lowers the directive value to the given duration. The
+// directive is added when it's missing and add is true.
+func capDirective(directives []directive, name string, limit time.Duration, add bool) []directive {
+	if limit <= 0 {
+		return directives
+	}
+
+	capped := strconv.Itoa(int(limit.Seconds()))
+	i := findDirective(directives, name)
+	if i < 0 {
+		if add {
+			directives = append(directives, directive{name: name, value: capped})
+		}
+
+		return directives
+	}
+
+	if seconds, ok := directiveSeconds(directives, name); !ok || time.Duration(seconds)*time.Second > limit {
+		directives[i].value = capped
+	}
+
+	return directives
+}
+
+// ApplyDownstreamCacheControl rewrites the Cache-Control sent to the client
+// with the given policy, the stored response keeps its own policy. The Age
+// and Expires headers are recomputed so the client never considers the
+// response fresh longer than the cache does.
+func ApplyDownstreamCacheControl(headers http.Header, policy *configurationtypes.DownstreamCacheControl, now time.Time) {
+	if policy == nil {
+		return
+	}
+
+	stored := splitDirectives(HeaderAllCommaSepValuesString(headers, "Cache-Control"))
+	directives := stored
+	if policy.Value != "" {
+		directives = splitDirectives(policy.Value)
+	}
+	directives = slices.Clone(directives)
+
+	if visibility := strings.ToLower(policy.Visibility); visibility == "public" || visibility == "private" {
+		directives = slices.DeleteFunc(directives, func(d directive) bool {
+			return d.name == "public" || d.name == "private"
+		})
+		directives = append([]directive{{name: visibility}}, directives...)
+	}
+
+	uncacheable := findDirective(directives, "no-store") >= 0 || findDirective(directives, "no-cache") >= 0
+	directives = capDirective(directives, "max-age", policy.MaxAge.Duration, !uncacheable)
+	directives = capDirective(directives, "s-maxage", policy.SMaxAge.Duration, !uncacheable)
+	directives = capDirective(directives, "stale-while-revalidate", policy.StaleWhileRevalidate.Duration, false)
+	directives = capDirective(directives, "stale-if-error", policy.StaleIfError.Duration, false)
+
+	if len(directives) == 0 {
+		headers.Del("Cache-Control")
+	} else {
+		headers.Set("Cache-Control", joinDirectives(directives))
+	}
+
+	if policy.CDNCacheControl != "" {
+		headers.Set("CDN-Cache-Control", policy.CDNCacheControl)
+	}
+
+	maxAge, hasMaxAge := directiveSeconds(directives, "max-age")
+	if uncacheable || !hasMaxAge {
+		if uncacheable {
+			headers.Del("Expires")
+		}
+
+		return
+	}
+
+	age, hasAge := 0, headers.Get("Age") != ""
+	if hasAge {
+		age, _ = strconv.Atoi(headers.Get("Age"))
+	}
+
+	// The remaining stored freshness bounds the client one.
+	downstreamAge := 0
+	storedMaxAge, hasStoredMaxAge := directiveSeconds(stored, "s-maxage")
+	if !hasStoredMaxAge {
+		storedMaxAge, hasStoredMaxAge = directiveSeconds(stored, "max-age")
+	}
+	if hasStoredMaxAge {
+		downstreamAge = max(0, maxAge-(storedMaxAge-age))
+	}
+	downstreamAge = min(downstreamAge, maxAge)
+
+	if hasAge || downstreamAge > 0 {
+		headers.Set("Age", strconv.Itoa(downstreamAge))
+	}
+	headers.Set("Expires", now.Add(time.Duration(maxAge-downstreamAge)*time.Second).UTC().Format(http.TimeFormat))
+}
@@ -0,0 +1,52 @@
+package rfc
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/darkweak/souin/configurationtypes"
+)
+
+func Test_ApplyDownstreamCacheControl(t *testing.T) {
+	now := time.Now()
+
+	headers := http.Header{"Cache-Control": []string{`public, max-age=3600, stale-while-revalidate=600, private="Set-Cookie, Foo"`}}
+	ApplyDownstreamCacheControl(headers, &configurationtypes.DownstreamCacheControl{
+		MaxAge:               configurationtypes.Duration{Duration: time.Minute},
+		StaleWhileRevalidate: configurationtypes.Duration{Duration: 10 * time.Second},
+		StaleIfError:         configurationtypes.Duration{Duration: 10 * time.Second},
+		Visibility:           "private",
+		CDNCacheControl:      "max-age=3600",
+	}, now)
+	if cc := headers.Get("Cache-Control"); cc != "private, max-age=60, stale-while-revalidate=10" {
+		t.Errorf("Unexpected downstream Cache-Control %q.", cc)
+	}
+	if headers.Get("CDN-Cache-Control") != "max-age=3600" {
+		t.Errorf("The CDN-Cache-Control header should be emitted, %q given.", headers.Get("CDN-Cache-Control"))
+	}
+	if headers.Get("Expires") != now.Add(time.Minute).UTC().Format(http.TimeFormat) || headers.Get("Age") != "" {
+		t.Errorf("Unexpected Expires %q or Age %q.", headers.Get("Expires"), headers.Get("Age"))
+	}
+
+	headers = http.Header{"Cache-Control": []string{"max-age=100"}, "Age": []string{"70"}}
+	ApplyDownstreamCacheControl(headers, &configurationtypes.DownstreamCacheControl{MaxAge: configurationtypes.Duration{Duration: time.Minute}}, now)
+	if headers.Get("Cache-Control") != "max-age=60" || headers.Get("Age") != "30" {
+		t.Errorf("The client freshness must be bounded by the stored one, Cache-Control %q and Age %q given.", headers.Get("Cache-Control"), headers.Get("Age"))
+	}
+	if headers.Get("Expires") != now.Add(30*time.Second).UTC().Format(http.TimeFormat) {
+		t.Errorf("Unexpected Expires %q.", headers.Get("Expires"))
+	}
+
+	headers = http.Header{"Cache-Control": []string{"max-age=100"}, "Expires": []string{now.Format(http.TimeFormat)}}
+	ApplyDownstreamCacheControl(headers, &configurationtypes.DownstreamCacheControl{Value: "no-store", MaxAge: configurationtypes.Duration{Duration: time.Minute}}, now)
+	if headers.Get("Cache-Control") != "no-store" || headers.Get("Expires") != "" {
+		t.Errorf("The value must replace the Cache-Control, Cache-Control %q and Expires %q given.", headers.Get("Cache-Control"), headers.Get("Expires"))
+	}
+
+	headers = http.Header{"Cache-Control": []string{"max-age=100"}}
+	ApplyDownstreamCacheControl(headers, nil, now)
+	if headers.Get("Cache-Control") != "max-age=100" {
+		t.Errorf("A nil policy must not change the headers, %q given.", headers.Get("Cache-Control"))
+	}
+}
@@ -0,0 +1,59 @@
+package rfc
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// volatileHeaders change between two responses with the same representation
+// so they are never part of the generated ETag.
+var volatileHeaders = []string{
+	"Age",
+	"Cache-Control",
+	"Cache-Status",
+	"Date",
+	"Expires",
+	"Last-Modified",
+	"Server-Timing",
+	"Set-Cookie",
+	"Surrogate-Control",
+	StoredDeltaHeader,
+	StoredLengthHeader,
+	StoredTTLHeader,
+	UpstreamCacheStatusHeader,
+}
+
+// GenerateETag computes a validator from the body and the non-volatile
+// response headers that are not excluded.
+func GenerateETag(body []byte, headers http.Header, weak bool, excludedHeaders []string) string {
+	excluded := slices.Clone(volatileHeaders)
+	for _, name := range excludedHeaders {
+		excluded = append(excluded, http.CanonicalHeaderKey(name))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		if !slices.Contains(excluded, http.CanonicalHeaderKey(name)) {
+			names = append(names, http.CanonicalHeaderKey(name))
+		}
+	}
+	sort.Strings(names)
+
+	digest := xxhash.New()
+	for _, name := range names {
+		_, _ = digest.WriteString(name + ":" + strings.Join(headers.Values(name), ",") + "\n")
+	}
+	_, _ = digest.Write(body)
+
+	etag := `"` + strconv.FormatUint(digest.Sum64(), 16) + `"`
+	if weak {
+		etag = "W/" + etag
+	}
+
+	return etag
+}
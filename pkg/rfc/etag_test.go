@@ -0,0 +1,38 @@
+package rfc
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func Test_GenerateETag(t *testing.T) {
+	headers := http.Header{
+		"Content-Type": []string{"text/plain"},
+		"Date":         []string{"Mon, 02 Jan 2006 15:04:05 GMT"},
+		"X-Request-Id": []string{"first"},
+	}
+
+	etag := GenerateETag([]byte("Hello"), headers, false, []string{"x-request-id"})
+	if !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) {
+		t.Errorf("The generated ETag should be a quoted strong validator, %s given.", etag)
+	}
+
+	headers.Set("Date", "Tue, 03 Jan 2006 15:04:05 GMT")
+	headers.Set("X-Request-Id", "second")
+	if other := GenerateETag([]byte("Hello"), headers, false, []string{"x-request-id"}); other != etag {
+		t.Errorf("The volatile and excluded headers must not change the ETag, %s and %s given.", etag, other)
+	}
+
+	if other := GenerateETag([]byte("Hello"), headers, false, nil); other == etag {
+		t.Error("The non excluded headers must change the ETag.")
+	}
+
+	if other := GenerateETag([]byte("World"), headers, false, []string{"x-request-id"}); other == etag {
+		t.Error("The body must change the ETag.")
+	}
+
+	if weak := GenerateETag([]byte("Hello"), headers, true, []string{"x-request-id"}); weak != "W/"+etag {
+		t.Errorf("The weak ETag should be W/%s, %s given.", etag, weak)
+	}
+}
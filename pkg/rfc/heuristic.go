@@ -0,0 +1,59 @@
+package rfc
+
+import (
+	"net/http"
+	"time"
+)
+
+// DefaultHeuristicFraction is the fraction of the time since the
+// Last-Modified date used as heuristic lifetime when none is configured.
+const DefaultHeuristicFraction = 0.1
+
+// isHeuristicallyCacheableCode returns true for the status codes defined as
+// heuristically cacheable by the RFC 9110 section 15.1.
+func isHeuristicallyCacheableCode(code int) bool {
+	switch code {
+	case 200, 203, 204, 206, 300, 301, 308, 404, 405, 410, 414, 501:
+		return true
+	}
+
+	return false
+}
+
+// HeuristicFreshness computes the heuristic freshness lifetime described in
+// the RFC 9111 section 4.2.2 as a fraction of the interval between the Date and
+// the Last-Modified headers, clamped between min and max when they are set.
+// It returns false when the response is not eligible.
+func HeuristicFreshness(code int, headers http.Header, fraction float64, min, max time.Duration) (time.Duration, bool) {
+	if !isHeuristicallyCacheableCode(code) {
+		return 0, false
+	}
+
+	lastModified, err := http.ParseTime(headers.Get("Last-Modified"))
+	if err != nil {
+		return 0, false
+	}
+
+	date, err := http.ParseTime(headers.Get("Date"))
+	if err != nil {
+		date = time.Now()
+	}
+
+	if fraction <= 0 {
+		fraction = DefaultHeuristicFraction
+	}
+
+	lifetime := time.Duration(float64(date.Sub(lastModified)) * fraction)
+	if lifetime <= 0 {
+		return 0, false
+	}
+
+	if min > 0 && lifetime < min {
+		lifetime = min
+	}
+	if max > 0 && lifetime > max {
+		lifetime = max
+	}
+
+	return lifetime, true
+}
@@ -0,0 +1,39 @@
+package rfc
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_HeuristicFreshness(t *testing.T) {
+	now := time.Now().UTC()
+	headers := http.Header{
+		"Date":          []string{now.Format(http.TimeFormat)},
+		"Last-Modified": []string{now.Add(-10 * time.Hour).Format(http.TimeFormat)},
+	}
+
+	if lifetime, ok := HeuristicFreshness(http.StatusOK, headers, 0, 0, 0); !ok || lifetime != time.Hour {
+		t.Errorf("The heuristic lifetime should be 1h with the default fraction, %v given.", lifetime)
+	}
+	if lifetime, ok := HeuristicFreshness(http.StatusOK, headers, 0.5, 0, 0); !ok || lifetime != 5*time.Hour {
+		t.Errorf("The heuristic lifetime should be 5h with a 0.5 fraction, %v given.", lifetime)
+	}
+	if lifetime, _ := HeuristicFreshness(http.StatusOK, headers, 0.1, 2*time.Hour, 0); lifetime != 2*time.Hour {
+		t.Errorf("The heuristic lifetime should be clamped to the min value, %v given.", lifetime)
+	}
+	if lifetime, _ := HeuristicFreshness(http.StatusOK, headers, 0.1, 0, 10*time.Minute); lifetime != 10*time.Minute {
+		t.Errorf("The heuristic lifetime should be clamped to the max value, %v given.", lifetime)
+	}
+	if _, ok := HeuristicFreshness(http.StatusCreated, headers, 0.1, 0, 0); ok {
+		t.Error("The 201 status code is not heuristically cacheable.")
+	}
+	if _, ok := HeuristicFreshness(http.StatusOK, http.Header{}, 0.1, 0, 0); ok {
+		t.Error("The heuristic lifetime requires a Last-Modified header.")
+	}
+
+	headers.Set("Last-Modified", now.Add(time.Hour).Format(http.TimeFormat))
+	if _, ok := HeuristicFreshness(http.StatusOK, headers, 0.1, 0, 0); ok {
+		t.Error("A Last-Modified date in the future must not give a heuristic lifetime.")
+	}
+}
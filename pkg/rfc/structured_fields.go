@@ -0,0 +1,267 @@
+package rfc
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var errInvalidStructuredField = errors.New("invalid structured field")
+
+// Token is a structured field token (RFC 8941 section 3.3.4). It's
+// serialized as a string when the value isn't a valid token.
+type Token string
+
+type sfParam struct {
+	key   string
+	value any
+}
+
+type sfParser struct {
+	value string
+	pos   int
+}
+
+func (p *sfParser) eof() bool {
+	return p.pos >= len(p.value)
+}
+
+func (p *sfParser) skip(chars string) {
+	for !p.eof() && strings.IndexByte(chars, p.value[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func isLcalpha(c byte) bool {
+	return c >= 'a' && c <= 'z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isTokenChar(c byte) bool {
+	return c > ' ' && c < 0x7f && !strings.ContainsRune("\"(),;<=>?@[\\]{}", rune(c))
+}
+
+func isToken(value string) bool {
+	if value == "" || (!isLcalpha(value[0]) && (value[0] < 'A' || value[0] > 'Z') && value[0] != '*') {
+		return false
+	}
+
+	for i := 1; i < len(value); i++ {
+		if !isTokenChar(value[i]) && value[i] != ':' && value[i] != '/' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (p *sfParser) parseKey() (string, error) {
+	if p.eof() || (!isLcalpha(p.value[p.pos]) && p.value[p.pos] != '*') {
+		return "", errInvalidStructuredField
+	}
+
+	start := p.pos
+	for !p.eof() {
+		c := p.value[p.pos]
+		if !isLcalpha(c) && !isDigit(c) && !strings.ContainsRune("_-.*", rune(c)) {
+			break
+		}
+		p.pos++
+	}
+
+	return p.value[start:p.pos], nil
+}
+
+// parseItem returns the bare item as an int64, a float64, a string, a
+// Token, a bool or a []byte.
+func (p *sfParser) parseItem() (any, error) {
+	if p.eof() {
+		return nil, errInvalidStructuredField
+	}
+
+	switch c := p.value[p.pos]; {
+	case c == '-' || isDigit(c):
+		start := p.pos
+		p.pos++
+		decimal := false
+		for !p.eof() && (isDigit(p.value[p.pos]) || p.value[p.pos] == '.') {
+			decimal = decimal || p.value[p.pos] == '.'
+			p.pos++
+		}
+		raw := p.value[start:p.pos]
+		if decimal {
+			f, e := strconv.ParseFloat(raw, 64)
+			if e != nil {
+				return nil, errInvalidStructuredField
+			}
+
+			return f, nil
+		}
+		i, e := strconv.ParseInt(raw, 10, 64)
+		if e != nil || len(strings.TrimPrefix(raw, "-")) > 15 {
+			return nil, errInvalidStructuredField
+		}
+
+		return i, nil
+	case c == '"':
+		p.pos++
+		var b strings.Builder
+		for !p.eof() {
+			c = p.value[p.pos]
+			p.pos++
+			switch {
+			case c == '\\':
+				if p.eof() || (p.value[p.pos] != '"' && p.value[p.pos] != '\\') {
+					return nil, errInvalidStructuredField
+				}
+				b.WriteByte(p.value[p.pos])
+				p.pos++
+			case c == '"':
+				return b.String(), nil
+			case c < ' ' || c >= 0x7f:
+				return nil, errInvalidStructuredField
+			default:
+				b.WriteByte(c)
+			}
+		}
+
+		return nil, errInvalidStructuredField
+	case c == '?':
+		if p.pos+1 >= len(p.value) || (p.value[p.pos+1] != '0' && p.value[p.pos+1] != '1') {
+			return nil, errInvalidStructuredField
+		}
+		p.pos += 2
+
+		return p.value[p.pos-1] == '1', nil
+	case c == ':':
+		end := strings.IndexByte(p.value[p.pos+1:], ':')
+		if end < 0 {
+			return nil, errInvalidStructuredField
+		}
+		raw, e := base64.StdEncoding.DecodeString(p.value[p.pos+1 : p.pos+1+end])
+		if e != nil {
+			return nil, errInvalidStructuredField
+		}
+		p.pos += end + 2
+
+		return raw, nil
+	case isLcalpha(c) || (c >= 'A' && c <= 'Z') || c == '*':
+		start := p.pos
+		for !p.eof() && (isTokenChar(p.value[p.pos]) || p.value[p.pos] == ':' || p.value[p.pos] == '/') {
+			p.pos++
+		}
+
+		return Token(p.value[start:p.pos]), nil
+	}
+
+	return nil, errInvalidStructuredField
+}
+
+func (p *sfParser) parseParameters() ([]sfParam, error) {
+	params := []sfParam{}
+	for !p.eof() && p.value[p.pos] == ';' {
+		p.pos++
+		p.skip(" ")
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+
+		var value any = true
+		if !p.eof() && p.value[p.pos] == '=' {
+			p.pos++
+			if value, err = p.parseItem(); err != nil {
+				return nil, err
+			}
+		}
+		params = setParam(params, key, value)
+	}
+
+	return params, nil
+}
+
+// setParam replaces the parameter value in place or appends it, a false
+// value removes the parameter.
+func setParam(params []sfParam, key string, value any) []sfParam {
+	for i, param := range params {
+		if param.key == key {
+			if value == false {
+				return append(params[:i], params[i+1:]...)
+			}
+			params[i].value = value
+
+			return params
+		}
+	}
+
+	if value == false {
+		return params
+	}
+
+	return append(params, sfParam{key: key, value: value})
+}
+
+func serializeString(value string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < ' ' || c >= 0x7f {
+			continue
+		}
+		if c == '"' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	b.WriteByte('"')
+
+	return b.String()
+}
+
+func serializeItem(value any) string {
+	switch v := value.(type) {
+	case Token:
+		if isToken(string(v)) {
+			return string(v)
+		}
+
+		return serializeString(string(v))
+	case string:
+		return serializeString(v)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		if v {
+			return "?1"
+		}
+
+		return "?0"
+	case []byte:
+		return ":" + base64.StdEncoding.EncodeToString(v) + ":"
+	}
+
+	return serializeString("")
+}
+
+func serializeParameters(params []sfParam) string {
+	var b strings.Builder
+	for _, param := range params {
+		b.WriteString("; ")
+		b.WriteString(param.key)
+		if param.value != true {
+			b.WriteByte('=')
+			b.WriteString(serializeItem(param.value))
+		}
+	}
+
+	return b.String()
+}
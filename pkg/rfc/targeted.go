@@ -0,0 +1,94 @@
+package rfc
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// parseBareItem returns the directive value with the Cache-Control syntax,
+// ok is false when the value type is not usable as a directive value.
+func (p *sfParser) parseBareItem() (value string, ok bool, err error) {
+	item, err := p.parseItem()
+	if err != nil {
+		return "", false, err
+	}
+
+	switch v := item.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10), true, nil
+	case string:
+		return strconv.Quote(v), true, nil
+	case Token:
+		return string(v), true, nil
+	case bool:
+		return "", v, nil
+	}
+
+	return "", false, nil
+}
+
+// ParseTargetedCacheControl parses a targeted cache control field as a
+// structured field dictionary (RFC 9213 section 2.1) and returns its
+// directives with the Cache-Control syntax. The directives with an
+// unexpected value type are ignored and an invalid field returns an error.
+func ParseTargetedCacheControl(value string) (string, error) {
+	p := &sfParser{value: strings.TrimSpace(value)}
+	directives := []string{}
+	positions := map[string]int{}
+
+	for !p.eof() {
+		key, err := p.parseKey()
+		if err != nil {
+			return "", err
+		}
+
+		directive, ok := "", true
+		if !p.eof() && p.value[p.pos] == '=' {
+			p.pos++
+			if !p.eof() && p.value[p.pos] == '(' {
+				return "", errInvalidStructuredField
+			}
+			directive, ok, err = p.parseBareItem()
+			if err != nil {
+				return "", err
+			}
+		}
+		if _, err = p.parseParameters(); err != nil {
+			return "", err
+		}
+
+		// The last occurrence of a dictionary key overrides the previous ones.
+		if position, found := positions[key]; found {
+			directives[position] = ""
+		}
+		if ok {
+			if directive != "" {
+				directive = key + "=" + directive
+			} else {
+				directive = key
+			}
+			positions[key] = len(directives)
+			directives = append(directives, directive)
+		} else {
+			delete(positions, key)
+		}
+
+		p.skip(" \t")
+		if p.eof() {
+			break
+		}
+		if p.value[p.pos] != ',' {
+			return "", errInvalidStructuredField
+		}
+		p.pos++
+		p.skip(" \t")
+		if p.eof() {
+			return "", errInvalidStructuredField
+		}
+	}
+
+	return strings.Join(slices.DeleteFunc(directives, func(directive string) bool {
+		return directive == ""
+	}), ", "), nil
+}
@@ -0,0 +1,37 @@
+package rfc
+
+import "testing"
+
+func Test_ParseTargetedCacheControl(t *testing.T) {
+	for value, expected := range map[string]string{
+		"max-age=60":                                   "max-age=60",
+		"max-age=60, must-revalidate":                  "max-age=60, must-revalidate",
+		"no-store=?1, private=?0":                      "no-store",
+		`private="Set-Cookie", s-maxage=10`:            `private="Set-Cookie", s-maxage=10`,
+		"max-age=60;foo=bar, stale-while-revalidate=5": "max-age=60, stale-while-revalidate=5",
+		"max-age=60, max-age=10":                       "max-age=10",
+		"max-age=1.5, public":                          "public",
+		"":                                             "",
+	} {
+		actual, err := ParseTargetedCacheControl(value)
+		if err != nil {
+			t.Errorf("The value %q should be valid, %v given.", value, err)
+		}
+		if actual != expected {
+			t.Errorf("The value %q should be converted to %q, %q given.", value, expected, actual)
+		}
+	}
+
+	for _, value := range []string{
+		"Max-Age=60",
+		"max-age=60,",
+		"max-age=60 public",
+		`private="unterminated`,
+		"max-age=(1 2)",
+		"max-age=?2",
+	} {
+		if _, err := ParseTargetedCacheControl(value); err == nil {
+			t.Errorf("The value %q should be invalid.", value)
+		}
+	}
+}
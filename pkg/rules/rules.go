@@ -0,0 +1,315 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/darkweak/souin/configurationtypes"
+	"github.com/darkweak/storages/core"
+	"github.com/google/cel-go/cel"
+)
+
+type ctxKey string
+
+const resultCtx ctxKey = "souin_ctx.RULES_RESULT"
+
+const (
+	requestVariable  = "request"
+	responseVariable = "response"
+)
+
+type rule struct {
+	configurationtypes.Rule
+	program       cel.Program
+	keyComponents []cel.Program
+	responsePhase bool
+}
+
+// Engine evaluates the compiled cache rules in their declaration order.
+type Engine struct {
+	rules []rule
+}
+
+// Result contains the merged actions of the matching rules, the later
+// matching rules override the scalar actions of the previous ones.
+type Result struct {
+	Bypass        bool
+	TTL           time.Duration
+	Stale         time.Duration
+	KeyComponents []string
+	SurrogateKeys []string
+	Storers       []string
+	StripHeaders  []string
+	Matched       []string
+
+	requestMatches []bool
+}
+
+func newEnv(withResponse bool) (*cel.Env, error) {
+	options := []cel.EnvOption{
+		cel.Variable(requestVariable, cel.MapType(cel.StringType, cel.DynType)),
+	}
+	if withResponse {
+		options = append(options, cel.Variable(responseVariable, cel.MapType(cel.StringType, cel.DynType)))
+	}
+
+	return cel.NewEnv(options...)
+}
+
+func compile(env *cel.Env, expression string) (cel.Program, error) {
+	ast, issues := env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+
+	return env.Program(ast)
+}
+
+func compileCondition(env *cel.Env, expression string) (cel.Program, error) {
+	ast, issues := env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+
+	if !ast.OutputType().IsExactType(cel.BoolType) && !ast.OutputType().IsExactType(cel.DynType) {
+		return nil, fmt.Errorf("the condition must return a bool, %s given", ast.OutputType())
+	}
+
+	return env.Program(ast)
+}
+
+func referencesVariable(ast *cel.Ast, name string) bool {
+	for _, reference := range ast.NativeRep().ReferenceMap() {
+		if reference.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func newRule(requestEnv, responseEnv *cel.Env, r configurationtypes.Rule) (*rule, error) {
+	compiled := &rule{Rule: r}
+
+	// The condition referencing the response is compiled against the response
+	// environment and evaluated once the upstream response is known.
+	ast, issues := responseEnv.Compile(r.Condition)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	env := requestEnv
+	if referencesVariable(ast, responseVariable) {
+		env = responseEnv
+		compiled.responsePhase = true
+	}
+
+	program, err := compileCondition(env, r.Condition)
+	if err != nil {
+		return nil, err
+	}
+	compiled.program = program
+
+	for _, component := range r.KeyComponents {
+		componentProgram, err := compile(requestEnv, component)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key component %s: %w", component, err)
+		}
+		compiled.keyComponents = append(compiled.keyComponents, componentProgram)
+	}
+
+	if compiled.responsePhase && len(compiled.keyComponents) > 0 {
+		return nil, fmt.Errorf("the key components can't be used with a condition on the response")
+	}
+
+	return compiled, nil
+}
+
+// NewEngine compiles the given rules, the invalid ones are logged and skipped.
+// It returns nil when there is no valid rule.
+func NewEngine(configurationRules []configurationtypes.Rule, logger core.Logger) *Engine {
+	if len(configurationRules) == 0 {
+		return nil
+	}
+
+	requestEnv, err := newEnv(false)
+	if err != nil {
+		logger.Errorf("Impossible to create the rules request environment: %v", err)
+		return nil
+	}
+	responseEnv, err := newEnv(true)
+	if err != nil {
+		logger.Errorf("Impossible to create the rules response environment: %v", err)
+		return nil
+	}
+
+	engine := &Engine{}
+	for i, r := range configurationRules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule-%d", i)
+		}
+
+		compiled, err := newRule(requestEnv, responseEnv, r)
+		if err != nil {
+			logger.Errorf("Impossible to compile the rule %s: %v", r.Name, err)
+			continue
+		}
+
+		engine.rules = append(engine.rules, *compiled)
+	}
+
+	if len(engine.rules) == 0 {
+		return nil
+	}
+
+	logger.Debugf("Loaded %d cache rule(s).", len(engine.rules))
+
+	return engine
+}
+
+func headersToMap(headers http.Header) map[string]string {
+	values := make(map[string]string, len(headers))
+	for name := range headers {
+		values[strings.ToLower(name)] = strings.Join(headers.Values(name), ", ")
+	}
+
+	return values
+}
+
+func requestActivation(rq *http.Request) map[string]any {
+	query := map[string]string{}
+	for name, values := range rq.URL.Query() {
+		if len(values) > 0 {
+			query[name] = values[0]
+		}
+	}
+
+	cookies := map[string]string{}
+	for _, cookie := range rq.Cookies() {
+		cookies[cookie.Name] = cookie.Value
+	}
+
+	return map[string]any{
+		"method":  rq.Method,
+		"host":    rq.Host,
+		"path":    rq.URL.Path,
+		"query":   query,
+		"headers": headersToMap(rq.Header),
+		"cookies": cookies,
+	}
+}
+
+func responseActivation(status int, headers http.Header) map[string]any {
+	return map[string]any{
+		"status":       status,
+		"content_type": headers.Get("Content-Type"),
+		"headers":      headersToMap(headers),
+	}
+}
+
+func matches(program cel.Program, activation map[string]any) bool {
+	value, _, err := program.Eval(activation)
+	if err != nil {
+		return false
+	}
+
+	matched, ok := value.Value().(bool)
+
+	return ok && matched
+}
+
+func (r *Result) apply(current rule, keyComponents []string) {
+	r.Matched = append(r.Matched, current.Name)
+	if current.Bypass {
+		r.Bypass = true
+	}
+	if current.TTL.Duration != 0 {
+		r.TTL = current.TTL.Duration
+	}
+	if current.Stale.Duration != 0 {
+		r.Stale = current.Stale.Duration
+	}
+	if len(current.Storers) != 0 {
+		r.Storers = current.Storers
+	}
+	r.KeyComponents = append(r.KeyComponents, keyComponents...)
+	r.SurrogateKeys = append(r.SurrogateKeys, current.SurrogateKeys...)
+	r.StripHeaders = append(r.StripHeaders, current.StripHeaders...)
+}
+
+// EvaluateRequest evaluates the rules that only depend on the request.
+func (e *Engine) EvaluateRequest(rq *http.Request) *Result {
+	result := &Result{requestMatches: make([]bool, len(e.rules))}
+	activation := map[string]any{requestVariable: requestActivation(rq)}
+
+	for i, current := range e.rules {
+		if current.responsePhase || !matches(current.program, activation) {
+			continue
+		}
+
+		result.requestMatches[i] = true
+		keyComponents := make([]string, 0, len(current.keyComponents))
+		for _, component := range current.keyComponents {
+			if value, _, err := component.Eval(activation); err == nil {
+				keyComponents = append(keyComponents, fmt.Sprint(value.Value()))
+			}
+		}
+		result.apply(current, keyComponents)
+	}
+
+	return result
+}
+
+// EvaluateResponse returns the request rules result merged with the rules
+// depending on the response, still in the declaration order. The request
+// result stored in the context is left untouched.
+func (e *Engine) EvaluateResponse(rq *http.Request, status int, headers http.Header) *Result {
+	result := FromContext(rq.Context())
+	if result == nil {
+		result = e.EvaluateRequest(rq)
+	}
+
+	activation := map[string]any{
+		requestVariable:  requestActivation(rq),
+		responseVariable: responseActivation(status, headers),
+	}
+
+	merged := &Result{requestMatches: result.requestMatches, KeyComponents: result.KeyComponents}
+
+	for i, current := range e.rules {
+		if current.responsePhase {
+			if matches(current.program, activation) {
+				merged.apply(current, nil)
+			}
+		} else if i < len(result.requestMatches) && result.requestMatches[i] {
+			merged.apply(current, nil)
+		}
+	}
+
+	return merged
+}
+
+// MatchedNames returns the comma separated matching rules names.
+func (r *Result) MatchedNames() string {
+	if r == nil {
+		return ""
+	}
+
+	return strings.Join(r.Matched, ",")
+}
+
+// WithResult stores the rules result in the request context.
+func WithResult(rq *http.Request, result *Result) *http.Request {
+	return rq.WithContext(context.WithValue(rq.Context(), resultCtx, result))
+}
+
+// FromContext returns the rules result stored in the context if any.
+func FromContext(c context.Context) *Result {
+	if result, ok := c.Value(resultCtx).(*Result); ok {
+		return result
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package rules
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/darkweak/souin/configurationtypes"
+	"go.uber.org/zap"
+)
+
+func newTestEngine(t *testing.T, rules ...configurationtypes.Rule) *Engine {
+	t.Helper()
+
+	engine := NewEngine(rules, zap.NewNop().Sugar())
+	if engine == nil {
+		t.Fatal("The engine must not be nil.")
+	}
+
+	return engine
+}
+
+func TestNewEngine(t *testing.T) {
+	if NewEngine(nil, zap.NewNop().Sugar()) != nil {
+		t.Error("The engine must be nil without rules.")
+	}
+
+	if NewEngine([]configurationtypes.Rule{{Name: "invalid", Condition: "request.unknown("}}, zap.NewNop().Sugar()) != nil {
+		t.Error("The engine must be nil when every rule is invalid.")
+	}
+
+	if NewEngine([]configurationtypes.Rule{{Name: "not-bool", Condition: "1 + 1"}}, zap.NewNop().Sugar()) != nil {
+		t.Error("The engine must reject a non bool condition.")
+	}
+
+	if NewEngine([]configurationtypes.Rule{{Name: "response-key", Condition: "response.status == 200", KeyComponents: []string{"request.path"}}}, zap.NewNop().Sugar()) != nil {
+		t.Error("The engine must reject the key components on a response condition.")
+	}
+
+	engine := newTestEngine(
+		t,
+		configurationtypes.Rule{Condition: "request.method == 'GET'"},
+		configurationtypes.Rule{Name: "invalid", Condition: "request.method =="},
+		configurationtypes.Rule{Name: "response", Condition: "response.status == 200"},
+	)
+	if len(engine.rules) != 2 {
+		t.Fatalf("The engine must contain 2 rules, %d given.", len(engine.rules))
+	}
+	if engine.rules[0].Name != "rule-0" {
+		t.Errorf("The unnamed rule must be named from its index, %s given.", engine.rules[0].Name)
+	}
+	if engine.rules[0].responsePhase || !engine.rules[1].responsePhase {
+		t.Error("The rules phases must be detected from their condition.")
+	}
+
+	requestEnv, _ := newEnv(false)
+	responseEnv, _ := newEnv(true)
+	if _, err := newRule(requestEnv, responseEnv, configurationtypes.Rule{Condition: "request.path == '/' && unknown"}); err == nil || !strings.Contains(err.Error(), "undeclared reference to 'unknown'") {
+		t.Errorf("The compilation error of the condition must be returned, %v given.", err)
+	}
+	if compiled, err := newRule(requestEnv, responseEnv, configurationtypes.Rule{Condition: "request.path == '/' || response.status == 200"}); err != nil || !compiled.responsePhase {
+		t.Errorf("The condition referencing the response must be evaluated on the response, %v given.", err)
+	}
+}
+
+func TestEngine_EvaluateRequest(t *testing.T) {
+	engine := newTestEngine(
+		t,
+		configurationtypes.Rule{
+			Name:          "api",
+			Condition:     "request.path.startsWith('/api') && request.headers['x-tenant'] == 'acme'",
+			TTL:           configurationtypes.Duration{Duration: time.Minute},
+			KeyComponents: []string{"request.headers['x-tenant']", "request.query['page']"},
+			SurrogateKeys: []string{"api"},
+		},
+		configurationtypes.Rule{
+			Name:      "session",
+			Condition: "'session' in request.cookies",
+			Bypass:    true,
+		},
+		configurationtypes.Rule{
+			Name:      "override",
+			Condition: "request.host == 'example.com'",
+			TTL:       configurationtypes.Duration{Duration: time.Hour},
+			Storers:   []string{"REDIS"},
+		},
+	)
+
+	rq := httptest.NewRequest(http.MethodGet, "http://example.com/api/users?page=2", nil)
+	rq.Header.Set("X-Tenant", "acme")
+	result := engine.EvaluateRequest(rq)
+
+	if result.Bypass {
+		t.Error("The request must not bypass the cache.")
+	}
+	if result.TTL != time.Hour {
+		t.Errorf("The last matching rule TTL must win, %v given.", result.TTL)
+	}
+	if !reflect.DeepEqual(result.KeyComponents, []string{"acme", "2"}) {
+		t.Errorf("Unexpected key components %v.", result.KeyComponents)
+	}
+	if !reflect.DeepEqual(result.Storers, []string{"REDIS"}) {
+		t.Errorf("Unexpected storers %v.", result.Storers)
+	}
+	if result.MatchedNames() != "api,override" {
+		t.Errorf("Unexpected matched rules %s.", result.MatchedNames())
+	}
+
+	rq.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+	if !engine.EvaluateRequest(rq).Bypass {
+		t.Error("The session cookie must bypass the cache.")
+	}
+}
+
+func TestEngine_EvaluateResponse(t *testing.T) {
+	engine := newTestEngine(
+		t,
+		configurationtypes.Rule{
+			Name:          "api",
+			Condition:     "request.path.startsWith('/api')",
+			SurrogateKeys: []string{"api"},
+		},
+		configurationtypes.Rule{
+			Name:         "json",
+			Condition:    "response.status == 200 && response.content_type.startsWith('application/json')",
+			TTL:          configurationtypes.Duration{Duration: 5 * time.Minute},
+			StripHeaders: []string{"Set-Cookie"},
+		},
+		configurationtypes.Rule{
+			Name:      "errors",
+			Condition: "response.status >= 500",
+			Bypass:    true,
+		},
+	)
+
+	rq := httptest.NewRequest(http.MethodGet, "http://example.com/api/users", nil)
+	rq = WithResult(rq, engine.EvaluateRequest(rq))
+
+	headers := http.Header{}
+	headers.Set("Content-Type", "application/json; charset=utf-8")
+	result := engine.EvaluateResponse(rq, http.StatusOK, headers)
+
+	if requestResult := FromContext(rq.Context()); result == requestResult || requestResult.TTL != 0 || requestResult.MatchedNames() != "api" {
+		t.Errorf("The context result must be left untouched, %+v given.", requestResult)
+	}
+	if result.Bypass {
+		t.Error("The response must not bypass the cache.")
+	}
+	if result.TTL != 5*time.Minute {
+		t.Errorf("Unexpected TTL %v.", result.TTL)
+	}
+	if !reflect.DeepEqual(result.SurrogateKeys, []string{"api"}) || !reflect.DeepEqual(result.StripHeaders, []string{"Set-Cookie"}) {
+		t.Errorf("Unexpected surrogate keys %v or strip headers %v.", result.SurrogateKeys, result.StripHeaders)
+	}
+	if result.MatchedNames() != "api,json" {
+		t.Errorf("Unexpected matched rules %s.", result.MatchedNames())
+	}
+	if again := engine.EvaluateResponse(rq, http.StatusOK, headers); again.MatchedNames() != "api,json" || !reflect.DeepEqual(again.SurrogateKeys, []string{"api"}) {
+		t.Errorf("The response evaluation must not accumulate the results, %+v given.", again)
+	}
+
+	rq = httptest.NewRequest(http.MethodGet, "http://example.com/api/users", nil)
+	result = engine.EvaluateResponse(rq, http.StatusBadGateway, http.Header{})
+	if !result.Bypass || result.MatchedNames() != "api,errors" {
+		t.Errorf("The upstream errors must bypass the cache, %+v given.", result)
+	}
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"bufio"
 	"bytes"
 	"net/http"
 	"regexp"
@@ -111,6 +112,11 @@ func (provider *Default) Get(key string) []byte {
 
 	res, ok := result.(item)
 	if !ok {
+		// The mappings are stored as raw bytes without expiration.
+		if mapping, isMapping := result.([]byte); isMapping {
+			return mapping
+		}
+
 		return nil
 	}
 
@@ -130,11 +136,63 @@ func (provider *Default) GetMultiLevel(key string, req *http.Request, validator
 		return
 	}
 
-	fresh, stale, _ = core.MappingElection(provider, result.([]byte), req, validator, provider.logger)
+	mapping, err := core.DecodeMapping(result.([]byte))
+	if err != nil {
+		return
+	}
+
+	// The election follows core.MappingElection with readers owned by each
+	// response, the pooled ones being reused while the caller reads the body.
+	for keyName, keyItem := range mapping.GetMapping() {
+		if disableVary, _ := req.Context().Value(core.DISABLE_VARY_CTX).(bool); !disableVary && !variedHeadersMatch(keyItem, req) {
+			continue
+		}
+
+		core.ValidateETagFromHeader(keyItem.GetEtag(), validator)
+		if !validator.Matched {
+			provider.logger.Debugf("The stored key %s didn't match the current iteration key ETag %+v", keyName, validator)
+
+			continue
+		}
+
+		if time.Since(keyItem.GetFreshTime().AsTime()) < 0 {
+			if response := provider.Get(keyName); response != nil {
+				if fresh, err = readResponse(response, req); err != nil {
+					provider.logger.Errorf("An error occurred while reading response for the key %s: %v", keyName, err)
+				}
+
+				return
+			}
+		}
+
+		if time.Since(keyItem.GetStaleTime().AsTime()) < 0 {
+			if response := provider.Get(keyName); response != nil {
+				if stale, err = readResponse(response, req); err != nil {
+					provider.logger.Errorf("An error occurred while reading response for the key %s: %v", keyName, err)
+
+					return
+				}
+			}
+		}
+	}
 
 	return
 }
 
+func variedHeadersMatch(keyItem *core.KeyIndex, req *http.Request) bool {
+	for hname, hval := range keyItem.GetVariedHeaders() {
+		if req.Header.Get(hname) != strings.Join(hval.GetHeaderValue(), ", ") {
+			return false
+		}
+	}
+
+	return true
+}
+
+func readResponse(data []byte, req *http.Request) (*http.Response, error) {
+	return http.ReadResponse(bufio.NewReader(lz4.NewReader(bytes.NewReader(data))), req)
+}
+
 // SetMultiLevel tries to store the key with the given value and update the mapping key to store metadata.
 func (provider *Default) SetMultiLevel(baseKey, variedKey string, value []byte, variedHeaders http.Header, etag string, duration time.Duration, realKey string) error {
 	now := time.Now()
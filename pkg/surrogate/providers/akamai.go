@@ -1,7 +1,6 @@
 package providers
 
 import (
-	"bytes"
 	"encoding/json"
 	"net/http"
 
@@ -23,13 +22,22 @@ func generateAkamaiInstance(config configurationtypes.AbstractConfigurationInter
 		strategy = "invalidate"
 	}
 
-	a.url = "https://" + cdn.Hostname + "/ccu/v3/" + strategy + "/tag"
+	baseURL := cdn.BaseURL
+	if baseURL == "" {
+		baseURL = "https://" + cdn.Hostname
+	}
+	a.url = baseURL + "/ccu/v3/" + strategy + "/tag"
 	if cdn.Network != "" {
 		a.url += "/" + cdn.Network
 	}
 
 	a.init(config, defaultStorerName)
 	a.parent = a
+	var authorize func(*http.Request, []byte)
+	if cdn.ClientToken != "" {
+		authorize = newEdgeGridSigner(cdn.ClientToken, cdn.ClientSecret, cdn.AccessToken).Sign
+	}
+	a.dispatcher = newPurgeDispatcher("akamai", cdn.Purge, a.Storage, a.logger, authorize)
 
 	return a
 }
@@ -50,22 +58,20 @@ func (a *AkamaiSurrogateStorage) Store(response *http.Response, cacheKey, uri st
 	return e
 }
 
-// Purge purges the urls associated to the tags
-func (a *AkamaiSurrogateStorage) Purge(header http.Header) (cacheKeys []string, surrogateKeys []string) {
-	keys, headers := a.baseStorage.Purge(header)
-	m, b := map[string][]string{"objects": headers}, new(bytes.Buffer)
-	e := json.NewEncoder(b).Encode(m)
-	if e != nil {
+// PurgeMatched purges the urls associated to the matched tags
+func (a *AkamaiSurrogateStorage) PurgeMatched(matched []string) (cacheKeys []string, surrogateKeys []string) {
+	keys, headers := a.baseStorage.PurgeMatched(matched)
+	tags := NonEmpty(headers)
+	if len(tags) == 0 {
 		return keys, headers
 	}
-	req, err := http.NewRequest(http.MethodPost, a.url, b)
-	if err == nil {
-		req.Header.Set("Accept", "application/json")
-		req.Header.Set("Content-Type", "application/json")
 
-		go func() {
-			_, _ = new(http.Client).Do(req)
-		}()
+	body, err := json.Marshal(map[string][]string{"objects": tags})
+	if err == nil {
+		a.dispatcher.Enqueue(http.MethodPost, a.url, http.Header{
+			"Accept":       {"application/json"},
+			"Content-Type": {"application/json"},
+		}, body)
 	}
 
 	return keys, headers
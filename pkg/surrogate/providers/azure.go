@@ -0,0 +1,81 @@
+package providers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/darkweak/souin/configurationtypes"
+)
+
+const (
+	azureBaseURL          = "https://management.azure.com"
+	azureAPIVersion       = "2023-05-01"
+	azureMaxPathsPerPurge = 100
+)
+
+// AzureSurrogateStorage is the layer for Surrogate-key support storage.
+// Azure Front Door doesn't support the tags, so the request paths of the
+// tagged responses are indexed and purged instead.
+type AzureSurrogateStorage struct {
+	*baseStorage
+	providerAPIKey string
+	url            string
+}
+
+func generateAzureInstance(config configurationtypes.AbstractConfigurationInterface, defaultStorerName string) *AzureSurrogateStorage {
+	cdn := config.GetDefaultCache().GetCDN()
+	a := &AzureSurrogateStorage{
+		baseStorage:    &baseStorage{indexPaths: true},
+		providerAPIKey: cdn.APIKey,
+		url:            azureBaseURL,
+	}
+	if cdn.BaseURL != "" {
+		a.url = cdn.BaseURL
+	}
+	a.url += "/subscriptions/" + cdn.SubscriptionID +
+		"/resourceGroups/" + cdn.ResourceGroup +
+		"/providers/Microsoft.Cdn/profiles/" + cdn.Profile +
+		"/afdEndpoints/" + cdn.Endpoint +
+		"/purge?api-version=" + azureAPIVersion
+
+	a.init(config, defaultStorerName)
+	a.parent = a
+	a.dispatcher = newPurgeDispatcher("azure", cdn.Purge, a.Storage, a.logger, func(rq *http.Request, _ []byte) {
+		rq.Header.Set("Authorization", "Bearer "+a.providerAPIKey)
+	})
+
+	return a
+}
+
+func (*AzureSurrogateStorage) getHeaderSeparator() string {
+	return ","
+}
+
+// Store stores the response tags located in the first non empty supported header
+func (a *AzureSurrogateStorage) Store(response *http.Response, cacheKey, uri string) error {
+	defer func() {
+		response.Header.Del(surrogateKey)
+		response.Header.Del(surrogateControl)
+	}()
+
+	return a.baseStorage.Store(response, cacheKey, uri)
+}
+
+// PurgeMatched purges the urls associated to the matched tags
+func (a *AzureSurrogateStorage) PurgeMatched(matched []string) (cacheKeys []string, surrogateKeys []string) {
+	keys, headers := a.baseStorage.PurgeMatched(matched)
+	paths := a.purgePaths(NonEmpty(headers))
+	for i := 0; i < len(paths); i += azureMaxPathsPerPurge {
+		j := min(i+azureMaxPathsPerPurge, len(paths))
+		body, err := json.Marshal(map[string][]string{"contentPaths": paths[i:j]})
+		if err != nil {
+			continue
+		}
+
+		a.dispatcher.Enqueue(http.MethodPost, a.url, http.Header{
+			"Content-Type": {"application/json"},
+		}, body)
+	}
+
+	return keys, headers
+}
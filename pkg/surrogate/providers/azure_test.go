@@ -0,0 +1,63 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/darkweak/souin/pkg/storage"
+	"github.com/darkweak/souin/pkg/storage/types"
+	"github.com/darkweak/storages/core"
+)
+
+func TestAzureSurrogateStorage(t *testing.T) {
+	server, received := webhookStandIn(t)
+	memoryStorer, _ := storage.Factory(mockConfiguration(cdnConfigurationSouin))
+	core.RegisterStorage(memoryStorer)
+
+	p := SurrogateFactory(mockConfiguration(func() string {
+		return fmt.Sprintf(`
+default_cache:
+  cdn:
+    provider: azure
+    base_url: %s
+    subscription_id: subscription
+    resource_group: group
+    profile: profile
+    endpoint: endpoint
+    api_key: token
+`, server.URL)
+	}), types.DefaultStorageName)
+
+	for i := 0; i <= azureMaxPathsPerPurge; i++ {
+		response := &http.Response{Header: http.Header{surrogateKey: {"products"}}}
+		_ = p.Store(response, fmt.Sprintf("GET-http-example.com-/products/%d", i), fmt.Sprintf("/products/%d", i))
+		if response.Header.Get(surrogateKey) != "" {
+			t.Errorf("The surrogate keys must not be sent to Azure, %v given.", response.Header)
+		}
+	}
+	_, _ = p.Purge(http.Header{surrogateKey: {"products"}})
+	waitForResults(t, p, 2)
+
+	paths := map[string]bool{}
+	for _, rq := range received() {
+		if rq.method != http.MethodPost || rq.path != "/subscriptions/subscription/resourceGroups/group/providers/Microsoft.Cdn/profiles/profile/afdEndpoints/endpoint/purge?api-version="+azureAPIVersion {
+			t.Errorf("Unexpected Azure request %s %s.", rq.method, rq.path)
+		}
+		if rq.header.Get("Authorization") != "Bearer token" || rq.header.Get("Content-Type") != "application/json" {
+			t.Errorf("The request must be authorized with the token, %v given.", rq.header)
+		}
+
+		var body map[string][]string
+		if err := json.Unmarshal([]byte(rq.body), &body); err != nil || len(body["contentPaths"]) == 0 || len(body["contentPaths"]) > azureMaxPathsPerPurge {
+			t.Errorf("Each purge must have at most %d content paths, %s given.", azureMaxPathsPerPurge, rq.body)
+		}
+		for _, path := range body["contentPaths"] {
+			paths[path] = true
+		}
+	}
+	if len(paths) != azureMaxPathsPerPurge+1 {
+		t.Errorf("The paths of the tagged responses must be purged, %d given.", len(paths))
+	}
+}
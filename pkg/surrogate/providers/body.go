@@ -0,0 +1,335 @@
+package providers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/darkweak/souin/configurationtypes"
+)
+
+const (
+	defaultBodyKeysMaxBytes = 1 << 20
+	defaultBodyKeysMaxTags  = 100
+
+	graphqlTypename = "__typename"
+	graphqlID       = "id"
+)
+
+type jsonPathStepKind int
+
+const (
+	jsonPathChild jsonPathStepKind = iota
+	jsonPathIndex
+	jsonPathWildcard
+	jsonPathRecursive
+)
+
+type jsonPathStep struct {
+	kind  jsonPathStepKind
+	name  string
+	index int
+}
+
+type jsonPath struct {
+	prefix string
+	steps  []jsonPathStep
+}
+
+// bodyKeysRule holds the compiled body extraction rules of a surrogate_keys
+// entry, applied to the responses matching its url.
+type bodyKeysRule struct {
+	url       *regexp.Regexp
+	jsonPaths []jsonPath
+	graphql   bool
+}
+
+// compileBodyKeysRule compiles the JSONPath expressions of the entry. It
+// returns false when the entry doesn't extract anything from the body.
+func compileBodyKeysRule(keys configurationtypes.SurrogateKeys, url *regexp.Regexp) (bodyKeysRule, bool, error) {
+	rule := bodyKeysRule{url: url, graphql: keys.GraphQL}
+	for _, path := range keys.JSONPaths {
+		steps, err := parseJSONPath(path.Path)
+		if err != nil {
+			return rule, false, err
+		}
+		rule.jsonPaths = append(rule.jsonPaths, jsonPath{prefix: path.Prefix, steps: steps})
+	}
+
+	return rule, rule.graphql || len(rule.jsonPaths) > 0, nil
+}
+
+// parseJSONPath supports the $ root, .name, ['name'], [n], [*], .* and the
+// ..name recursive descent.
+func parseJSONPath(expression string) ([]jsonPathStep, error) {
+	if !strings.HasPrefix(expression, "$") {
+		return nil, fmt.Errorf("the JSONPath %q must start with $", expression)
+	}
+
+	steps := []jsonPathStep{}
+	rest := expression[1:]
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			name, remaining := readJSONPathName(rest[2:])
+			if name == "" {
+				return nil, fmt.Errorf("the JSONPath %q has an empty recursive descent", expression)
+			}
+			steps = append(steps, jsonPathStep{kind: jsonPathRecursive, name: name})
+			rest = remaining
+		case rest[0] == '.':
+			name, remaining := readJSONPathName(rest[1:])
+			switch name {
+			case "":
+				return nil, fmt.Errorf("the JSONPath %q has an empty member", expression)
+			case "*":
+				steps = append(steps, jsonPathStep{kind: jsonPathWildcard})
+			default:
+				steps = append(steps, jsonPathStep{kind: jsonPathChild, name: name})
+			}
+			rest = remaining
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("the JSONPath %q has an unclosed bracket", expression)
+			}
+			selector := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+
+			if selector == "*" {
+				steps = append(steps, jsonPathStep{kind: jsonPathWildcard})
+				continue
+			}
+			if len(selector) >= 2 && (selector[0] == '\'' || selector[0] == '"') && selector[len(selector)-1] == selector[0] {
+				steps = append(steps, jsonPathStep{kind: jsonPathChild, name: selector[1 : len(selector)-1]})
+				continue
+			}
+			index, err := strconv.Atoi(selector)
+			if err != nil {
+				return nil, fmt.Errorf("the JSONPath %q has an invalid selector %q", expression, selector)
+			}
+			steps = append(steps, jsonPathStep{kind: jsonPathIndex, index: index})
+		default:
+			return nil, fmt.Errorf("the JSONPath %q is invalid near %q", expression, rest)
+		}
+	}
+
+	return steps, nil
+}
+
+func readJSONPathName(value string) (string, string) {
+	end := strings.IndexAny(value, ".[")
+	if end < 0 {
+		return value, ""
+	}
+
+	return value[:end], value[end:]
+}
+
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func children(node interface{}) []interface{} {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		list := make([]interface{}, 0, len(value))
+		for _, key := range sortedKeys(value) {
+			list = append(list, value[key])
+		}
+
+		return list
+	case []interface{}:
+		return value
+	}
+
+	return nil
+}
+
+func descendants(node interface{}, list []interface{}) []interface{} {
+	list = append(list, node)
+	for _, child := range children(node) {
+		list = descendants(child, list)
+	}
+
+	return list
+}
+
+func (step jsonPathStep) apply(nodes []interface{}) []interface{} {
+	next := []interface{}{}
+	for _, node := range nodes {
+		switch step.kind {
+		case jsonPathChild:
+			if object, ok := node.(map[string]interface{}); ok {
+				if value, ok := object[step.name]; ok {
+					next = append(next, value)
+				}
+			}
+		case jsonPathIndex:
+			if array, ok := node.([]interface{}); ok {
+				index := step.index
+				if index < 0 {
+					index += len(array)
+				}
+				if index >= 0 && index < len(array) {
+					next = append(next, array[index])
+				}
+			}
+		case jsonPathWildcard:
+			next = append(next, children(node)...)
+		case jsonPathRecursive:
+			all := descendants(node, nil)
+			if step.name == "*" {
+				next = append(next, all[1:]...)
+				continue
+			}
+			next = append(next, jsonPathStep{kind: jsonPathChild, name: step.name}.apply(all)...)
+		}
+	}
+
+	return next
+}
+
+// scalarValue returns the string form of the JSON strings, numbers and
+// booleans.
+func scalarValue(node interface{}) (string, bool) {
+	switch value := node.(type) {
+	case string:
+		return value, value != ""
+	case json.Number:
+		return value.String(), true
+	case bool:
+		return strconv.FormatBool(value), true
+	}
+
+	return "", false
+}
+
+func (path jsonPath) extract(document interface{}) []string {
+	nodes := []interface{}{document}
+	for _, step := range path.steps {
+		nodes = step.apply(nodes)
+	}
+
+	tags := []string{}
+	for _, node := range nodes {
+		if value, ok := scalarValue(node); ok {
+			tags = append(tags, path.prefix+value)
+		}
+	}
+
+	return tags
+}
+
+// graphqlKeys returns a Typename:id tag for each object carrying both the
+// __typename and the id fields.
+func graphqlKeys(node interface{}, tags []string) []string {
+	if object, ok := node.(map[string]interface{}); ok {
+		if typename, ok := object[graphqlTypename].(string); ok && typename != "" {
+			if id, ok := scalarValue(object[graphqlID]); ok {
+				tags = append(tags, typename+":"+id)
+			}
+		}
+	}
+	for _, child := range children(node) {
+		tags = graphqlKeys(child, tags)
+	}
+
+	return tags
+}
+
+func isJSONResponse(header http.Header) bool {
+	if encoding := header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// BodyKeysLimit returns the maximum body size read to extract the surrogate
+// keys, 0 when no surrogate_keys entry extracts them from the body.
+func (s *baseStorage) BodyKeysLimit() int {
+	if len(s.bodyRules) == 0 {
+		return 0
+	}
+
+	return s.bodyMaxBytes
+}
+
+// bodyKeys extracts the surrogate keys from the JSON response body using the
+// rules matching the uri, up to the configured tags count. The response is
+// already sent when they are extracted, so they are indexed locally only and
+// never reach the tags header of the CDN.
+func (s *baseStorage) bodyKeys(response *http.Response, uri string) []string {
+	if len(s.bodyRules) == 0 || response.Body == nil || !isJSONResponse(response.Header) {
+		return nil
+	}
+
+	rules := make([]bodyKeysRule, 0, len(s.bodyRules))
+	for _, rule := range s.bodyRules {
+		if rule.url.MatchString(uri) {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	b, err := io.ReadAll(io.LimitReader(response.Body, int64(s.bodyMaxBytes)+1))
+	if err != nil || len(b) > s.bodyMaxBytes {
+		s.logger.Debugf("Skip the body surrogate keys extraction for %s, the body is unreadable or larger than %d bytes", uri, s.bodyMaxBytes)
+
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	var document interface{}
+	if err = decoder.Decode(&document); err != nil {
+		s.logger.Debugf("Skip the body surrogate keys extraction for %s: %v", uri, err)
+
+		return nil
+	}
+
+	extracted := []string{}
+	for _, rule := range rules {
+		for _, path := range rule.jsonPaths {
+			extracted = append(extracted, path.extract(document)...)
+		}
+		if rule.graphql {
+			extracted = graphqlKeys(document, extracted)
+		}
+	}
+
+	seen := make(map[string]bool, len(extracted))
+	tags := []string{}
+	for _, tag := range extracted {
+		if seen[tag] {
+			continue
+		}
+		if len(tags) == s.bodyMaxTags {
+			s.logger.Debugf("Keep the %d first body surrogate keys of %s", s.bodyMaxTags, uri)
+
+			break
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+
+	return tags
+}
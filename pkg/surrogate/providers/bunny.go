@@ -0,0 +1,83 @@
+package providers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/darkweak/souin/configurationtypes"
+)
+
+const (
+	bunnyBaseURL = "https://api.bunny.net"
+	bunnyCDNTag  = "CDN-Tag"
+)
+
+// BunnySurrogateStorage is the layer for Surrogate-key support storage
+type BunnySurrogateStorage struct {
+	*baseStorage
+	providerAPIKey string
+	url            string
+}
+
+func generateBunnyInstance(config configurationtypes.AbstractConfigurationInterface, defaultStorerName string) *BunnySurrogateStorage {
+	cdn := config.GetDefaultCache().GetCDN()
+	b := &BunnySurrogateStorage{
+		baseStorage:    &baseStorage{},
+		providerAPIKey: cdn.APIKey,
+		url:            bunnyBaseURL,
+	}
+	if cdn.BaseURL != "" {
+		b.url = cdn.BaseURL
+	}
+	b.url += "/pullzone/" + cdn.PullZoneID + "/purgeCache"
+
+	b.init(config, defaultStorerName)
+	b.parent = b
+	b.dispatcher = newPurgeDispatcher("bunny", cdn.Purge, b.Storage, b.logger, func(rq *http.Request, _ []byte) {
+		rq.Header.Set("AccessKey", b.providerAPIKey)
+	})
+
+	return b
+}
+
+func (*BunnySurrogateStorage) getHeaderSeparator() string {
+	return ","
+}
+
+func (*BunnySurrogateStorage) getOrderedSurrogateKeyHeadersCandidate() []string {
+	return []string{
+		bunnyCDNTag,
+		surrogateKey,
+	}
+}
+
+// Store stores the response tags located in the first non empty supported header
+func (b *BunnySurrogateStorage) Store(response *http.Response, cacheKey, uri string) error {
+	defer func() {
+		response.Header.Del(surrogateKey)
+		response.Header.Del(surrogateControl)
+	}()
+	e := b.baseStorage.Store(response, cacheKey, uri)
+	response.Header.Set(bunnyCDNTag, strings.Join(b.ParseHeaders(b.getSurrogateKey(response.Header)), b.getHeaderSeparator()))
+
+	return e
+}
+
+// PurgeMatched purges the urls associated to the matched tags. The Bunny API purges a
+// single tag per request.
+func (b *BunnySurrogateStorage) PurgeMatched(matched []string) (cacheKeys []string, surrogateKeys []string) {
+	keys, headers := b.baseStorage.PurgeMatched(matched)
+	for _, tag := range NonEmpty(headers) {
+		body, err := json.Marshal(map[string]string{"CacheTag": tag})
+		if err != nil {
+			continue
+		}
+
+		b.dispatcher.Enqueue(http.MethodPost, b.url, http.Header{
+			"Content-Type": {"application/json"},
+		}, body)
+	}
+
+	return keys, headers
+}
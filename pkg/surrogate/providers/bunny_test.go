@@ -0,0 +1,57 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/darkweak/souin/pkg/storage"
+	"github.com/darkweak/souin/pkg/storage/types"
+	"github.com/darkweak/storages/core"
+)
+
+func TestBunnySurrogateStorage(t *testing.T) {
+	server, received := webhookStandIn(t)
+	memoryStorer, _ := storage.Factory(mockConfiguration(cdnConfigurationSouin))
+	core.RegisterStorage(memoryStorer)
+
+	p := SurrogateFactory(mockConfiguration(func() string {
+		return fmt.Sprintf(`
+default_cache:
+  cdn:
+    provider: bunny
+    base_url: %s
+    pull_zone_id: "42"
+    api_key: secret
+`, server.URL)
+	}), types.DefaultStorageName)
+
+	response := &http.Response{Header: http.Header{surrogateKey: {"first, second"}}}
+	_ = p.Store(response, "GET-http-example.com-/path", "/path")
+	if response.Header.Get(bunnyCDNTag) != "first,second" || response.Header.Get(surrogateKey) != "" {
+		t.Errorf("The tags must be sent in the CDN-Tag header, %v given.", response.Header)
+	}
+
+	_, _ = p.Purge(http.Header{surrogateKey: {"first, second"}})
+	waitForResults(t, p, 2)
+
+	tags := []string{}
+	for _, rq := range received() {
+		if rq.method != http.MethodPost || rq.path != "/pullzone/42/purgeCache" || rq.header.Get("AccessKey") != "secret" || rq.header.Get("Content-Type") != "application/json" {
+			t.Errorf("Unexpected Bunny request %s %s %v.", rq.method, rq.path, rq.header)
+		}
+
+		var body map[string]string
+		if err := json.Unmarshal([]byte(rq.body), &body); err != nil || len(body) != 1 {
+			t.Errorf("Unexpected Bunny body %s.", rq.body)
+		}
+		tags = append(tags, body["CacheTag"])
+	}
+	sort.Strings(tags)
+	if strings.Join(tags, ",") != "first,second" {
+		t.Errorf("Each tag must be purged in its own request, %v given.", tags)
+	}
+}
@@ -1,21 +1,25 @@
 package providers
 
 import (
-	"bytes"
 	"encoding/json"
-	"io"
 	"net/http"
 	"strings"
 
 	"github.com/darkweak/souin/configurationtypes"
 )
 
+const (
+	cloudflareBaseURL         = "https://api.cloudflare.com/client/v4"
+	cloudflareMaxTagsPerPurge = 30
+)
+
 // CloudflareSurrogateStorage is the layer for Surrogate-key support storage
 type CloudflareSurrogateStorage struct {
 	*baseStorage
 	providerAPIKey string
 	email          string
 	zoneID         string
+	url            string
 }
 
 func generateCloudflareInstance(config configurationtypes.AbstractConfigurationInterface, defaultStorerName string) *CloudflareSurrogateStorage {
@@ -25,10 +29,25 @@ func generateCloudflareInstance(config configurationtypes.AbstractConfigurationI
 		providerAPIKey: cdn.APIKey,
 		zoneID:         cdn.ZoneID,
 		email:          cdn.Email,
+		url:            cloudflareBaseURL,
+	}
+	if cdn.BaseURL != "" {
+		f.url = cdn.BaseURL
 	}
+	f.url += "/zones/" + f.zoneID + "/purge_cache"
 
 	f.init(config, defaultStorerName)
 	f.parent = f
+	f.dispatcher = newPurgeDispatcher("cloudflare", cdn.Purge, f.Storage, f.logger, func(rq *http.Request, _ []byte) {
+		if f.email == "" {
+			rq.Header.Set("Authorization", "Bearer "+f.providerAPIKey)
+
+			return
+		}
+
+		rq.Header.Set("X-Auth-Email", f.email)
+		rq.Header.Set("X-Auth-Key", f.providerAPIKey)
+	})
 
 	return f
 }
@@ -56,36 +75,20 @@ func (*CloudflareSurrogateStorage) getOrderedSurrogateKeyHeadersCandidate() []st
 	}
 }
 
-func processBatches(arr []string, req *http.Request) {
-	const maxPerBatch = 30
-	for i := 0; i < len(arr); i += maxPerBatch {
-		j := i + maxPerBatch
-		if j > len(arr) {
-			j = len(arr)
+// PurgeMatched purges the urls associated to the matched tags
+func (c *CloudflareSurrogateStorage) PurgeMatched(matched []string) (cacheKeys []string, surrogateKeys []string) {
+	keys, headers := c.baseStorage.PurgeMatched(matched)
+	tags := NonEmpty(headers)
+	for i := 0; i < len(tags); i += cloudflareMaxTagsPerPurge {
+		j := min(i+cloudflareMaxTagsPerPurge, len(tags))
+		body, err := json.Marshal(map[string][]string{"tags": tags[i:j]})
+		if err != nil {
+			continue
 		}
 
-		body, _ := json.Marshal(map[string]interface{}{"tags": arr[i:j]})
-		req.Body = io.NopCloser(bytes.NewBuffer(body))
-		_, _ = new(http.Client).Do(req)
-	}
-}
-
-// Purge purges the urls associated to the tags
-func (c *CloudflareSurrogateStorage) Purge(header http.Header) (cacheKeys []string, surrogateKeys []string) {
-	keys, headers := c.baseStorage.Purge(header)
-	req, err := http.NewRequest(
-		http.MethodPost,
-		"https://api.cloudflare.com/client/v4/zones/"+c.zoneID+"/purge",
-		nil,
-	)
-	if err == nil {
-		req.Header.Set("X-Auth-Email", c.email)
-		req.Header.Set("X-Auth-Key", c.providerAPIKey)
-		req.Header.Set("Content-Type", "application/json")
-
-		go func() {
-			processBatches(headers, req)
-		}()
+		c.dispatcher.Enqueue(http.MethodPost, c.url, http.Header{
+			"Content-Type": {"application/json"},
+		}, body)
 	}
 
 	return keys, headers
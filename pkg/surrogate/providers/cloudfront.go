@@ -0,0 +1,91 @@
+package providers
+
+import (
+	"encoding/xml"
+	"net/http"
+
+	"github.com/darkweak/souin/configurationtypes"
+	"github.com/google/uuid"
+)
+
+const (
+	cloudfrontBaseURL          = "https://cloudfront.amazonaws.com"
+	cloudfrontRegion           = "us-east-1"
+	cloudfrontService          = "cloudfront"
+	cloudfrontMaxPathsPerPurge = 3000
+)
+
+type cloudfrontInvalidationBatch struct {
+	XMLName         xml.Name `xml:"http://cloudfront.amazonaws.com/doc/2020-05-31/ InvalidationBatch"`
+	Paths           cloudfrontPaths
+	CallerReference string
+}
+
+type cloudfrontPaths struct {
+	Quantity int
+	Items    []string `xml:"Items>Path"`
+}
+
+// CloudfrontSurrogateStorage is the layer for Surrogate-key support storage.
+// CloudFront doesn't support the tags, so the request paths of the tagged
+// responses are indexed and invalidated instead.
+type CloudfrontSurrogateStorage struct {
+	*baseStorage
+	signer *sigV4Signer
+	url    string
+}
+
+func generateCloudfrontInstance(config configurationtypes.AbstractConfigurationInterface, defaultStorerName string) *CloudfrontSurrogateStorage {
+	cdn := config.GetDefaultCache().GetCDN()
+	c := &CloudfrontSurrogateStorage{
+		baseStorage: &baseStorage{indexPaths: true},
+		signer:      newSigV4Signer(cdn.AccessKeyID, cdn.SecretAccessKey, cdn.SessionToken, cloudfrontRegion, cloudfrontService),
+		url:         cloudfrontBaseURL,
+	}
+	if cdn.BaseURL != "" {
+		c.url = cdn.BaseURL
+	}
+	c.url += "/2020-05-31/distribution/" + cdn.DistributionID + "/invalidation"
+
+	c.init(config, defaultStorerName)
+	c.parent = c
+	c.dispatcher = newPurgeDispatcher("cloudfront", cdn.Purge, c.Storage, c.logger, c.signer.Sign)
+
+	return c
+}
+
+func (*CloudfrontSurrogateStorage) getHeaderSeparator() string {
+	return ","
+}
+
+// Store stores the response tags located in the first non empty supported header
+func (c *CloudfrontSurrogateStorage) Store(response *http.Response, cacheKey, uri string) error {
+	defer func() {
+		response.Header.Del(surrogateKey)
+		response.Header.Del(surrogateControl)
+	}()
+
+	return c.baseStorage.Store(response, cacheKey, uri)
+}
+
+// PurgeMatched purges the urls associated to the matched tags
+func (c *CloudfrontSurrogateStorage) PurgeMatched(matched []string) (cacheKeys []string, surrogateKeys []string) {
+	keys, headers := c.baseStorage.PurgeMatched(matched)
+	paths := c.purgePaths(NonEmpty(headers))
+	for i := 0; i < len(paths); i += cloudfrontMaxPathsPerPurge {
+		j := min(i+cloudfrontMaxPathsPerPurge, len(paths))
+		body, err := xml.Marshal(cloudfrontInvalidationBatch{
+			Paths:           cloudfrontPaths{Quantity: j - i, Items: paths[i:j]},
+			CallerReference: uuid.NewString(),
+		})
+		if err != nil {
+			continue
+		}
+
+		c.dispatcher.Enqueue(http.MethodPost, c.url, http.Header{
+			"Content-Type": {"application/xml"},
+		}, append([]byte(xml.Header), body...))
+	}
+
+	return keys, headers
+}
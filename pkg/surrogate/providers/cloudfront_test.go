@@ -0,0 +1,82 @@
+package providers
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/darkweak/souin/pkg/storage"
+	"github.com/darkweak/souin/pkg/storage/types"
+	"github.com/darkweak/storages/core"
+)
+
+func TestCloudfrontSurrogateStorage(t *testing.T) {
+	server, received := webhookStandIn(t)
+	memoryStorer, _ := storage.Factory(mockConfiguration(cdnConfigurationSouin))
+	core.RegisterStorage(memoryStorer)
+
+	p := SurrogateFactory(mockConfiguration(func() string {
+		return fmt.Sprintf(`
+default_cache:
+  cdn:
+    provider: cloudfront
+    base_url: %s
+    distribution_id: EDFDVBD6EXAMPLE
+    access_key_id: AKIDEXAMPLE
+    secret_access_key: secret
+`, server.URL)
+	}), types.DefaultStorageName)
+
+	response := &http.Response{Header: http.Header{surrogateKey: {"products"}}}
+	_ = p.Store(response, "GET-http-example.com-/products", "/products")
+	if response.Header.Get(surrogateKey) != "" {
+		t.Errorf("The surrogate keys must not be sent to CloudFront, %v given.", response.Header)
+	}
+	for i := 0; i < cloudfrontMaxPathsPerPurge; i++ {
+		_ = p.Store(&http.Response{Header: http.Header{surrogateKey: {"products"}}}, fmt.Sprintf("GET-http-example.com-/products/%d", i), fmt.Sprintf("/products/%d", i))
+	}
+	_, _ = p.Purge(http.Header{surrogateKey: {"products"}})
+	waitForResults(t, p, 2)
+
+	paths := map[string]bool{}
+	references := map[string]bool{}
+	for _, rq := range received() {
+		if rq.method != http.MethodPost || rq.path != "/2020-05-31/distribution/EDFDVBD6EXAMPLE/invalidation" || rq.header.Get("Content-Type") != "application/xml" {
+			t.Errorf("Unexpected CloudFront request %s %s %v.", rq.method, rq.path, rq.header)
+		}
+
+		signed, _ := time.Parse(sigV4TimeFormat, rq.header.Get("X-Amz-Date"))
+		signer := newSigV4Signer("AKIDEXAMPLE", "secret", "", cloudfrontRegion, cloudfrontService)
+		signer.now = func() time.Time {
+			return signed
+		}
+		expected, _ := http.NewRequest(rq.method, server.URL+rq.path, bytes.NewReader([]byte(rq.body)))
+		expected.Header.Set("Content-Type", rq.header.Get("Content-Type"))
+		signer.Sign(expected, []byte(rq.body))
+		if authorization := rq.header.Get("Authorization"); authorization == "" || authorization != expected.Header.Get("Authorization") {
+			t.Errorf("The request must be signed with the secret access key, %s given.", authorization)
+		}
+
+		if !strings.HasPrefix(rq.body, xml.Header) {
+			t.Errorf("The invalidation batch must be an XML document, %s given.", rq.body)
+		}
+		var batch cloudfrontInvalidationBatch
+		if err := xml.Unmarshal([]byte(rq.body), &batch); err != nil || batch.Paths.Quantity != len(batch.Paths.Items) || batch.CallerReference == "" {
+			t.Errorf("Unexpected invalidation batch %+v with %v.", batch, err)
+		}
+		if len(batch.Paths.Items) > cloudfrontMaxPathsPerPurge || references[batch.CallerReference] {
+			t.Errorf("Each invalidation batch must have at most %d paths and its own caller reference, %+v given.", cloudfrontMaxPathsPerPurge, batch.Paths.Quantity)
+		}
+		references[batch.CallerReference] = true
+		for _, path := range batch.Paths.Items {
+			paths[path] = true
+		}
+	}
+	if len(paths) != cloudfrontMaxPathsPerPurge+1 || !paths["/products"] || !paths[fmt.Sprintf("/products/%d", cloudfrontMaxPathsPerPurge-1)] {
+		t.Errorf("The paths of the tagged responses must be invalidated, %d given.", len(paths))
+	}
+}
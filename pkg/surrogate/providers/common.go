@@ -1,6 +1,7 @@
 package providers
 
 import (
+	"fmt"
 	"net/http"
 	"net/url"
 	"regexp"
@@ -9,6 +10,7 @@ import (
 	"time"
 
 	"github.com/darkweak/souin/configurationtypes"
+	"github.com/darkweak/souin/pkg/rfc"
 	"github.com/darkweak/souin/pkg/storage/types"
 	"github.com/darkweak/storages/core"
 )
@@ -28,6 +30,8 @@ const (
 	cacheTag              = "Cache-Tag"
 
 	surrogatePrefix = "SURROGATE_"
+	hostKeyPrefix   = "host:"
+	pathKeyPrefix   = "path:"
 )
 
 var storageToInfiniteTTLMap = map[string]time.Duration{
@@ -70,6 +74,18 @@ func isSafeHTTPMethod(method string) bool {
 	return false
 }
 
+// NonEmpty returns the values without the empty ones.
+func NonEmpty(values []string) []string {
+	list := make([]string, 0, len(values))
+	for _, value := range values {
+		if value != "" {
+			list = append(list, value)
+		}
+	}
+
+	return list
+}
+
 func uniqueTag(values []string) []string {
 	tmp := make(map[string]bool)
 	list := []string{}
@@ -97,19 +113,38 @@ type keysRegexpInner struct {
 }
 
 type baseStorage struct {
-	parent     SurrogateInterface
-	Storage    types.Storer
-	Keys       map[string]configurationtypes.SurrogateKeys
-	keysRegexp map[string]keysRegexpInner
-	dynamic    bool
-	keepStale  bool
-	logger     core.Logger
-	mu         sync.Mutex
-	duration   time.Duration
+	parent             SurrogateInterface
+	Storage            types.Storer
+	Keys               map[string]configurationtypes.SurrogateKeys
+	keysRegexp         map[string]keysRegexpInner
+	dynamic            bool
+	keepStale          bool
+	checkExistence     bool
+	indexPaths         bool
+	hierarchical       bool
+	bodyRules          []bodyKeysRule
+	bodyMaxBytes       int
+	bodyMaxTags        int
+	dependencies       []dependencyRule
+	maxDependencyDepth int
+	maxWildcardTags    int
+	logger             core.Logger
+	mu                 sync.Mutex
+	duration           time.Duration
+	stale              time.Duration
+	targets            []string
+	dispatcher         *purgeDispatcher
+	stopCompaction     chan struct{}
+	stopOnce           sync.Once
+	indexLocks         [indexLockStripes]sync.Mutex
+	writerMu           sync.Mutex
+	writer             string
+	heartbeat          time.Time
 }
 
 func (s *baseStorage) init(config configurationtypes.AbstractConfigurationInterface, defaultStorerName string) {
-	if configuration, ok := config.GetSurrogateKeys()["_configuration"]; ok {
+	configuration, ok := config.GetSurrogateKeys()["_configuration"]
+	if ok {
 		storer := core.GetRegisteredStorer(configuration.Storer)
 		if storer == nil {
 			storer = core.GetRegisteredStorer(types.DefaultStorageName + "-")
@@ -154,54 +189,55 @@ func (s *baseStorage) init(config configurationtypes.AbstractConfigurationInterf
 		}
 
 		keysRegexp[key] = innerKey
+
+		rule, extracts, err := compileBodyKeysRule(regexps, innerKey.Url)
+		if err != nil {
+			config.GetLogger().Errorf("Impossible to compile the body surrogate keys of %s: %v", key, err)
+		} else if extracts {
+			s.bodyRules = append(s.bodyRules, rule)
+		}
 	}
 
+	s.targets = make([]string, 0, len(config.GetDefaultCache().GetTargetedCacheControl()))
+	for _, target := range config.GetDefaultCache().GetTargetedCacheControl() {
+		s.targets = append(s.targets, http.CanonicalHeaderKey(target))
+	}
 	s.dynamic = config.GetDefaultCache().GetCDN().Dynamic
 	s.logger = config.GetLogger()
 	s.keysRegexp = keysRegexp
 	s.duration = storageToInfiniteTTLMap[s.Storage.Name()]
-}
-
-// containsCacheKey checks if the cacheKey already exists in the comma-separated currentValue.
-// This is much faster than regex matching, especially for long strings.
-func containsCacheKey(currentValue, cacheKey string) bool {
-	if currentValue == "" {
-		return false
+	s.stale = config.GetDefaultCache().GetStale()
+	s.checkExistence = fmt.Sprintf("%s-%s", s.Storage.Name(), s.Storage.Uuid()) == defaultStorerName
+	s.hierarchical = configuration.HierarchicalKeys
+	s.bodyMaxBytes = configuration.MaxBodyBytes
+	if s.bodyMaxBytes <= 0 {
+		s.bodyMaxBytes = defaultBodyKeysMaxBytes
 	}
-	// Check for exact match at various positions:
-	// 1. Exact match of entire string
-	if currentValue == cacheKey {
-		return true
+	s.bodyMaxTags = configuration.MaxBodyTags
+	if s.bodyMaxTags <= 0 {
+		s.bodyMaxTags = defaultBodyKeysMaxTags
 	}
-	// 2. At the beginning: "cacheKey,"
-	if strings.HasPrefix(currentValue, cacheKey+souinStorageSeparator) {
-		return true
+	dependencies, err := compileDependencies(configuration.Dependencies)
+	if err != nil {
+		config.GetLogger().Errorf("Impossible to compile the surrogate keys dependencies: %v", err)
 	}
-	// 3. At the end: ",cacheKey"
-	if strings.HasSuffix(currentValue, souinStorageSeparator+cacheKey) {
-		return true
+	s.dependencies = dependencies
+	s.maxDependencyDepth = configuration.MaxDependencyDepth
+	if s.maxDependencyDepth <= 0 {
+		s.maxDependencyDepth = defaultMaxDependencyDepth
 	}
-	// 4. In the middle: ",cacheKey,"
-	if strings.Contains(currentValue, souinStorageSeparator+cacheKey+souinStorageSeparator) {
-		return true
+	s.maxWildcardTags = configuration.MaxWildcardTags
+	if s.maxWildcardTags <= 0 {
+		s.maxWildcardTags = defaultMaxWildcardTags
 	}
-	return false
-}
-
-func (s *baseStorage) storeTag(tag string, cacheKey string) {
-	defer s.mu.Unlock()
-	s.mu.Lock()
-	currentValue := string(s.Storage.Get(surrogatePrefix + tag))
-	if !containsCacheKey(currentValue, cacheKey) {
-		s.logger.Debugf("Store the tag %s", tag)
 
-		if currentValue == "" {
-			currentValue = cacheKey
-		} else {
-			currentValue += souinStorageSeparator + cacheKey
-		}
-
-		_ = s.Storage.Set(surrogatePrefix+tag, []byte(currentValue), s.duration)
+	interval := configuration.CompactionInterval.Duration
+	if interval == 0 {
+		interval = defaultCompactionInterval
+	}
+	if interval > 0 {
+		s.stopCompaction = make(chan struct{})
+		go s.compactEvery(interval, s.stopCompaction)
 	}
 }
 
@@ -227,7 +263,24 @@ func (*baseStorage) getOrderedSurrogateControlHeadersCandidate() []string {
 	}
 }
 
+// GetSurrogateControl returns the first valid targeted cache control field
+// (RFC 9213) with its directives converted to the Cache-Control syntax, or
+// the first provider candidate header otherwise.
 func (s *baseStorage) GetSurrogateControl(header http.Header) (string, string) {
+	for _, target := range s.targets {
+		values := header.Values(target)
+		if len(values) == 0 {
+			continue
+		}
+
+		directives, err := rfc.ParseTargetedCacheControl(strings.Join(values, ", "))
+		if err == nil {
+			return target, directives
+		}
+
+		s.logger.Debugf("Ignore the invalid targeted field %s: %v", target, err)
+	}
+
 	return getCandidateHeader(header, s.parent.getOrderedSurrogateControlHeadersCandidate)
 }
 
@@ -240,13 +293,21 @@ func (s *baseStorage) getSurrogateKey(header http.Header) string {
 	return v
 }
 
-func (s *baseStorage) purgeTag(tag string) []string {
-	toInvalidate := string(s.Storage.Get(surrogatePrefix + tag))
-	s.logger.Debugf("Purge the tag %s", tag)
-	if !s.keepStale {
-		s.Storage.Delete(surrogatePrefix + tag)
+// AppendSurrogateKeys adds the given keys to the surrogate keys header of the provider.
+func (s *baseStorage) AppendSurrogateKeys(header http.Header, keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	name, value := getCandidateHeader(header, s.parent.getOrderedSurrogateKeyHeadersCandidate)
+	if value == "" {
+		name = s.parent.getOrderedSurrogateKeyHeadersCandidate()[0]
+		header.Set(name, strings.Join(keys, s.parent.getHeaderSeparator()))
+
+		return
 	}
-	return strings.Split(toInvalidate, souinStorageSeparator)
+
+	header.Set(name, value+s.parent.getHeaderSeparator()+strings.Join(keys, s.parent.getHeaderSeparator()))
 }
 
 // Store will take the lead to store the cache key for each provided Surrogate-key
@@ -254,64 +315,161 @@ func (s *baseStorage) Store(response *http.Response, cacheKey, uri string) error
 	h := response.Header
 
 	cacheKey = url.QueryEscape(cacheKey)
+	expiry := s.entryExpiry(response)
 
 	keys := s.ParseHeaders(s.parent.getSurrogateKey(h))
+	if s.hierarchical {
+		keys = append(keys, hierarchicalKeys(response.Request, uri)...)
+	}
+	keys = append(keys, s.bodyKeys(response, uri)...)
+	s.storeDependencies(h.Get(surrogateDepends), keys, uri, expiry)
+	s.storeSize(response, cacheKey, expiry)
 
 	for _, key := range keys {
 		_, v := s.parent.GetSurrogateControl(h)
 		if controls := s.ParseHeaders(v); len(controls) != 0 {
 			if len(controls) == 1 && controls[0] == "" {
-				s.storeTag(key, cacheKey)
-				s.storeTag(uri, cacheKey)
+				s.storeKey(key, cacheKey, uri, expiry)
 
 				continue
 			}
 			for _, control := range controls {
 				if s.parent.candidateStore(control) {
-					s.storeTag(key, cacheKey)
-					s.storeTag(uri, cacheKey)
+					s.storeKey(key, cacheKey, uri, expiry)
 
 					break
 				}
 			}
 		} else {
-			s.storeTag(key, cacheKey)
-			s.storeTag(uri, cacheKey)
+			s.storeKey(key, cacheKey, uri, expiry)
 		}
 	}
 
 	return nil
 }
 
-// Purge take the request headers as parameter, retrieve the associated cache keys for the Surrogate-Key given.
-// It returns an array which one contains the cache keys to invalidate.
+// hierarchicalKeys returns the host tag of the request and a path tag for
+// each prefix of the uri, so purging path:/api removes the whole subtree.
+func hierarchicalKeys(rq *http.Request, uri string) []string {
+	keys := []string{}
+	if rq != nil && rq.Host != "" {
+		keys = append(keys, hostKeyPrefix+strings.ToLower(rq.Host))
+	}
+
+	prefix := ""
+	for _, segment := range strings.Split(uri, "/") {
+		if segment == "" {
+			continue
+		}
+		prefix += "/" + segment
+		keys = append(keys, pathKeyPrefix+prefix)
+	}
+	if prefix == "" && uri != "" {
+		keys = append(keys, pathKeyPrefix+"/")
+	}
+
+	return keys
+}
+
+// Purge take the request headers as parameter, retrieve the associated cache keys for the Surrogate-Key given
+// and the tags depending on them.
+// It returns an array which one contains the cache keys to invalidate and the expanded tags.
 func (s *baseStorage) Purge(header http.Header) (cacheKeys []string, surrogateKeys []string) {
-	surrogates := s.ParseHeaders(s.parent.getSurrogateKey(header))
+	surrogates, err := s.MatchTags(header)
+	if err != nil {
+		s.logger.Errorf("Impossible to purge the tags: %v", err)
+
+		return []string{}, []string{}
+	}
+
+	return s.parent.PurgeMatched(surrogates)
+}
+
+// PurgeMatched purges the tags returned by MatchTags as is, without resolving
+// them again, and notifies the CDN provider.
+func (s *baseStorage) PurgeMatched(matched []string) (cacheKeys []string, surrogateKeys []string) {
 	toInvalidate := []string{}
-	for _, su := range surrogates {
+	for _, su := range matched {
 		toInvalidate = append(toInvalidate, s.purgeTag(su)...)
 	}
 
 	s.logger.Debugf("Purge the following tags: %+v", toInvalidate)
 
-	return uniqueTag(toInvalidate), surrogates
+	return uniqueTag(toInvalidate), matched
 }
 
 // Invalidate the grouped responses from the Cache-Group-Invalidation HTTP response header
 func (s *baseStorage) Invalidate(method string, headers http.Header) {
 	if !isSafeHTTPMethod(method) {
-		for _, group := range headers["Cache-Group-Invalidation"] {
+		groups, err := s.matchTags(headers["Cache-Group-Invalidation"])
+		if err != nil {
+			s.logger.Errorf("Impossible to invalidate the groups: %v", err)
+		}
+		for _, group := range groups {
 			s.purgeTag(group)
 		}
 	}
 }
 
-// List returns the stored keys associated to resources
-func (s *baseStorage) List() map[string]string {
-	return s.Storage.MapKeys(surrogatePrefix)
+// PurgeTags purges the tags, the ones matching the patterns and their
+// dependents from the local index without notifying the CDN and returns the
+// associated cache keys.
+func (s *baseStorage) PurgeTags(tags []string) []string {
+	matched, err := s.matchTags(NonEmpty(tags))
+	if err != nil {
+		s.logger.Errorf("Impossible to purge the tags: %v", err)
+	}
+
+	toInvalidate := []string{}
+	for _, tag := range matched {
+		toInvalidate = append(toInvalidate, s.purgeTag(tag)...)
+	}
+
+	return uniqueTag(toInvalidate)
+}
+
+// InvalidateKeys notifies the provider about the cache keys invalidated
+// without tags, the providers purging by tag ignore them.
+func (*baseStorage) InvalidateKeys([]string) {}
+
+// PurgeReport returns the pending and latest CDN purge requests
+func (s *baseStorage) PurgeReport() PurgeReport {
+	return s.dispatcher.Report()
+}
+
+// Close stops the background compaction and the CDN purge requests of the
+// provider.
+func (s *baseStorage) Close() {
+	s.stopOnce.Do(func() {
+		if s.stopCompaction != nil {
+			close(s.stopCompaction)
+		}
+		s.dispatcher.Close()
+	})
+}
+
+// Reset clears the surrogate keys storage but keeps the pending CDN purge
+// requests.
+func (s *baseStorage) Reset() error {
+	if s.dispatcher != nil {
+		s.dispatcher.mu.Lock()
+		defer s.dispatcher.mu.Unlock()
+	}
+
+	jobs := s.Storage.MapKeys(purgeJobPrefix)
+	if err := s.Storage.Reset(); err != nil {
+		return err
+	}
+	for id, job := range jobs {
+		_ = s.Storage.Set(purgeJobPrefix+id, []byte(job), storageToInfiniteTTLMap[s.Storage.Name()])
+	}
+
+	return nil
 }
 
 // Destruct method will shutdown properly the provider
 func (s *baseStorage) Destruct() error {
-	return s.Storage.Reset()
+	s.Close()
+
+	return s.Reset()
 }
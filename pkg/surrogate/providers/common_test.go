@@ -2,12 +2,19 @@ package providers
 
 import (
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/darkweak/souin/configurationtypes"
+	"github.com/darkweak/souin/pkg/rfc"
 	"github.com/darkweak/souin/pkg/storage"
 	"github.com/darkweak/souin/tests"
 	"github.com/darkweak/storages/core"
@@ -32,6 +39,7 @@ func mockCommonProvider() *baseStorage {
 			dynamic:    true,
 			mu:         sync.Mutex{},
 			logger:     zap.NewNop().Sugar(),
+			duration:   storageToInfiniteTTLMap[memoryStorer.Name()],
 		},
 	}
 
@@ -57,6 +65,26 @@ func TestBaseStorage_ParseHeaders(t *testing.T) {
 	}
 }
 
+func TestBaseStorage_AppendSurrogateKeys(t *testing.T) {
+	bs := mockCommonProvider()
+
+	header := http.Header{}
+	bs.AppendSurrogateKeys(header)
+	if len(header) != 0 {
+		t.Errorf("The header should stay empty without keys, %v given.", header)
+	}
+
+	bs.AppendSurrogateKeys(header, "first", "second")
+	if header.Get(cacheGroupKey) != "first, second" {
+		t.Errorf("The Cache-Groups header should be equal to first, second, %s given.", header.Get(cacheGroupKey))
+	}
+
+	bs.AppendSurrogateKeys(header, "third")
+	if header.Get(cacheGroupKey) != "first, second, third" {
+		t.Errorf("The keys should be appended to the existing ones, %s given.", header.Get(cacheGroupKey))
+	}
+}
+
 func TestBaseStorage_Purge(t *testing.T) {
 	bs := mockCommonProvider()
 	headerMock := http.Header{}
@@ -173,38 +201,468 @@ func TestBaseStorage_Store_Load(t *testing.T) {
 	// }
 }
 
-func TestContainsCacheKey(t *testing.T) {
-	testCases := []struct {
-		name         string
-		currentValue string
-		cacheKey     string
-		expected     bool
-	}{
-		{"empty current value", "", "key1", false},
-		{"exact match single key", "key1", "key1", true},
-		{"key at beginning", "key1,key2,key3", "key1", true},
-		{"key at end", "key1,key2,key3", "key3", true},
-		{"key in middle", "key1,key2,key3", "key2", true},
-		{"key not present", "key1,key2,key3", "key4", false},
-		{"partial match should not match", "key1,key2,key3", "key", false},
-		{"partial match at start should not match", "key12,key2,key3", "key1", false},
-		{"partial match at end should not match", "key1,key2,key34", "key3", false},
-		{"url encoded key present", "%2Fapi%2Fusers,other", "%2Fapi%2Fusers", true},
-		{"url encoded key not present", "%2Fapi%2Fusers,other", "%2Fapi%2Fposts", false},
-		{"key with special chars", "a%2Fb,c%2Fd", "a%2Fb", true},
-		{"empty key in empty value", "", "", false},
-		{"empty key in non-empty value", "key1,key2", "", false},
-		{"similar keys should not match", "product-123,product-1234", "product-12", false},
-		{"leading comma in stored value", ",key1,key2", "key1", true},
-	}
-
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			result := containsCacheKey(tc.currentValue, tc.cacheKey)
-			if result != tc.expected {
-				t.Errorf("containsCacheKey(%q, %q) = %v, expected %v",
-					tc.currentValue, tc.cacheKey, result, tc.expected)
-			}
-		})
+func TestBaseStorage_Index(t *testing.T) {
+	bs := mockCommonProvider()
+	_ = bs.Storage.Reset()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(iteration int) {
+			defer wg.Done()
+			res := http.Response{Header: http.Header{}}
+			res.Header.Set(surrogateKey, "popular, other")
+			res.Header.Set(rfc.StoredTTLHeader, "1m0s")
+			res.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+			_ = bs.Store(&res, fmt.Sprintf("key_%d", iteration), "/uri")
+		}(i)
+	}
+	wg.Wait()
+
+	list := bs.List()
+	if keys := strings.Split(list["popular"], souinStorageSeparator); len(keys) != 100 {
+		t.Errorf("The popular tag must index the 100 concurrently stored keys, %d given.", len(keys))
+	}
+	if expiry, ok := bs.members(indexSetKey(surrogateIndexPrefix, "popular"))["key_1"]; !ok || expiry == 0 {
+		t.Errorf("The entry must store the response expiry, %d given.", expiry)
+	}
+
+	_ = bs.Storage.Set(surrogatePrefix+"popular", []byte("legacy_1,legacy_2"), storageToInfiniteTTLMap[bs.Storage.Name()])
+	header := http.Header{}
+	header.Set(surrogateKey, "popular")
+	bs.keepStale = false
+	if keys, _ := bs.Purge(header); len(keys) != 102 {
+		t.Errorf("The purge must return the indexed and legacy keys, %d given.", len(keys))
+	}
+	if _, ok := bs.List()["popular"]; ok {
+		t.Error("The purged tag must be removed from the index.")
+	}
+	if keys := strings.Split(bs.List()["other"], souinStorageSeparator); len(keys) != 100 {
+		t.Errorf("The other tags must be kept, %d keys given.", len(keys))
+	}
+}
+
+func TestBaseStorage_HierarchicalKeys(t *testing.T) {
+	bs := mockCommonProvider()
+	_ = bs.Storage.Reset()
+	bs.keepStale = false
+	bs.hierarchical = true
+
+	for key, uri := range map[string]string{"users": "/api/users", "user": "/api/users/42", "orders": "/api/orders", "home": "/"} {
+		rq := httptest.NewRequest(http.MethodGet, "http://Example.com"+uri, nil)
+		_ = bs.Store(&http.Response{Header: http.Header{}, Request: rq}, key, uri)
+	}
+
+	if keys := hierarchicalKeys(httptest.NewRequest(http.MethodGet, "http://example.com/api/users/", nil), "/api/users/"); strings.Join(keys, " ") != "host:example.com path:/api path:/api/users" {
+		t.Errorf("Unexpected hierarchical keys %v.", keys)
+	}
+	if keys := bs.List()["path:/"]; keys != "home" {
+		t.Errorf("The root must be tagged path:/, %q given.", keys)
+	}
+
+	header := http.Header{}
+	header.Set(surrogateKey, "path:/api/users")
+	keys, _ := bs.Purge(header)
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "user,users" {
+		t.Errorf("The purge must remove the whole subtree, %v given.", keys)
+	}
+
+	header.Set(surrogateKey, "host:example.com")
+	if keys, _ = bs.Purge(header); len(keys) != 4 {
+		t.Errorf("The host purge must remove every entry of the host, %v given.", keys)
+	}
+}
+
+func TestBaseStorage_BodyKeys(t *testing.T) {
+	bs := mockCommonProvider()
+	_ = bs.Storage.Reset()
+	bs.keepStale = false
+	bs.bodyMaxBytes = 512
+	bs.bodyMaxTags = 4
+
+	products, _, _ := compileBodyKeysRule(configurationtypes.SurrogateKeys{
+		JSONPaths: []configurationtypes.SurrogateJSONPath{{Path: "$.items[*].id", Prefix: "product:"}, {Path: "$..brand"}},
+	}, regexp.MustCompile("^/products"))
+	graphql, _, _ := compileBodyKeysRule(configurationtypes.SurrogateKeys{GraphQL: true}, regexp.MustCompile("^/graphql"))
+	bs.bodyRules = []bodyKeysRule{products, graphql}
+
+	if bs.BodyKeysLimit() != 512 {
+		t.Errorf("The body keys limit must be 512, %d given.", bs.BodyKeysLimit())
+	}
+	if _, _, err := compileBodyKeysRule(configurationtypes.SurrogateKeys{JSONPaths: []configurationtypes.SurrogateJSONPath{{Path: "items[0"}}}, nil); err == nil {
+		t.Error("The invalid JSONPath must be rejected.")
+	}
+
+	store := func(key, uri, contentType, body string) {
+		header := http.Header{}
+		header.Set("Content-Type", contentType)
+		header.Set(surrogateKey, "header")
+		_ = bs.Store(&http.Response{Header: header, Body: io.NopCloser(strings.NewReader(body))}, key, uri)
+	}
+	store("list", "/products", "application/json; charset=utf-8", `{"items":[{"id":1,"brand":"acme"},{"id":"2"},{"id":{"nested":true}}]}`)
+	store("query", "/graphql", "application/graphql-response+json", `{"data":{"product":{"__typename":"Product","id":"42","reviews":[{"__typename":"Review","id":7}]}}}`)
+	store("html", "/products/html", "text/html", `{"items":[{"id":3}]}`)
+	store("large", "/products/large", "application/json", `{"items":[`+strings.Repeat(`{"id":4},`, 100)+`{"id":5}]}`)
+	store("many", "/products/many", "application/json", `{"items":[{"id":6},{"id":7},{"id":8},{"id":9},{"id":10}]}`)
+
+	list := bs.List()
+	for tag, expected := range map[string]string{
+		"product:1":  "list",
+		"product:2":  "list",
+		"acme":       "list",
+		"Product:42": "query",
+		"Review:7":   "query",
+		"product:3":  "",
+		"product:5":  "",
+		"product:9":  "many",
+		"product:10": "",
+	} {
+		if list[tag] != expected {
+			t.Errorf("The tag %s must reference %q, %q given.", tag, expected, list[tag])
+		}
+	}
+	if !strings.Contains(list["header"], "html") {
+		t.Errorf("The header keys must still be stored, %q given.", list["header"])
+	}
+}
+
+func TestBaseStorage_Dependencies(t *testing.T) {
+	bs := mockCommonProvider()
+	_ = bs.Storage.Reset()
+	bs.keepStale = false
+	bs.maxDependencyDepth = 3
+	bs.dependencies, _ = compileDependencies(map[string][]string{
+		"category:{id}":    {"listing:{id}", "listing:{id}:page"},
+		"a":                {"b"},
+		"b":                {"c"},
+		"c":                {"a", "d"},
+		"d":                {"e"},
+		"price:{currency}": {"$total:{currency}"},
+	})
+
+	store := func(key, tags, depends string) {
+		header := http.Header{}
+		header.Set(surrogateKey, tags)
+		header.Set(surrogateDepends, depends)
+		_ = bs.Store(&http.Response{Header: header}, key, "/"+key)
+	}
+	store("listing", "listing:12", "")
+	store("product", "product:5", "category:12")
+	store("orphan", "", "category:12")
+	store("other", "product:6", "category:13")
+
+	header := http.Header{}
+	header.Set(surrogateKey, "category:12")
+	keys, tags := bs.Purge(header)
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "listing,orphan,product" {
+		t.Errorf("The purge must remove the dependent entries, %v given.", keys)
+	}
+	if strings.Join(tags, ",") != "category:12,listing:12,listing:12:page,/orphan,product:5" {
+		t.Errorf("The purge must report the expanded tags, %v given.", tags)
+	}
+
+	if tags, _ := bs.expandTags([]string{"a"}); strings.Join(tags, ",") != "a,b,c,d" {
+		t.Errorf("The expansion must stop on cycles and at the maximum depth, %v given.", tags)
+	}
+	if tags, _ := bs.expandTags([]string{"price:eur"}); strings.Join(tags, ",") != "price:eur,$total:eur" {
+		t.Errorf("The dependent tags must keep the dollar signs, %v given.", tags)
+	}
+	if keys := bs.PurgeTags([]string{"category:13"}); len(keys) != 1 || keys[0] != "other" {
+		t.Errorf("The local purge must expand the dependencies, %v given.", keys)
+	}
+}
+
+func TestBaseStorage_Wildcards(t *testing.T) {
+	bs := mockCommonProvider()
+	_ = bs.Storage.Reset()
+	bs.keepStale = false
+	bs.maxWildcardTags = 3
+
+	for key, tags := range map[string]string{
+		"first":  "product:1, tenant:42:users",
+		"second": "product:2, tenant:42:orders",
+		"third":  "product:3, tenant:43:users",
+		"fourth": "product:4, product",
+	} {
+		header := http.Header{}
+		header.Set(surrogateKey, tags)
+		_ = bs.Store(&http.Response{Header: header}, key, "/"+key)
+	}
+	_ = bs.Storage.Set(surrogatePrefix+"tenant:42:legacy", []byte("legacy"), storageToInfiniteTTLMap[bs.Storage.Name()])
+	bs.Compact()
+
+	header := http.Header{}
+	header.Set(surrogateKey, "product:*")
+	if _, err := bs.MatchTags(header); err == nil {
+		t.Error("The pattern matching more tags than allowed must be rejected.")
+	}
+	if keys, tags := bs.Purge(header); len(keys) != 0 || len(tags) != 0 {
+		t.Errorf("The rejected pattern must not purge anything, %v %v given.", keys, tags)
+	}
+
+	header.Set(surrogateKey, "tenant:42:*")
+	tags, err := bs.MatchTags(header)
+	if err != nil || strings.Join(tags, ",") != "tenant:42:legacy,tenant:42:orders,tenant:42:users" {
+		t.Errorf("Unexpected matched tags %v, %v.", tags, err)
+	}
+	if len(bs.List()["tenant:42:users"]) == 0 {
+		t.Error("The match must not purge the tags.")
+	}
+
+	header.Set(surrogateKey, "tenant:*:users, product")
+	keys, tags := bs.Purge(header)
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "first,fourth,third" || strings.Join(tags, ",") != "tenant:42:users,tenant:43:users,product" {
+		t.Errorf("Unexpected purged keys %v and tags %v.", keys, tags)
+	}
+
+	bs.maxWildcardTags = 4
+	if keys := bs.PurgeTags([]string{"product:*"}); len(keys) != 4 {
+		t.Errorf("The local purge must resolve the patterns, %v given.", keys)
+	}
+
+	for key, tags := range map[string]string{"literal": "sale*off", "other": "sale-50-off"} {
+		_ = bs.Store(&http.Response{Header: http.Header{surrogateKey: {tags}}}, key, "/"+key)
+	}
+	header.Set(surrogateKey, `sale\*off`)
+	if tags, err = bs.MatchTags(header); err != nil || strings.Join(tags, ",") != "sale*off" {
+		t.Errorf("The escaped * must match the literal tag only, %v given with %v.", tags, err)
+	}
+	header.Set(surrogateKey, `sale\**`)
+	if tags, err = bs.MatchTags(header); err != nil || strings.Join(tags, ",") != "sale*off" {
+		t.Errorf("The escaped * must be part of the pattern prefix, %v given with %v.", tags, err)
+	}
+	if keys := bs.PurgeTags(EscapeWildcards([]string{"sale*off"})); strings.Join(keys, ",") != "literal" {
+		t.Errorf("The escaped tags must be purged literally, %v given.", keys)
+	}
+}
+
+func TestBaseStorage_WildcardDependencies(t *testing.T) {
+	bs := mockCommonProvider()
+	_ = bs.Storage.Reset()
+	bs.keepStale = false
+	bs.maxWildcardTags = 2
+	bs.maxDependencyDepth = defaultMaxDependencyDepth
+	bs.dependencies, _ = compileDependencies(map[string][]string{
+		"category:{id}": {"listing:{id}:*"},
+	})
+
+	for key, tags := range map[string]string{
+		"first":  "listing:12:page1",
+		"second": "listing:12:page2",
+		"third":  "listing:13:page1",
+		"fourth": "listing:14:page1, listing:14:page2, listing:14:page3",
+	} {
+		header := http.Header{}
+		header.Set(surrogateKey, tags)
+		_ = bs.Store(&http.Response{Header: header}, key, "/"+key)
+	}
+
+	header := http.Header{}
+	header.Set(surrogateKey, "category:12")
+	keys, tags := bs.Purge(header)
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "first,second" || strings.Join(tags, ",") != "category:12,listing:12:page1,listing:12:page2" {
+		t.Errorf("The dependent pattern must be resolved, %v %v given.", keys, tags)
+	}
+
+	header.Set(surrogateKey, "category:14")
+	if _, err := bs.MatchTags(header); err == nil {
+		t.Error("The dependent pattern matching more tags than allowed must be rejected.")
+	}
+	if len(bs.List()["listing:14:page1"]) == 0 {
+		t.Error("The rejected dependent pattern must not purge anything.")
+	}
+
+	if keys, tags := bs.PurgeMatched([]string{"category:14", "listing:14:page1"}); len(keys) != 1 || keys[0] != "fourth" || len(tags) != 2 {
+		t.Errorf("The matched tags must be purged as is, %v %v given.", keys, tags)
+	}
+	if len(bs.List()["listing:14:page2"]) == 0 {
+		t.Error("The matched tags must not be expanded again.")
+	}
+}
+
+func TestBaseStorage_ReverseLookup(t *testing.T) {
+	bs := mockCommonProvider()
+	_ = bs.Storage.Reset()
+	bs.keepStale = false
+
+	store := func(key, uri, tags, size string) {
+		header := http.Header{}
+		header.Set(surrogateKey, tags)
+		header.Set(rfc.StoredLengthHeader, size)
+		_ = bs.Store(&http.Response{Header: header}, key, uri)
+	}
+	store("GET-http-example.com-/products/1", "/products/1", "product:1, products", "100")
+	store("GET-http-example.com-/products/1-gzip", "/products/1", "product:1, products", "40")
+	store("GET-http-example.com-/products", "/products", "products", "1000")
+	store("GET-http-example.com-/products", "/products", "products, listing", "1200")
+
+	if tags := bs.LookupKey("GET-http-example.com-/products/1"); strings.Join(tags, ",") != "product:1,products" {
+		t.Errorf("Unexpected tags %v for the key.", tags)
+	}
+	lookup := bs.LookupURI("/products/1")
+	if len(lookup) != 2 || strings.Join(lookup["GET-http-example.com-/products/1-gzip"], ",") != "product:1,products" {
+		t.Errorf("Unexpected tags %v for the uri.", lookup)
+	}
+
+	stats := bs.Stats()
+	if stats["products"] != (TagStats{Entries: 3, Bytes: 1340}) || stats["product:1"] != (TagStats{Entries: 2, Bytes: 140}) {
+		t.Errorf("Unexpected stats %+v.", stats)
+	}
+
+	header := http.Header{}
+	header.Set(surrogateKey, "product:1")
+	_, _ = bs.Purge(header)
+	if tags := bs.LookupKey("GET-http-example.com-/products/1"); strings.Join(tags, ",") != "products" {
+		t.Errorf("The purge must update the reverse index, %v given.", tags)
+	}
+}
+
+// BenchmarkBaseStorage_Index compares the index lookups with a prefix scan
+// of a storer holding 100000 other keys.
+func BenchmarkBaseStorage_Index(b *testing.B) {
+	bs := mockCommonProvider()
+	_ = bs.Storage.Reset()
+	for i := 0; i < 100000; i++ {
+		_ = bs.Storage.Set(fmt.Sprintf("GET-http-example.com-/page/%d", i), []byte("response"), time.Hour)
+	}
+	store := func(i int) {
+		header := http.Header{}
+		header.Set(surrogateKey, fmt.Sprintf("product:%d, products", i%1000))
+		_ = bs.Store(&http.Response{Header: header}, fmt.Sprintf("GET-http-example.com-/products/%d", i%1000), fmt.Sprintf("/products/%d", i%1000))
+	}
+	for i := 0; i < 1000; i++ {
+		store(i)
+	}
+
+	b.Run("Store", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			store(i)
+		}
+	})
+	b.Run("Lookup", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, _ = bs.tagKeys("products")
+		}
+	})
+	b.Run("Wildcard", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = bs.storedTags("product:")
+		}
+	})
+	b.Run("PrefixScan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = bs.Storage.MapKeys(indexSetKey(surrogateIndexPrefix, "products"))
+		}
+	})
+}
+
+func TestBaseStorage_Compact(t *testing.T) {
+	bs := mockCommonProvider()
+	_ = bs.Storage.Reset()
+	bs.checkExistence = true
+
+	_ = bs.Storage.Set(core.MappingKeyPrefix+"existing", []byte("mapping"), time.Minute)
+	_ = bs.Storage.Set("missing", []byte("response without mapping"), time.Minute)
+	_ = bs.Storage.Set(surrogatePrefix+"legacy", []byte("existing,missing"), storageToInfiniteTTLMap[bs.Storage.Name()])
+	bs.storeTag("expired", "existing", time.Now().Add(-time.Minute))
+	outdated := indexSetKey(surrogateIndexPrefix, "outdated")
+	_ = bs.Storage.Set(outdated, []byte("idle.0"), time.Minute)
+	_ = bs.Storage.Set(outdated+surrogateIndexSeparator+"idle.0", formatEntries([]indexEntry{{member: "existing", expiry: time.Now().Add(-time.Minute).Unix()}}), time.Minute)
+	bs.storeTag("fresh", "existing", time.Now().Add(time.Minute))
+	orphaned := indexSetKey(surrogateIndexPrefix, "orphaned")
+	_ = bs.Storage.Set(orphaned+surrogateIndexSeparator+"idle.0", formatEntries([]indexEntry{{member: "existing"}}), time.Minute)
+	live := indexSetKey(surrogateIndexPrefix, "live")
+	_ = bs.Storage.Set(surrogateWriterPrefix+"live", []byte(fmt.Sprint(time.Now().Unix())), time.Minute)
+	_ = bs.Storage.Set(live, []byte("live.0"), time.Minute)
+	_ = bs.Storage.Set(live+surrogateIndexSeparator+"live.0", formatEntries([]indexEntry{{member: "missing"}}), time.Minute)
+
+	bs.Compact()
+
+	if len(bs.Storage.Get(surrogatePrefix+"legacy")) != 0 {
+		t.Error("The legacy tag must be migrated.")
+	}
+	list := bs.List()
+	if list["legacy"] != "existing" {
+		t.Errorf("The migrated tag must only keep the existing key, %q given.", list["legacy"])
+	}
+	if len(bs.Storage.Get(outdated)) != 0 || len(bs.Storage.Get(outdated+surrogateIndexSeparator+"idle.0")) != 0 {
+		t.Error("The expired entry must be dropped with its segment.")
+	}
+	if keys, _ := bs.tagKeys("orphaned"); len(keys) != 1 || keys[0] != "existing" {
+		t.Errorf("The orphan segment must be listed again, %v given.", keys)
+	}
+	if keys, _ := bs.tagKeys("live"); len(keys) != 1 || keys[0] != "missing" {
+		t.Errorf("The segment of an active instance must be left to it, %v given.", keys)
+	}
+	if list["fresh"] != "existing" {
+		t.Errorf("The fresh entry must be kept, %q given.", list["fresh"])
+	}
+}
+
+func TestBaseStorage_CompactReverseLookup(t *testing.T) {
+	bs := mockCommonProvider()
+	_ = bs.Storage.Reset()
+	bs.checkExistence = true
+
+	existing, missing := "GET-http-example.com-/products?page=1", "GET-http-example.com-/products?page=2"
+	_ = bs.Storage.Set(core.MappingKeyPrefix+existing, []byte("mapping"), time.Minute)
+	_ = bs.Storage.Set(missing, []byte("response without mapping"), time.Minute)
+	for _, key := range []string{existing, missing} {
+		bs.storeReverse("products", url.QueryEscape(key), time.Now().Add(time.Minute))
+		bs.storeSize(&http.Response{Header: http.Header{rfc.StoredLengthHeader: {"100"}}}, url.QueryEscape(key), time.Now().Add(time.Minute))
+	}
+
+	bs.Compact()
+
+	if tags := bs.LookupKey(existing); strings.Join(tags, ",") != "products" {
+		t.Errorf("The reverse entry of the mapped key must be kept, %v given.", tags)
+	}
+	if tags := bs.LookupKey(missing); len(tags) != 0 {
+		t.Errorf("The reverse entry of the key without mapping must be dropped, %v given.", tags)
+	}
+	if sizes := bs.Storage.MapKeys(surrogateSizePrefix); len(sizes) != 1 {
+		t.Errorf("Only the size entry of the mapped key must be kept, %v given.", sizes)
+	}
+	bs.storeSize(&http.Response{Header: http.Header{"Content-Length": {"42"}}}, url.QueryEscape(existing), time.Now().Add(time.Minute))
+	if size, _ := bs.size(url.QueryEscape(existing)); size != 42 {
+		t.Errorf("The size entry must be overwritten, %d given.", size)
+	}
+}
+
+func TestBaseStorage_Close(t *testing.T) {
+	bs := mockCommonProvider()
+	bs.stopCompaction = make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		bs.compactEvery(time.Millisecond, bs.stopCompaction)
+		close(stopped)
+	}()
+
+	bs.Close()
+	bs.Close()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("The compaction must stop once the provider is closed.")
+	}
+}
+
+func TestBaseStorage_Reset(t *testing.T) {
+	bs := mockCommonProvider()
+	_ = bs.Storage.Set(surrogateIndexPrefix+"tag|key", []byte("0"), time.Minute)
+	_ = bs.Storage.Set(purgeJobPrefix+"pending", []byte(`{"id":"pending"}`), time.Minute)
+
+	if err := bs.Reset(); err != nil {
+		t.Fatalf("The reset must succeed, %v given.", err)
+	}
+	if len(bs.Storage.MapKeys(surrogateIndexPrefix)) != 0 {
+		t.Error("The reset must clear the surrogate index.")
+	}
+	if jobs := bs.Storage.MapKeys(purgeJobPrefix); jobs["pending"] != `{"id":"pending"}` {
+		t.Errorf("The reset must keep the pending purge requests, %v given.", jobs)
 	}
 }
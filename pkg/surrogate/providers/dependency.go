@@ -0,0 +1,141 @@
+package providers
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The dependencies declared by the responses through the Surrogate-Depends
+// header are stored as sets of the surrogate index under SURROGATE-DEP_, the
+// dependent tags of each tag with the expiry of the response.
+const (
+	surrogateDepends          = "Surrogate-Depends"
+	surrogateDependencyPrefix = "SURROGATE-DEP_"
+
+	defaultMaxDependencyDepth = 5
+)
+
+var dependencyPlaceholder = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// dependencyRule is a declared dependency. The {name} placeholders of the
+// source tag match a value without colon reused in the dependent tags.
+type dependencyRule struct {
+	source  *regexp.Regexp
+	targets []string
+}
+
+func compileDependencies(dependencies map[string][]string) ([]dependencyRule, error) {
+	sources := make([]string, 0, len(dependencies))
+	for source := range dependencies {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	rules := make([]dependencyRule, 0, len(sources))
+	for _, source := range sources {
+		pattern := "^"
+		last := 0
+		for _, match := range dependencyPlaceholder.FindAllStringSubmatchIndex(source, -1) {
+			pattern += regexp.QuoteMeta(source[last:match[0]]) + "(?P<" + source[match[2]:match[3]] + ">[^:]+)"
+			last = match[1]
+		}
+		compiled, err := regexp.Compile(pattern + regexp.QuoteMeta(source[last:]) + "$")
+		if err != nil {
+			return nil, fmt.Errorf("the dependency %q is invalid: %v", source, err)
+		}
+
+		rule := dependencyRule{source: compiled}
+		for _, target := range dependencies[source] {
+			rule.targets = append(rule.targets, dependencyPlaceholder.ReplaceAllString(strings.ReplaceAll(target, "$", "$$"), "$${$1}"))
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// storeDependencies makes each tag of the response depend on the tags listed
+// in its Surrogate-Depends header, or the request path when it has no tag.
+func (s *baseStorage) storeDependencies(depends string, tags []string, uri string, expiry time.Time) {
+	dependencies := NonEmpty(s.ParseHeaders(depends))
+	if len(dependencies) == 0 {
+		return
+	}
+
+	tags = NonEmpty(tags)
+	if len(tags) == 0 {
+		tags = []string{uri}
+	}
+
+	for _, dependency := range dependencies {
+		setKey := indexSetKey(surrogateDependencyPrefix, dependency)
+		for _, tag := range tags {
+			if tag != dependency {
+				s.addMember(setKey, tag, expiry)
+			}
+		}
+	}
+}
+
+// dependents returns the tags depending on the given one, from the declared
+// rules and the stored dependencies.
+func (s *baseStorage) dependents(tag string) []string {
+	tags := []string{}
+	for _, rule := range s.dependencies {
+		match := rule.source.FindStringSubmatchIndex(tag)
+		if match == nil {
+			continue
+		}
+		for _, target := range rule.targets {
+			tags = append(tags, string(rule.source.ExpandString(nil, target, tag, match)))
+		}
+	}
+
+	return append(tags, s.memberList(indexSetKey(surrogateDependencyPrefix, tag))...)
+}
+
+// expandTags walks the dependency graph breadth first from the tags and
+// returns them followed by every tag depending on them, transitively up to
+// the maximum depth. Each tag is visited once so the cycles end the walk. The
+// dependent patterns are resolved against the stored tags like the purged
+// ones and fail the same way when they match more tags than allowed.
+func (s *baseStorage) expandTags(tags []string) ([]string, error) {
+	visited := map[string]bool{}
+	expanded := append([]string{}, tags...)
+	current := []string{}
+	for _, tag := range NonEmpty(tags) {
+		if !visited[tag] {
+			visited[tag] = true
+			current = append(current, tag)
+		}
+	}
+
+	for depth := 0; len(current) > 0; depth++ {
+		next := []string{}
+		for _, tag := range current {
+			dependents, err := s.resolveWildcards(s.dependents(tag))
+			if err != nil {
+				return nil, err
+			}
+			for _, dependent := range dependents {
+				if dependent == "" || visited[dependent] {
+					continue
+				}
+				if depth >= s.maxDependencyDepth {
+					s.logger.Debugf("Stop the dependencies expansion of %s at the depth %d", tag, depth)
+
+					continue
+				}
+				visited[dependent] = true
+				expanded = append(expanded, dependent)
+				next = append(next, dependent)
+			}
+		}
+		current = next
+	}
+
+	return expanded, nil
+}
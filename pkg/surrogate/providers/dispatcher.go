@@ -0,0 +1,410 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/darkweak/souin/configurationtypes"
+	"github.com/darkweak/souin/pkg/api/prometheus"
+	"github.com/darkweak/souin/pkg/storage/types"
+	"github.com/darkweak/storages/core"
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+)
+
+const (
+	purgeJobPrefix = "SURROGATE-PURGE_"
+
+	defaultPurgeMaxAttempts = 10
+	defaultPurgeBackoff     = time.Second
+	maxPurgeBackoff         = 5 * time.Minute
+	defaultPurgeTimeout     = 10 * time.Second
+	maxPurgeResults         = 100
+	maxPurgeResponseBody    = 1024
+	purgeJobLease           = time.Minute
+)
+
+// PurgeJob is a purge request waiting to be sent to the CDN provider.
+type PurgeJob struct {
+	ID          string      `json:"id"`
+	Provider    string      `json:"provider"`
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+	Attempts    int         `json:"attempts"`
+	NextAttempt time.Time   `json:"next_attempt"`
+	LastError   string      `json:"last_error,omitempty"`
+	Owner       string      `json:"owner,omitempty"`
+	LeaseUntil  time.Time   `json:"lease_until,omitempty"`
+}
+
+// PurgeResult is the outcome of a purge request sent to the CDN provider.
+type PurgeResult struct {
+	ID         string    `json:"id"`
+	Provider   string    `json:"provider"`
+	URL        string    `json:"url"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Attempts   int       `json:"attempts"`
+	Success    bool      `json:"success"`
+	Error      string    `json:"error,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// PurgeReport lists the pending purge requests and the latest results.
+type PurgeReport struct {
+	Pending []PurgeJob    `json:"pending"`
+	Results []PurgeResult `json:"results"`
+}
+
+type purgeError struct {
+	retryable bool
+	message   string
+}
+
+func (e *purgeError) Error() string {
+	return e.message
+}
+
+// purgeDispatcher sends the purge requests of a CDN provider. The pending
+// requests are persisted in the surrogate storer to survive a restart,
+// retried with an exponential backoff and sent under a rate limit. The
+// credentials are added by authorize when sending, so they aren't persisted.
+// The instances sharing the storer resume the same requests, each one leases
+// a request before sending it so the others skip it until the lease expires.
+type purgeDispatcher struct {
+	provider    string
+	owner       string
+	lease       time.Duration
+	client      *http.Client
+	storage     types.Storer
+	logger      core.Logger
+	limiter     *rate.Limiter
+	maxAttempts int
+	backoff     time.Duration
+	authorize   func(*http.Request, []byte)
+
+	mu      sync.Mutex
+	pending map[string]*PurgeJob
+	results []PurgeResult
+	wake    chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func newPurgeDispatcher(provider string, c configurationtypes.CDNPurge, storage types.Storer, logger core.Logger, authorize func(*http.Request, []byte)) *purgeDispatcher {
+	d := &purgeDispatcher{
+		provider:    provider,
+		owner:       uuid.NewString(),
+		authorize:   authorize,
+		client:      &http.Client{Timeout: c.Timeout.Duration},
+		storage:     storage,
+		logger:      logger,
+		limiter:     rate.NewLimiter(rate.Inf, 1),
+		maxAttempts: c.MaxAttempts,
+		backoff:     c.Backoff.Duration,
+		pending:     make(map[string]*PurgeJob),
+		wake:        make(chan struct{}, 1),
+	}
+	if d.client.Timeout == 0 {
+		d.client.Timeout = defaultPurgeTimeout
+	}
+	if c.RateLimit > 0 {
+		d.limiter = rate.NewLimiter(rate.Limit(c.RateLimit), 1)
+	}
+	if d.maxAttempts <= 0 {
+		d.maxAttempts = defaultPurgeMaxAttempts
+	}
+	if d.backoff <= 0 {
+		d.backoff = defaultPurgeBackoff
+	}
+	d.lease = max(purgeJobLease, 2*d.client.Timeout)
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+
+	for _, value := range storage.MapKeys(purgeJobPrefix) {
+		var job PurgeJob
+		if err := json.Unmarshal([]byte(value), &job); err == nil && job.Provider == provider {
+			d.pending[job.ID] = &job
+		}
+	}
+	if len(d.pending) > 0 {
+		logger.Infof("Resume %d pending %s purge requests", len(d.pending), provider)
+	}
+	d.updateQueueSize()
+
+	go d.run()
+
+	return d
+}
+
+// Enqueue persists the purge request and schedules it immediately.
+func (d *purgeDispatcher) Enqueue(method, url string, header http.Header, body []byte) {
+	job := &PurgeJob{
+		ID:          uuid.NewString(),
+		Provider:    d.provider,
+		Method:      method,
+		URL:         url,
+		Header:      header,
+		Body:        body,
+		NextAttempt: time.Now(),
+		Owner:       d.owner,
+	}
+	job.LeaseUntil = job.NextAttempt.Add(d.lease)
+
+	d.mu.Lock()
+	d.pending[job.ID] = job
+	d.persist(job)
+	d.updateQueueSize()
+	d.mu.Unlock()
+
+	d.notify()
+}
+
+func (d *purgeDispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// persist must be called with the lock held.
+func (d *purgeDispatcher) persist(job *PurgeJob) {
+	if value, err := json.Marshal(job); err == nil {
+		_ = d.storage.Set(purgeJobPrefix+job.ID, value, storageToInfiniteTTLMap[d.storage.Name()])
+	}
+}
+
+// updateQueueSize must be called with the lock held.
+func (d *purgeDispatcher) updateQueueSize() {
+	prometheus.Set(prometheus.CDNPurgeQueueSize, float64(len(d.pending)))
+}
+
+// next returns the earliest scheduled job and the delay before it's due.
+func (d *purgeDispatcher) next() (*PurgeJob, time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var next *PurgeJob
+	for _, job := range d.pending {
+		if next == nil || job.NextAttempt.Before(next.NextAttempt) {
+			next = job
+		}
+	}
+	if next == nil {
+		return nil, 0
+	}
+
+	return next, time.Until(next.NextAttempt)
+}
+
+func (d *purgeDispatcher) run() {
+	for {
+		job, delay := d.next()
+		if job == nil {
+			select {
+			case <-d.wake:
+				continue
+			case <-d.ctx.Done():
+				return
+			}
+		}
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-d.wake:
+				timer.Stop()
+				continue
+			case <-d.ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+
+		if d.limiter.Wait(d.ctx) != nil {
+			return
+		}
+		if !d.claim(job) {
+			continue
+		}
+		statusCode, err := d.send(job)
+		d.done(job, statusCode, err)
+	}
+}
+
+// claim leases the job to this instance before sending it. The job leased by
+// another instance is postponed until its lease expires and the one removed
+// from the storer, already sent by another instance, is forgotten.
+//
+// The storer has no atomic set-if-absent so the lease is a read followed by a
+// write, read again to back off when another instance overwrote it. Two
+// instances claiming the job at the same time may still both send it, the CDN
+// purges being idempotent a duplicate send only costs a request.
+func (d *purgeDispatcher) claim(job *PurgeJob) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	value := d.storage.Get(purgeJobPrefix + job.ID)
+	if len(value) == 0 {
+		delete(d.pending, job.ID)
+		d.updateQueueSize()
+
+		return false
+	}
+
+	var stored PurgeJob
+	if err := json.Unmarshal(value, &stored); err == nil {
+		if stored.Owner != "" && stored.Owner != d.owner && time.Now().Before(stored.LeaseUntil) {
+			job.NextAttempt = stored.LeaseUntil
+
+			return false
+		}
+		job.Attempts = stored.Attempts
+		job.LastError = stored.LastError
+	}
+
+	job.Owner = d.owner
+	job.LeaseUntil = time.Now().Add(d.lease)
+	d.persist(job)
+
+	var leased PurgeJob
+	if err := json.Unmarshal(d.storage.Get(purgeJobPrefix+job.ID), &leased); err == nil && leased.Owner != d.owner {
+		job.NextAttempt = leased.LeaseUntil
+
+		return false
+	}
+
+	return true
+}
+
+// Close stops sending the purge requests and releases the leased ones so
+// another instance can send them without waiting for the lease expiry.
+func (d *purgeDispatcher) Close() {
+	if d == nil {
+		return
+	}
+
+	d.cancel()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, job := range d.pending {
+		if job.Owner == d.owner {
+			job.Owner = ""
+			job.LeaseUntil = time.Time{}
+			d.persist(job)
+		}
+	}
+}
+
+func (d *purgeDispatcher) send(job *PurgeJob) (int, error) {
+	rq, err := http.NewRequest(job.Method, job.URL, bytes.NewReader(job.Body))
+	if err != nil {
+		return 0, &purgeError{message: err.Error()}
+	}
+	for name, values := range job.Header {
+		rq.Header[name] = values
+	}
+	if d.authorize != nil {
+		d.authorize(rq, job.Body)
+	}
+
+	res, err := d.client.Do(rq)
+	if err != nil {
+		return 0, &purgeError{retryable: true, message: err.Error()}
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	if res.StatusCode >= http.StatusOK && res.StatusCode < http.StatusMultipleChoices {
+		_, _ = io.Copy(io.Discard, res.Body)
+		return res.StatusCode, nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(res.Body, maxPurgeResponseBody))
+
+	return res.StatusCode, &purgeError{
+		retryable: res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= http.StatusInternalServerError,
+		message:   fmt.Sprintf("unexpected status %d: %s", res.StatusCode, bytes.TrimSpace(body)),
+	}
+}
+
+func (d *purgeDispatcher) done(job *PurgeJob, statusCode int, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	job.Attempts++
+	if err != nil {
+		job.LastError = err.Error()
+		if pErr, ok := err.(*purgeError); ok && pErr.retryable && job.Attempts < d.maxAttempts {
+			backoff := d.backoff << (job.Attempts - 1)
+			if backoff <= 0 || backoff > maxPurgeBackoff {
+				backoff = maxPurgeBackoff
+			}
+			job.NextAttempt = time.Now().Add(backoff)
+			job.LeaseUntil = job.NextAttempt.Add(d.lease)
+			d.persist(job)
+			prometheus.Increment(prometheus.CDNPurgeRetryCounter)
+			d.logger.Debugf("Retry the %s purge request %s in %v: %v", d.provider, job.ID, backoff, err)
+
+			return
+		}
+
+		prometheus.Increment(prometheus.CDNPurgeFailureCounter)
+		d.logger.Errorf("Abandon the %s purge request %s after %d attempts: %v", d.provider, job.ID, job.Attempts, err)
+	} else {
+		prometheus.Increment(prometheus.CDNPurgeSuccessCounter)
+	}
+
+	delete(d.pending, job.ID)
+	d.storage.Delete(purgeJobPrefix + job.ID)
+	d.updateQueueSize()
+
+	d.results = append(d.results, PurgeResult{
+		ID:         job.ID,
+		Provider:   d.provider,
+		URL:        job.URL,
+		StatusCode: statusCode,
+		Attempts:   job.Attempts,
+		Success:    err == nil,
+		Error:      job.LastError,
+		Time:       time.Now(),
+	})
+	if len(d.results) > maxPurgeResults {
+		d.results = d.results[len(d.results)-maxPurgeResults:]
+	}
+}
+
+// Report returns the pending purge requests ordered by schedule and the
+// latest results.
+func (d *purgeDispatcher) Report() PurgeReport {
+	report := PurgeReport{Pending: []PurgeJob{}, Results: []PurgeResult{}}
+	if d == nil {
+		return report
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, job := range d.pending {
+		pending := *job
+		pending.Header = nil
+		pending.Body = nil
+		report.Pending = append(report.Pending, pending)
+	}
+	sort.Slice(report.Pending, func(i, j int) bool {
+		return report.Pending[i].NextAttempt.Before(report.Pending[j].NextAttempt)
+	})
+	report.Results = append(report.Results, d.results...)
+
+	return report
+}
@@ -0,0 +1,356 @@
+package providers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/darkweak/souin/configurationtypes"
+	"github.com/darkweak/souin/pkg/storage"
+	"github.com/darkweak/souin/pkg/storage/types"
+	"github.com/darkweak/storages/core"
+	"go.uber.org/zap"
+)
+
+func waitForReport(t *testing.T, d *purgeDispatcher, results int) PurgeReport {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if report := d.Report(); len(report.Results) >= results {
+			return report
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("The dispatcher didn't report %d results in time: %+v", results, d.Report())
+
+	return PurgeReport{}
+}
+
+func TestPurgeDispatcher(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "secret" {
+			t.Errorf("The request must be authorized, %v given.", r.Header)
+		}
+
+		switch r.URL.Path {
+		case "/flaky":
+			if calls.Add(1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		case "/invalid":
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("unknown tag"))
+			return
+		}
+	}))
+	defer server.Close()
+
+	memoryStorer, _ := storage.Factory(mockConfiguration(cdnConfigurationSouin))
+	authorize := func(rq *http.Request, _ []byte) {
+		rq.Header.Set("Authorization", "secret")
+	}
+	d := newPurgeDispatcher("test", configurationtypes.CDNPurge{
+		Backoff:   configurationtypes.Duration{Duration: 10 * time.Millisecond},
+		RateLimit: 100,
+	}, memoryStorer, zap.NewNop().Sugar(), authorize)
+
+	d.Enqueue(http.MethodPost, server.URL+"/flaky", nil, []byte("body"))
+	d.Enqueue(http.MethodPost, server.URL+"/invalid", nil, nil)
+
+	report := waitForReport(t, d, 2)
+	if len(report.Pending) != 0 {
+		t.Errorf("The queue must be empty, %+v given.", report.Pending)
+	}
+	for _, result := range report.Results {
+		switch {
+		case strings.HasSuffix(result.URL, "/flaky"):
+			if !result.Success || result.Attempts != 3 || result.StatusCode != http.StatusOK {
+				t.Errorf("The flaky purge must succeed on the third attempt, %+v given.", result)
+			}
+		case strings.HasSuffix(result.URL, "/invalid"):
+			if result.Success || result.Attempts != 1 || !strings.Contains(result.Error, "unknown tag") {
+				t.Errorf("The invalid purge must fail without retry with the response body, %+v given.", result)
+			}
+		}
+	}
+	if jobs := memoryStorer.MapKeys(purgeJobPrefix); len(jobs) != 0 {
+		t.Errorf("The sent purges must be removed from the storage, %v given.", jobs)
+	}
+
+	pending, _ := json.Marshal(PurgeJob{ID: "pending", Provider: "resumed", Method: http.MethodPost, URL: server.URL + "/resumed"})
+	_ = memoryStorer.Set(purgeJobPrefix+"pending", pending, time.Minute)
+	resumed := newPurgeDispatcher("resumed", configurationtypes.CDNPurge{}, memoryStorer, zap.NewNop().Sugar(), authorize)
+	if report = waitForReport(t, resumed, 1); !report.Results[0].Success || report.Results[0].ID != "pending" {
+		t.Errorf("The persisted purge must be sent on start, %+v given.", report.Results)
+	}
+}
+
+func TestPurgeDispatcherLease(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer server.Close()
+
+	memoryStorer, _ := storage.Factory(mockConfiguration(cdnConfigurationSouin))
+	store := func(job PurgeJob) {
+		job.Provider = "lease"
+		job.Method = http.MethodPost
+		job.URL = server.URL + "/" + job.ID
+		value, _ := json.Marshal(job)
+		_ = memoryStorer.Set(purgeJobPrefix+job.ID, value, time.Minute)
+	}
+	store(PurgeJob{ID: "leased", Owner: "other", LeaseUntil: time.Now().Add(time.Hour)})
+	store(PurgeJob{ID: "expired", Owner: "other", LeaseUntil: time.Now().Add(-time.Second)})
+
+	d := newPurgeDispatcher("lease", configurationtypes.CDNPurge{}, memoryStorer, zap.NewNop().Sugar(), nil)
+	defer d.Close()
+
+	report := waitForReport(t, d, 1)
+	if report.Results[0].ID != "expired" || calls.Load() != 1 {
+		t.Errorf("Only the purge with an expired lease must be sent, %+v given.", report.Results)
+	}
+	if len(report.Pending) != 1 || report.Pending[0].ID != "leased" || report.Pending[0].Owner != "other" {
+		t.Errorf("The purge leased by another instance must stay pending, %+v given.", report.Pending)
+	}
+
+	memoryStorer.Delete(purgeJobPrefix + "leased")
+	d.mu.Lock()
+	d.pending["leased"].NextAttempt = time.Now()
+	d.mu.Unlock()
+	d.notify()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(d.Report().Pending) != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if report = d.Report(); len(report.Pending) != 0 || len(report.Results) != 1 || calls.Load() != 1 {
+		t.Errorf("The purge sent by another instance must be forgotten, %+v given.", report)
+	}
+}
+
+// overwritingStorer lets another instance lease the jobs right after they
+// are written.
+type overwritingStorer struct {
+	types.Storer
+}
+
+func (s overwritingStorer) Set(key string, value []byte, duration time.Duration) error {
+	var job PurgeJob
+	if json.Unmarshal(value, &job) == nil && job.Owner != "other" {
+		job.Owner, job.LeaseUntil = "other", time.Now().Add(time.Hour)
+		value, _ = json.Marshal(job)
+	}
+
+	return s.Storer.Set(key, value, duration)
+}
+
+func TestPurgeDispatcherLeaseOverwritten(t *testing.T) {
+	memoryStorer, _ := storage.Factory(mockConfiguration(cdnConfigurationSouin))
+	d := newPurgeDispatcher("overwritten", configurationtypes.CDNPurge{}, overwritingStorer{memoryStorer}, zap.NewNop().Sugar(), nil)
+	defer d.Close()
+
+	job := &PurgeJob{ID: "overwritten", Provider: "overwritten", Method: http.MethodPost, URL: "http://127.0.0.1:0/overwritten"}
+	value, _ := json.Marshal(job)
+	_ = memoryStorer.Set(purgeJobPrefix+job.ID, value, time.Minute)
+	if d.claim(job) || time.Until(job.NextAttempt) < time.Minute {
+		t.Errorf("The job leased by another instance in the meantime must be postponed, %+v given.", job)
+	}
+}
+
+func TestPurgeDispatcherClose(t *testing.T) {
+	memoryStorer, _ := storage.Factory(mockConfiguration(cdnConfigurationSouin))
+	d := newPurgeDispatcher("close", configurationtypes.CDNPurge{}, memoryStorer, zap.NewNop().Sugar(), nil)
+	d.Close()
+
+	d.Enqueue(http.MethodPost, "http://127.0.0.1:0/closed", nil, nil)
+	time.Sleep(50 * time.Millisecond)
+
+	report := d.Report()
+	if len(report.Pending) != 1 || len(report.Results) != 0 {
+		t.Errorf("The closed dispatcher must not send the purges, %+v given.", report)
+	}
+
+	d.Close()
+	var job PurgeJob
+	for _, value := range memoryStorer.MapKeys(purgeJobPrefix) {
+		_ = json.Unmarshal([]byte(value), &job)
+	}
+	if job.URL == "" || job.Owner != "" || !job.LeaseUntil.IsZero() {
+		t.Errorf("The closed dispatcher must release the pending purges, %+v given.", job)
+	}
+}
+
+func TestEdgeGridSigner(t *testing.T) {
+	signer := newEdgeGridSigner("client-token", "client-secret", "access-token")
+	signer.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+	signer.nonce = func() string { return "nonce" }
+
+	body := []byte(`{"objects":["tag"]}`)
+	rq := httptest.NewRequest(http.MethodPost, "https://akab.example.com/ccu/v3/delete/tag?a=b", nil)
+	signer.Sign(rq, body)
+
+	prefix := "EG1-HMAC-SHA256 client_token=client-token;access_token=access-token;timestamp=20240102T03:04:05+0000;nonce=nonce;"
+	authorization := rq.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, prefix+"signature=") {
+		t.Fatalf("Unexpected Authorization header %q.", authorization)
+	}
+
+	mac := func(key, data string) string {
+		h := hmac.New(sha256.New, []byte(key))
+		h.Write([]byte(data))
+		return base64.StdEncoding.EncodeToString(h.Sum(nil))
+	}
+	bodyHash := sha256.Sum256(body)
+	expected := mac(mac("client-secret", "20240102T03:04:05+0000"), "POST\thttps\takab.example.com\t/ccu/v3/delete/tag?a=b\t\t"+base64.StdEncoding.EncodeToString(bodyHash[:])+"\t"+prefix)
+	if authorization != prefix+"signature="+expected {
+		t.Errorf("Expected the signature %s, %q given.", expected, authorization)
+	}
+}
+
+func TestProvidersPurge(t *testing.T) {
+	var mu sync.Mutex
+	received := map[string]*http.Request{}
+	bodies := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		received[r.URL.Path] = r
+		bodies[r.URL.Path] = string(body)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	memoryStorer, _ := storage.Factory(mockConfiguration(cdnConfigurationSouin))
+	core.RegisterStorage(memoryStorer)
+
+	for _, provider := range []string{"akamai", "azure", "bunny", "cloudflare", "cloudfront", "fastly", "google"} {
+		config := mockConfiguration(func() string {
+			return fmt.Sprintf(`
+default_cache:
+  cdn:
+    provider: %s
+    base_url: %s
+    api_key: key
+    service_id: service
+    zone_id: zone
+    network: staging
+    client_token: client-token
+    client_secret: client-secret
+    access_token: access-token
+    access_key_id: AKIDEXAMPLE
+    secret_access_key: secret
+    distribution_id: distribution
+    pull_zone_id: zone
+    project_id: project
+    url_map: map
+    subscription_id: subscription
+    resource_group: group
+    profile: profile
+    endpoint: endpoint
+`, provider, server.URL)
+		})
+		p := SurrogateFactory(config, types.DefaultStorageName)
+		tags := http.Header{surrogateKey: {"first" + p.getHeaderSeparator() + "second"}}
+		_ = p.Store(&http.Response{Header: tags.Clone()}, "GET-http-example.com-/first-path", "/first-path")
+		_ = p.Store(&http.Response{Header: tags.Clone()}, "GET-http-example.com-/second-path", "/second-path")
+		_, _ = p.Purge(tags)
+
+		expected := 1
+		if provider == "bunny" {
+			expected = 2
+		}
+		deadline := time.Now().Add(2 * time.Second)
+		for len(p.PurgeReport().Results) < expected && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		results := p.PurgeReport().Results
+		if len(results) != expected {
+			t.Errorf("The %s purge must be sent %d times, %+v given.", provider, expected, results)
+		}
+		for _, result := range results {
+			if !result.Success {
+				t.Errorf("The %s purge must succeed, %+v given.", provider, result)
+			}
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if rq := received["/ccu/v3/delete/tag/staging"]; rq == nil || !strings.HasPrefix(rq.Header.Get("Authorization"), "EG1-HMAC-SHA256 ") || bodies["/ccu/v3/delete/tag/staging"] != `{"objects":["first","second"]}` {
+		t.Errorf("Unexpected Akamai purge %+v with body %s.", rq, bodies["/ccu/v3/delete/tag/staging"])
+	}
+	if rq := received["/zones/zone/purge_cache"]; rq == nil || rq.Header.Get("Authorization") != "Bearer key" || bodies["/zones/zone/purge_cache"] != `{"tags":["first","second"]}` {
+		t.Errorf("Unexpected Cloudflare purge %+v with body %s.", rq, bodies["/zones/zone/purge_cache"])
+	}
+	if rq := received["/service/service/purge"]; rq == nil || rq.Header.Get("Fastly-Key") != "key" || rq.Header.Get("Surrogate-Key") != "first second" {
+		t.Errorf("Unexpected Fastly purge %+v.", rq)
+	}
+	azurePath := "/subscriptions/subscription/resourceGroups/group/providers/Microsoft.Cdn/profiles/profile/afdEndpoints/endpoint/purge"
+	if rq := received[azurePath]; rq == nil || rq.Header.Get("Authorization") != "Bearer key" || rq.URL.Query().Get("api-version") != azureAPIVersion || bodies[azurePath] != `{"contentPaths":["/first-path","/second-path"]}` {
+		t.Errorf("Unexpected Azure purge %+v with body %s.", rq, bodies[azurePath])
+	}
+	if rq := received["/pullzone/zone/purgeCache"]; rq == nil || rq.Header.Get("AccessKey") != "key" || (bodies["/pullzone/zone/purgeCache"] != `{"CacheTag":"first"}` && bodies["/pullzone/zone/purgeCache"] != `{"CacheTag":"second"}`) {
+		t.Errorf("Unexpected Bunny purge %+v with body %s.", rq, bodies["/pullzone/zone/purgeCache"])
+	}
+	cloudfrontPath := "/2020-05-31/distribution/distribution/invalidation"
+	if rq := received[cloudfrontPath]; rq == nil ||
+		!strings.HasPrefix(rq.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") ||
+		!strings.Contains(bodies[cloudfrontPath], "<Paths><Quantity>2</Quantity><Items><Path>/first-path</Path><Path>/second-path</Path></Items></Paths>") {
+		t.Errorf("Unexpected CloudFront purge %+v with body %s.", rq, bodies[cloudfrontPath])
+	}
+	googlePath := "/projects/project/global/urlMaps/map/invalidateCache"
+	if rq := received[googlePath]; rq == nil || rq.Header.Get("Authorization") != "Bearer key" || bodies[googlePath] != `{"cacheTags":["first","second"]}` {
+		t.Errorf("Unexpected Google purge %+v with body %s.", rq, bodies[googlePath])
+	}
+}
+
+func TestProvidersStore(t *testing.T) {
+	memoryStorer, _ := storage.Factory(mockConfiguration(cdnConfigurationSouin))
+	core.RegisterStorage(memoryStorer)
+
+	for provider, header := range map[string]string{"bunny": bunnyCDNTag, "cloudfront": "", "google": cacheTag, "azure": ""} {
+		p := SurrogateFactory(mockConfiguration(func() string {
+			return "\ndefault_cache:\n  cdn:\n    provider: " + provider + "\n"
+		}), types.DefaultStorageName)
+		res := &http.Response{Header: http.Header{surrogateKey: {"first, second"}, surrogateControl: {"max-age=10"}}}
+		_ = p.Store(res, "key", "/path")
+
+		if res.Header.Get(surrogateKey) != "" || res.Header.Get(surrogateControl) != "" {
+			t.Errorf("The %s provider must remove the surrogate headers, %v given.", provider, res.Header)
+		}
+		if header != "" && res.Header.Get(header) != "first,second" {
+			t.Errorf("The %s provider must set the %s header, %v given.", provider, header, res.Header)
+		}
+	}
+}
+
+func TestSigV4Signer(t *testing.T) {
+	signer := newSigV4Signer("AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "", "us-east-1", "iam")
+	signer.now = func() time.Time { return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC) }
+
+	rq := httptest.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	rq.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signer.Sign(rq, nil)
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if authorization := rq.Header.Get("Authorization"); authorization != expected {
+		t.Errorf("Expected the Authorization header %q, %q given.", expected, authorization)
+	}
+	if rq.Header.Get("X-Amz-Date") != "20150830T123600Z" {
+		t.Errorf("Unexpected X-Amz-Date header %q.", rq.Header.Get("X-Amz-Date"))
+	}
+}
@@ -0,0 +1,77 @@
+package providers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	edgeGridAlgorithm       = "EG1-HMAC-SHA256"
+	edgeGridTimestampFormat = "20060102T15:04:05-0700"
+	edgeGridMaxBody         = 131072
+)
+
+// edgeGridSigner signs the Akamai API requests with the EdgeGrid
+// authentication scheme.
+type edgeGridSigner struct {
+	clientToken  string
+	clientSecret string
+	accessToken  string
+	now          func() time.Time
+	nonce        func() string
+}
+
+func newEdgeGridSigner(clientToken, clientSecret, accessToken string) *edgeGridSigner {
+	return &edgeGridSigner{
+		clientToken:  clientToken,
+		clientSecret: clientSecret,
+		accessToken:  accessToken,
+		now:          time.Now,
+		nonce:        uuid.NewString,
+	}
+}
+
+func edgeGridHMAC(key, data string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	_, _ = mac.Write([]byte(data))
+
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Sign sets the Authorization header of the request with the given body.
+func (e *edgeGridSigner) Sign(rq *http.Request, body []byte) {
+	timestamp := e.now().UTC().Format(edgeGridTimestampFormat)
+	authorization := fmt.Sprintf(
+		"%s client_token=%s;access_token=%s;timestamp=%s;nonce=%s;",
+		edgeGridAlgorithm, e.clientToken, e.accessToken, timestamp, e.nonce(),
+	)
+
+	contentHash := ""
+	if rq.Method == http.MethodPost && len(body) > 0 {
+		if len(body) > edgeGridMaxBody {
+			body = body[:edgeGridMaxBody]
+		}
+		sum := sha256.Sum256(body)
+		contentHash = base64.StdEncoding.EncodeToString(sum[:])
+	}
+
+	data := strings.Join([]string{
+		rq.Method,
+		rq.URL.Scheme,
+		rq.URL.Host,
+		rq.URL.RequestURI(),
+		"",
+		contentHash,
+		authorization,
+	}, "\t")
+	signature := edgeGridHMAC(edgeGridHMAC(e.clientSecret, timestamp), data)
+
+	rq.Header.Set("Authorization", authorization+"signature="+signature)
+}
@@ -11,10 +11,22 @@ func SurrogateFactory(config configurationtypes.AbstractConfigurationInterface,
 	switch cdn.Provider {
 	case "akamai":
 		return generateAkamaiInstance(config, defaultStorerName)
+	case "azure":
+		return generateAzureInstance(config, defaultStorerName)
+	case "bunny":
+		return generateBunnyInstance(config, defaultStorerName)
 	case "cloudflare":
 		return generateCloudflareInstance(config, defaultStorerName)
+	case "cloudfront":
+		return generateCloudfrontInstance(config, defaultStorerName)
 	case "fastly":
 		return generateFastlyInstance(config, defaultStorerName)
+	case "google":
+		return generateGoogleInstance(config, defaultStorerName)
+	case "varnish":
+		return generateWebhookInstance(config, defaultStorerName, true)
+	case "webhook":
+		return generateWebhookInstance(config, defaultStorerName, false)
 	default:
 		return generateSouinInstance(config, defaultStorerName)
 	}
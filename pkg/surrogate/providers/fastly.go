@@ -2,17 +2,23 @@ package providers
 
 import (
 	"net/http"
-	"net/url"
+	"strings"
 
 	"github.com/darkweak/souin/configurationtypes"
 )
 
+const (
+	fastlyBaseURL         = "https://api.fastly.com"
+	fastlyMaxKeysPerPurge = 256
+)
+
 // FastlySurrogateStorage is the layer for Surrogate-key support storage
 type FastlySurrogateStorage struct {
 	*baseStorage
 	providerAPIKey string
 	serviceID      string
 	strategy       string
+	url            string
 }
 
 func generateFastlyInstance(config configurationtypes.AbstractConfigurationInterface, defaultStorerName string) *FastlySurrogateStorage {
@@ -22,14 +28,22 @@ func generateFastlyInstance(config configurationtypes.AbstractConfigurationInter
 		providerAPIKey: cdn.APIKey,
 		serviceID:      cdn.ServiceID,
 		strategy:       "0",
+		url:            fastlyBaseURL,
 	}
 
 	if cdn.Strategy == "soft" {
 		f.strategy = "1"
 	}
+	if cdn.BaseURL != "" {
+		f.url = cdn.BaseURL
+	}
+	f.url += "/service/" + f.serviceID + "/purge"
 
 	f.init(config, defaultStorerName)
 	f.parent = f
+	f.dispatcher = newPurgeDispatcher("fastly", cdn.Purge, f.Storage, f.logger, func(rq *http.Request, _ []byte) {
+		rq.Header.Set("Fastly-Key", f.providerAPIKey)
+	})
 
 	return f
 }
@@ -54,24 +68,17 @@ func (*FastlySurrogateStorage) getOrderedSurrogateControlHeadersCandidate() []st
 	}
 }
 
-// Purge purges the urls associated to the tags
-func (f *FastlySurrogateStorage) Purge(header http.Header) (cacheKeys []string, surrogateKeys []string) {
-	keys, headers := f.baseStorage.Purge(header)
-	req, err := http.NewRequest(http.MethodPost, "https://api.fastly.com/service/"+f.serviceID+"/purge", nil)
-	if err == nil {
-		req.Header.Set("Fastly-Soft-Purge", f.strategy)
-		req.Header.Set("Fastly-Key", f.providerAPIKey)
-		req.Header.Set("Accept", "application/json")
-
-		go func() {
-			for _, h := range headers {
-				computedURL := "/service/" + f.serviceID + "/purge/" + h
-				req.RequestURI = computedURL
-				if req.URL, err = url.Parse(computedURL); err == nil {
-					_, _ = new(http.Client).Do(req)
-				}
-			}
-		}()
+// PurgeMatched purges the urls associated to the matched tags
+func (f *FastlySurrogateStorage) PurgeMatched(matched []string) (cacheKeys []string, surrogateKeys []string) {
+	keys, headers := f.baseStorage.PurgeMatched(matched)
+	tags := NonEmpty(headers)
+	for i := 0; i < len(tags); i += fastlyMaxKeysPerPurge {
+		j := min(i+fastlyMaxKeysPerPurge, len(tags))
+		f.dispatcher.Enqueue(http.MethodPost, f.url, http.Header{
+			"Accept":            {"application/json"},
+			"Fastly-Soft-Purge": {f.strategy},
+			"Surrogate-Key":     {strings.Join(tags[i:j], " ")},
+		}, nil)
 	}
 
 	return keys, headers
@@ -0,0 +1,131 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/darkweak/souin/configurationtypes"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	googleBaseURL = "https://compute.googleapis.com/compute/v1"
+	googleScope   = "https://www.googleapis.com/auth/compute"
+)
+
+// GoogleSurrogateStorage is the layer for Surrogate-key support storage
+type GoogleSurrogateStorage struct {
+	*baseStorage
+	tokenSource oauth2.TokenSource
+	url         string
+}
+
+// googleTokenSource returns the access tokens sent to the Compute API. The
+// service account of the credentials file, or the application default
+// credentials without api key, are exchanged for tokens refreshed before
+// they expire. The api key is sent as is, so a short lived token given there
+// must be rotated by an external process along with the configuration.
+func googleTokenSource(cdn configurationtypes.CDN) (oauth2.TokenSource, error) {
+	if cdn.CredentialsFile != "" {
+		data, err := os.ReadFile(cdn.CredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		credentials, err := google.CredentialsFromJSON(context.Background(), data, googleScope)
+		if err != nil {
+			return nil, err
+		}
+
+		return credentials.TokenSource, nil
+	}
+	if cdn.APIKey != "" {
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: cdn.APIKey}), nil
+	}
+
+	credentials, err := google.FindDefaultCredentials(context.Background(), googleScope)
+	if err != nil {
+		return nil, err
+	}
+
+	return credentials.TokenSource, nil
+}
+
+func generateGoogleInstance(config configurationtypes.AbstractConfigurationInterface, defaultStorerName string) *GoogleSurrogateStorage {
+	cdn := config.GetDefaultCache().GetCDN()
+	g := &GoogleSurrogateStorage{
+		baseStorage: &baseStorage{},
+		url:         googleBaseURL,
+	}
+	if cdn.BaseURL != "" {
+		g.url = cdn.BaseURL
+	}
+	g.url += "/projects/" + cdn.ProjectID + "/global/urlMaps/" + cdn.URLMap + "/invalidateCache"
+
+	g.init(config, defaultStorerName)
+	g.parent = g
+
+	tokenSource, err := googleTokenSource(cdn)
+	if err != nil {
+		g.logger.Errorf("Impossible to load the Google credentials, the purge requests won't be authorized: %v", err)
+	} else {
+		g.tokenSource = oauth2.ReuseTokenSource(nil, tokenSource)
+	}
+	g.dispatcher = newPurgeDispatcher("google", cdn.Purge, g.Storage, g.logger, func(rq *http.Request, _ []byte) {
+		if g.tokenSource == nil {
+			return
+		}
+		token, err := g.tokenSource.Token()
+		if err != nil {
+			g.logger.Errorf("Impossible to get a Google access token: %v", err)
+			return
+		}
+		token.SetAuthHeader(rq)
+	})
+
+	return g
+}
+
+func (*GoogleSurrogateStorage) getHeaderSeparator() string {
+	return ","
+}
+
+func (*GoogleSurrogateStorage) getOrderedSurrogateKeyHeadersCandidate() []string {
+	return []string{
+		cacheTag,
+		surrogateKey,
+	}
+}
+
+// Store stores the response tags located in the first non empty supported header
+func (g *GoogleSurrogateStorage) Store(response *http.Response, cacheKey, uri string) error {
+	defer func() {
+		response.Header.Del(surrogateKey)
+		response.Header.Del(surrogateControl)
+	}()
+	e := g.baseStorage.Store(response, cacheKey, uri)
+	response.Header.Set(cacheTag, strings.Join(g.ParseHeaders(g.getSurrogateKey(response.Header)), g.getHeaderSeparator()))
+
+	return e
+}
+
+// PurgeMatched purges the urls associated to the matched tags
+func (g *GoogleSurrogateStorage) PurgeMatched(matched []string) (cacheKeys []string, surrogateKeys []string) {
+	keys, headers := g.baseStorage.PurgeMatched(matched)
+	tags := NonEmpty(headers)
+	if len(tags) == 0 {
+		return keys, headers
+	}
+
+	body, err := json.Marshal(map[string][]string{"cacheTags": tags})
+	if err == nil {
+		g.dispatcher.Enqueue(http.MethodPost, g.url, http.Header{
+			"Content-Type": {"application/json"},
+		}, body)
+	}
+
+	return keys, headers
+}
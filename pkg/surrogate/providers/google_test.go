@@ -0,0 +1,61 @@
+package providers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/darkweak/souin/configurationtypes"
+)
+
+func TestGoogleTokenSource(t *testing.T) {
+	var issued atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("assertion") == "" {
+			t.Errorf("The service account must exchange a signed assertion, %v given.", r.PostForm)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":1}`, issued.Add(1))
+	}))
+	defer server.Close()
+
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	credentials, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "souin@project.iam.gserviceaccount.com",
+		"private_key_id": "key",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+		"token_uri":      server.URL,
+	})
+	credentialsFile := filepath.Join(t.TempDir(), "credentials.json")
+	_ = os.WriteFile(credentialsFile, credentials, 0o600)
+
+	ts, err := googleTokenSource(configurationtypes.CDN{APIKey: "ignored", CredentialsFile: credentialsFile})
+	if err != nil {
+		t.Fatalf("The credentials file must be loaded, %v given.", err)
+	}
+	for _, expected := range []string{"token-1", "token-2"} {
+		token, err := ts.Token()
+		if err != nil || token.AccessToken != expected {
+			t.Errorf("The expired token must be refreshed to %s, %+v given with %v.", expected, token, err)
+		}
+	}
+
+	ts, _ = googleTokenSource(configurationtypes.CDN{APIKey: "key"})
+	if token, _ := ts.Token(); token.AccessToken != "key" || token.Type() != "Bearer" {
+		t.Errorf("The api key must be sent as a bearer token, %+v given.", token)
+	}
+
+	if _, err = googleTokenSource(configurationtypes.CDN{CredentialsFile: filepath.Join(t.TempDir(), "missing.json")}); err == nil {
+		t.Error("A missing credentials file must be reported.")
+	}
+}
@@ -0,0 +1,571 @@
+package providers
+
+import (
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/darkweak/souin/pkg/rfc"
+	"github.com/darkweak/storages/core"
+	"github.com/google/uuid"
+)
+
+// The surrogate index keeps sets of members, such as the cache keys of a
+// tag, read with a few Get instead of a scan of the storer. A set is stored
+// as append-only segments holding at most maxSegmentEntries
+// "{escaped member} {unix expiry}" entries separated by commas, the expiry is
+// 0 when unknown. The {prefix}{escaped name} entry lists the segments of the
+// set and each segment {prefix}{escaped name}|{writer}.{n} is written by a
+// single instance, which appends to its last segment and opens the next one
+// once full, so the instances sharing a storer don't overwrite the entries
+// of each other. The segments list is the only shared value, an instance
+// adds its segment back on its next write when a concurrent update lost it
+// and the compaction lists the orphan segments again. The purges and the
+// compaction of the idle instances segments may still drop an entry appended
+// at the same time, it's then purged along with the next one.
+//
+// The index stores the cache keys of each tag under SURROGATE-IDX_, and the
+// escaped request paths of each tag under SURROGATE-PATH_ for the providers
+// purging by path. The tags are listed by their first tagGroupLength
+// characters under SURROGATE-TAGS_, the root set listing these groups, to
+// resolve the patterns. The tags stored as comma separated string under the
+// SURROGATE_ prefix by the previous versions are still read and are migrated
+// to the index by the compaction.
+const (
+	surrogateIndexPrefix      = "SURROGATE-IDX_"
+	surrogatePathPrefix       = "SURROGATE-PATH_"
+	surrogateTagsPrefix       = "SURROGATE-TAGS_"
+	surrogateWriterPrefix     = "SURROGATE-WRITER_"
+	surrogateIndexSeparator   = "|"
+	surrogateEntrySeparator   = ","
+	surrogateSegmentSeparator = "."
+
+	maxSegmentEntries = 256
+	tagGroupLength    = 3
+	indexLockStripes  = 64
+	writerHeartbeat   = time.Minute
+	writerIdleTimeout = 10 * time.Minute
+
+	defaultCompactionInterval = 10 * time.Minute
+)
+
+var indexSetPrefixes = []string{surrogateIndexPrefix, surrogatePathPrefix, surrogateDependencyPrefix, surrogateReversePrefix, surrogateTagsPrefix}
+
+type indexEntry struct {
+	member string
+	expiry int64
+}
+
+func indexSetKey(prefix string, name string) string {
+	return prefix + url.QueryEscape(name)
+}
+
+func tagGroup(tag string) string {
+	if len(tag) > tagGroupLength {
+		return tag[:tagGroupLength]
+	}
+
+	return tag
+}
+
+func parseSegments(value []byte) []string {
+	segments := []string{}
+	for _, segment := range strings.Split(string(value), surrogateEntrySeparator) {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+
+	return segments
+}
+
+func parseEntries(value []byte) []indexEntry {
+	entries := []indexEntry{}
+	for _, raw := range strings.Split(string(value), surrogateEntrySeparator) {
+		escaped, expiry, found := strings.Cut(raw, " ")
+		if !found {
+			continue
+		}
+		member, err := url.QueryUnescape(escaped)
+		if err != nil {
+			continue
+		}
+		value, _ := strconv.ParseInt(expiry, 10, 64)
+		entries = append(entries, indexEntry{member: member, expiry: value})
+	}
+
+	return entries
+}
+
+func formatEntries(entries []indexEntry) []byte {
+	raw := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		raw = append(raw, url.QueryEscape(entry.member)+" "+strconv.FormatInt(entry.expiry, 10))
+	}
+
+	return []byte(strings.Join(raw, surrogateEntrySeparator))
+}
+
+// laterExpiry returns the latest of the expiries, 0 being unknown so never
+// expiring.
+func laterExpiry(a, b int64) int64 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+
+	return max(a, b)
+}
+
+func expiryValue(expiry time.Time) int64 {
+	if expiry.IsZero() {
+		return 0
+	}
+
+	return expiry.Unix()
+}
+
+func (s *baseStorage) lockSet(setKey string) func() {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(setKey))
+	mu := &s.indexLocks[h.Sum32()%indexLockStripes]
+	mu.Lock()
+
+	return mu.Unlock
+}
+
+// writerID returns the identifier of the segments written by this instance
+// and records its activity so the others don't compact them meanwhile.
+func (s *baseStorage) writerID() string {
+	s.writerMu.Lock()
+	defer s.writerMu.Unlock()
+
+	if s.writer == "" {
+		s.writer = uuid.NewString()
+	}
+	if now := time.Now(); now.Sub(s.heartbeat) >= writerHeartbeat {
+		s.heartbeat = now
+		_ = s.Storage.Set(surrogateWriterPrefix+s.writer, []byte(strconv.FormatInt(now.Unix(), 10)), s.duration)
+	}
+
+	return s.writer
+}
+
+// idleWriter returns true when the writer of the segments didn't write for
+// a while, its segments can then be compacted by the other instances.
+func (s *baseStorage) idleWriter(writer string) bool {
+	last, err := strconv.ParseInt(string(s.Storage.Get(surrogateWriterPrefix+writer)), 10, 64)
+
+	return err != nil || time.Since(time.Unix(last, 0)) > writerIdleTimeout
+}
+
+func (s *baseStorage) setSegments(setKey string, segments []string) {
+	if len(segments) == 0 {
+		s.Storage.Delete(setKey)
+
+		return
+	}
+
+	_ = s.Storage.Set(setKey, []byte(strings.Join(segments, surrogateEntrySeparator)), s.duration)
+}
+
+// writeSegment stores the entries until the latest expiry and returns false
+// when they are all expired.
+func (s *baseStorage) writeSegment(segmentKey string, entries []indexEntry) bool {
+	if len(entries) == 0 {
+		s.Storage.Delete(segmentKey)
+
+		return false
+	}
+
+	ttl := s.duration
+	if s.duration > 0 {
+		latest := entries[0].expiry
+		for _, entry := range entries[1:] {
+			latest = laterExpiry(latest, entry.expiry)
+		}
+		if latest > 0 {
+			if ttl = time.Until(time.Unix(latest, 0)); ttl <= 0 {
+				s.Storage.Delete(segmentKey)
+
+				return false
+			}
+		}
+	}
+
+	_ = s.Storage.Set(segmentKey, formatEntries(entries), ttl)
+
+	return true
+}
+
+// addMember appends the member to the last segment of this instance, or
+// updates its expiry when it's already there.
+func (s *baseStorage) addMember(setKey string, member string, expiry time.Time) {
+	value := expiryValue(expiry)
+	if value > 0 && value < time.Now().Unix() {
+		return
+	}
+
+	writer := s.writerID()
+	defer s.lockSet(setKey)()
+
+	segments := parseSegments(s.Storage.Get(setKey))
+	last := -1
+	for _, segment := range segments {
+		owner, index, _ := strings.Cut(segment, surrogateSegmentSeparator)
+		if n, err := strconv.Atoi(index); err == nil && owner == writer && n > last {
+			last = n
+		}
+	}
+	if last < 0 {
+		last = 0
+	}
+
+	segment := writer + surrogateSegmentSeparator + strconv.Itoa(last)
+	entries := parseEntries(s.Storage.Get(setKey + surrogateIndexSeparator + segment))
+	found := false
+	for i := range entries {
+		if entries[i].member == member {
+			if entries[i].expiry == laterExpiry(entries[i].expiry, value) && slices.Contains(segments, segment) {
+				return
+			}
+			entries[i].expiry = laterExpiry(entries[i].expiry, value)
+			found = true
+
+			break
+		}
+	}
+	if !found {
+		if len(entries) >= maxSegmentEntries {
+			segment = writer + surrogateSegmentSeparator + strconv.Itoa(last+1)
+			entries = entries[:0]
+		}
+		entries = append(entries, indexEntry{member: member, expiry: value})
+	}
+
+	if s.writeSegment(setKey+surrogateIndexSeparator+segment, entries) && !slices.Contains(segments, segment) {
+		s.setSegments(setKey, append(segments, segment))
+	}
+}
+
+// members returns the unexpired members of the set with their expiry.
+func (s *baseStorage) members(setKey string) map[string]int64 {
+	now := time.Now().Unix()
+	members := map[string]int64{}
+	for _, segment := range parseSegments(s.Storage.Get(setKey)) {
+		for _, entry := range parseEntries(s.Storage.Get(setKey + surrogateIndexSeparator + segment)) {
+			if entry.expiry > 0 && entry.expiry < now {
+				continue
+			}
+			if previous, ok := members[entry.member]; ok {
+				entry.expiry = laterExpiry(previous, entry.expiry)
+			}
+			members[entry.member] = entry.expiry
+		}
+	}
+
+	return members
+}
+
+// memberList returns the sorted unexpired members of the set.
+func (s *baseStorage) memberList(setKey string) []string {
+	members := s.members(setKey)
+	list := make([]string, 0, len(members))
+	for member := range members {
+		list = append(list, member)
+	}
+	sort.Strings(list)
+
+	return list
+}
+
+// removeSet deletes the set with its segments.
+func (s *baseStorage) removeSet(setKey string) {
+	defer s.lockSet(setKey)()
+
+	for _, segment := range parseSegments(s.Storage.Get(setKey)) {
+		s.Storage.Delete(setKey + surrogateIndexSeparator + segment)
+	}
+	s.Storage.Delete(setKey)
+}
+
+// removeMember rewrites the segments of the set containing the member.
+func (s *baseStorage) removeMember(setKey string, member string) {
+	defer s.lockSet(setKey)()
+
+	segments := parseSegments(s.Storage.Get(setKey))
+	kept := make([]string, 0, len(segments))
+	for _, segment := range segments {
+		segmentKey := setKey + surrogateIndexSeparator + segment
+		entries := parseEntries(s.Storage.Get(segmentKey))
+		filtered := slices.DeleteFunc(slices.Clone(entries), func(entry indexEntry) bool {
+			return entry.member == member
+		})
+		if len(filtered) == len(entries) {
+			kept = append(kept, segment)
+
+			continue
+		}
+		if s.writeSegment(segmentKey, filtered) {
+			kept = append(kept, segment)
+		}
+	}
+	if len(kept) != len(segments) {
+		s.setSegments(setKey, kept)
+	}
+}
+
+// entryExpiry returns the instant the stored response expires including the
+// stale duration, or the zero time when it's unknown.
+func (s *baseStorage) entryExpiry(response *http.Response) time.Time {
+	ttl, err := time.ParseDuration(response.Header.Get(rfc.StoredTTLHeader))
+	if err != nil {
+		return time.Time{}
+	}
+
+	date, err := http.ParseTime(response.Header.Get("Date"))
+	if err != nil {
+		date = time.Now()
+	}
+
+	return date.Add(ttl + s.stale)
+}
+
+// storeTag indexes the cache key under the tag and lists the tag in its
+// group for the patterns.
+func (s *baseStorage) storeTag(tag string, cacheKey string, expiry time.Time) {
+	s.logger.Debugf("Store the tag %s", tag)
+	s.addMember(indexSetKey(surrogateIndexPrefix, tag), cacheKey, expiry)
+	s.addMember(indexSetKey(surrogateTagsPrefix, tagGroup(tag)), tag, expiry)
+	s.addMember(indexSetKey(surrogateTagsPrefix, ""), tagGroup(tag), expiry)
+}
+
+// storeKey indexes the cache key under the tag and the request path, the tag
+// under the cache key, and the request path itself when the provider purges
+// by path.
+func (s *baseStorage) storeKey(tag string, cacheKey string, uri string, expiry time.Time) {
+	s.storeTag(tag, cacheKey, expiry)
+	s.storeReverse(tag, cacheKey, expiry)
+	s.storeTag(uri, cacheKey, expiry)
+	if s.indexPaths {
+		s.storePath(tag, uri, expiry)
+	}
+}
+
+// storePath indexes the request path of a tagged response for the providers
+// purging by path.
+func (s *baseStorage) storePath(tag string, path string, expiry time.Time) {
+	s.addMember(indexSetKey(surrogatePathPrefix, tag), url.QueryEscape(path), expiry)
+}
+
+// purgePaths returns the unique request paths indexed for the tags and
+// removes them from the index unless the stale responses are kept.
+func (s *baseStorage) purgePaths(tags []string) []string {
+	seen := map[string]bool{}
+	paths := []string{}
+	for _, tag := range tags {
+		setKey := indexSetKey(surrogatePathPrefix, tag)
+		for _, escaped := range s.memberList(setKey) {
+			path, err := url.QueryUnescape(escaped)
+			if err != nil || seen[path] {
+				continue
+			}
+			seen[path] = true
+			paths = append(paths, path)
+		}
+		if !s.keepStale {
+			s.removeSet(setKey)
+		}
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+// tagKeys returns the cache keys indexed for the tag and the ones stored in
+// the legacy format.
+func (s *baseStorage) tagKeys(tag string) (indexed []string, legacy []string) {
+	indexed = s.memberList(indexSetKey(surrogateIndexPrefix, tag))
+
+	if value := string(s.Storage.Get(surrogatePrefix + tag)); value != "" {
+		legacy = strings.Split(value, souinStorageSeparator)
+	}
+
+	return indexed, legacy
+}
+
+func (s *baseStorage) purgeTag(tag string) []string {
+	indexed, legacy := s.tagKeys(tag)
+	s.logger.Debugf("Purge the tag %s", tag)
+	if !s.keepStale {
+		s.removeSet(indexSetKey(surrogateIndexPrefix, tag))
+		s.removeMember(indexSetKey(surrogateTagsPrefix, tagGroup(tag)), tag)
+		for _, key := range indexed {
+			s.deleteReverse(tag, key)
+		}
+		s.Storage.Delete(surrogatePrefix + tag)
+	}
+
+	return append(indexed, legacy...)
+}
+
+// List returns the stored keys associated to resources
+func (s *baseStorage) List() map[string]string {
+	list := s.Storage.MapKeys(surrogatePrefix)
+	for _, group := range s.memberList(indexSetKey(surrogateTagsPrefix, "")) {
+		for _, tag := range s.memberList(indexSetKey(surrogateTagsPrefix, group)) {
+			keys := s.memberList(indexSetKey(surrogateIndexPrefix, tag))
+			if len(keys) == 0 {
+				continue
+			}
+
+			if list[tag] == "" {
+				list[tag] = strings.Join(keys, souinStorageSeparator)
+			} else {
+				list[tag] += souinStorageSeparator + strings.Join(keys, souinStorageSeparator)
+			}
+		}
+	}
+
+	return list
+}
+
+// compactSet drops the expired entries of the set segments written by this
+// instance or an idle one and, when the surrogate storer is the cache one,
+// the entries whose response no longer exists. It lists the orphan segments
+// again and forgets the vanished ones.
+func (s *baseStorage) compactSet(prefix string, setKey string, orphans []string) int {
+	defer s.lockSet(setKey)()
+
+	now := time.Now().Unix()
+	dropped := 0
+	segments := parseSegments(s.Storage.Get(setKey))
+	for _, orphan := range orphans {
+		if !slices.Contains(segments, orphan) {
+			segments = append(segments, orphan)
+		}
+	}
+
+	// The stored responses are reached through the mapping of their cache
+	// key, the entry is dropped once the mapping is gone.
+	missing := func(escapedKey string) bool {
+		cacheKey, err := url.QueryUnescape(escapedKey)
+
+		return err == nil && len(s.Storage.Get(core.MappingKeyPrefix+cacheKey)) == 0
+	}
+	name, _ := url.QueryUnescape(strings.TrimPrefix(setKey, prefix))
+	removed := prefix == surrogateReversePrefix && s.checkExistence && missing(name)
+
+	kept := make([]string, 0, len(segments))
+	for _, segment := range segments {
+		segmentKey := setKey + surrogateIndexSeparator + segment
+		entries := parseEntries(s.Storage.Get(segmentKey))
+		if len(entries) == 0 {
+			continue
+		}
+
+		writer, _, _ := strings.Cut(segment, surrogateSegmentSeparator)
+		if writer != s.writerID() && !s.idleWriter(writer) {
+			kept = append(kept, segment)
+
+			continue
+		}
+
+		filtered := slices.DeleteFunc(slices.Clone(entries), func(entry indexEntry) bool {
+			return removed || (entry.expiry > 0 && entry.expiry < now) ||
+				(prefix == surrogateIndexPrefix && s.checkExistence && missing(entry.member))
+		})
+		dropped += len(entries) - len(filtered)
+		if len(filtered) == len(entries) || s.writeSegment(segmentKey, filtered) {
+			kept = append(kept, segment)
+		}
+	}
+	if !slices.Equal(kept, segments) || len(orphans) > 0 {
+		s.setSegments(setKey, kept)
+	}
+
+	return dropped
+}
+
+// Compact migrates the tags stored in the legacy format to the index and
+// drops the entries whose response expired or, when the surrogate storer is
+// the cache one, no longer exists. It scans the storer, unlike the lookups
+// and the purges.
+func (s *baseStorage) Compact() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for tag, value := range s.Storage.MapKeys(surrogatePrefix) {
+		for _, key := range strings.Split(value, souinStorageSeparator) {
+			if key != "" {
+				s.storeTag(tag, key, time.Time{})
+				s.storeReverse(tag, key, time.Time{})
+			}
+		}
+		s.Storage.Delete(surrogatePrefix + tag)
+	}
+
+	dropped := 0
+	for _, prefix := range indexSetPrefixes {
+		sets := map[string][]string{}
+		for entry := range s.Storage.MapKeys(prefix) {
+			name, segment, found := strings.Cut(entry, surrogateIndexSeparator)
+			if !found {
+				if _, ok := sets[name]; !ok {
+					sets[name] = []string{}
+				}
+
+				continue
+			}
+			sets[name] = append(sets[name], segment)
+		}
+
+		for name, segments := range sets {
+			listed := parseSegments(s.Storage.Get(prefix + name))
+			orphans := slices.DeleteFunc(segments, func(segment string) bool {
+				return slices.Contains(listed, segment)
+			})
+			dropped += s.compactSet(prefix, prefix+name, orphans)
+		}
+	}
+
+	now := time.Now().Unix()
+	for escapedKey := range s.Storage.MapKeys(surrogateSizePrefix) {
+		if _, expiry := s.size(escapedKey); expiry > 0 && expiry < now {
+			s.Storage.Delete(surrogateSizePrefix + escapedKey)
+			dropped++
+
+			continue
+		}
+		if s.checkExistence {
+			if cacheKey, err := url.QueryUnescape(escapedKey); err == nil && len(s.Storage.Get(core.MappingKeyPrefix+cacheKey)) == 0 {
+				s.Storage.Delete(surrogateSizePrefix + escapedKey)
+				dropped++
+			}
+		}
+	}
+
+	s.logger.Debugf("Compacted the surrogate index, %d entries dropped", dropped)
+}
+
+// compactEvery migrates the legacy tags on start then compacts the index at
+// each interval until stop is closed.
+func (s *baseStorage) compactEvery(interval time.Duration, stop <-chan struct{}) {
+	s.Compact()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.Compact()
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package providers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/darkweak/souin/pkg/rfc"
+)
+
+// The reverse index stores the tags of each cache key as a set of the
+// surrogate index under SURROGATE-KEY_, and the body size of the response as
+// SURROGATE-SIZE_{escaped cache key} with the "{size} {expiry}" value.
+const (
+	surrogateReversePrefix = "SURROGATE-KEY_"
+	surrogateSizePrefix    = "SURROGATE-SIZE_"
+)
+
+// TagStats reports the number of entries tagged and their approximate size.
+type TagStats struct {
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+}
+
+func (s *baseStorage) storeReverse(tag string, escapedKey string, expiry time.Time) {
+	if tag != "" {
+		s.addMember(indexSetKey(surrogateReversePrefix, escapedKey), tag, expiry)
+	}
+}
+
+// storeSize records the stored body size of the response, 0 when unknown,
+// overwriting the previous one of the cache key.
+func (s *baseStorage) storeSize(response *http.Response, escapedKey string, expiry time.Time) {
+	size, err := strconv.ParseInt(response.Header.Get(rfc.StoredLengthHeader), 10, 64)
+	if err != nil {
+		size, _ = strconv.ParseInt(response.Header.Get("Content-Length"), 10, 64)
+	}
+
+	ttl := s.duration
+	if !expiry.IsZero() && s.duration > 0 {
+		if ttl = time.Until(expiry); ttl <= 0 {
+			return
+		}
+	}
+
+	_ = s.Storage.Set(surrogateSizePrefix+escapedKey, []byte(strconv.FormatInt(size, 10)+" "+strconv.FormatInt(expiryValue(expiry), 10)), ttl)
+}
+
+// size returns the stored body size of the cache key and its expiry, 0
+// when it never expires.
+func (s *baseStorage) size(escapedKey string) (size int64, expiry int64) {
+	value, rawExpiry, _ := strings.Cut(string(s.Storage.Get(surrogateSizePrefix+escapedKey)), " ")
+	size, _ = strconv.ParseInt(value, 10, 64)
+	expiry, _ = strconv.ParseInt(rawExpiry, 10, 64)
+
+	return size, expiry
+}
+
+func (s *baseStorage) deleteReverse(tag string, escapedKey string) {
+	s.removeMember(indexSetKey(surrogateReversePrefix, escapedKey), tag)
+}
+
+// LookupKey returns the sorted tags of the cache key, the ones whose purge
+// removes it.
+func (s *baseStorage) LookupKey(cacheKey string) []string {
+	return s.memberList(indexSetKey(surrogateReversePrefix, url.QueryEscape(cacheKey)))
+}
+
+// LookupURI returns the tags of each cache key stored for the request path.
+func (s *baseStorage) LookupURI(uri string) map[string][]string {
+	indexed, legacy := s.tagKeys(uri)
+	lookup := make(map[string][]string, len(indexed)+len(legacy))
+	for _, escapedKey := range append(indexed, legacy...) {
+		if cacheKey, err := url.QueryUnescape(escapedKey); err == nil && cacheKey != "" {
+			lookup[cacheKey] = s.LookupKey(cacheKey)
+		}
+	}
+
+	return lookup
+}
+
+// Stats returns the number of entries and their approximate size per tag.
+func (s *baseStorage) Stats() map[string]TagStats {
+	sizes := map[string]int64{}
+	stats := map[string]TagStats{}
+	for tag, keys := range s.List() {
+		tagStats := TagStats{}
+		for _, key := range strings.Split(keys, souinStorageSeparator) {
+			if key == "" {
+				continue
+			}
+			tagStats.Entries++
+			if _, found := sizes[key]; !found {
+				sizes[key], _ = s.size(key)
+			}
+			tagStats.Bytes += sizes[key]
+		}
+		stats[tag] = tagStats
+	}
+
+	return stats
+}
@@ -0,0 +1,126 @@
+package providers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+)
+
+// sigV4Signer signs the AWS API requests with the Signature Version 4.
+type sigV4Signer struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	region          string
+	service         string
+	now             func() time.Time
+}
+
+func newSigV4Signer(accessKeyID, secretAccessKey, sessionToken, region, service string) *sigV4Signer {
+	return &sigV4Signer{
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		sessionToken:    sessionToken,
+		region:          region,
+		service:         service,
+		now:             time.Now,
+	}
+}
+
+func sigV4HMAC(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
+
+func sigV4Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+func sigV4Query(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := []string{}
+	for _, key := range keys {
+		vs := append([]string{}, values[key]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			pairs = append(pairs, strings.ReplaceAll(url.QueryEscape(key), "+", "%20")+"="+strings.ReplaceAll(url.QueryEscape(v), "+", "%20"))
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+// Sign sets the X-Amz-Date and Authorization headers of the request with
+// the given body. The Content-Type header is signed when present.
+func (s *sigV4Signer) Sign(rq *http.Request, body []byte) {
+	now := s.now().UTC()
+	amzDate := now.Format(sigV4TimeFormat)
+	rq.Header.Set("X-Amz-Date", amzDate)
+	if s.sessionToken != "" {
+		rq.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	host := rq.Host
+	if host == "" {
+		host = rq.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for _, name := range []string{"Content-Type", "X-Amz-Date", "X-Amz-Security-Token"} {
+		if value := rq.Header.Get(name); value != "" {
+			headers[strings.ToLower(name)] = strings.TrimSpace(value)
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := rq.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		rq.Method,
+		path,
+		sigV4Query(rq.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		sigV4Hash(body),
+	}, "\n")
+
+	scope := now.Format("20060102") + "/" + s.region + "/" + s.service + "/aws4_request"
+	stringToSign := strings.Join([]string{sigV4Algorithm, amzDate, scope, sigV4Hash([]byte(canonicalRequest))}, "\n")
+
+	key := sigV4HMAC([]byte("AWS4"+s.secretAccessKey), now.Format("20060102"))
+	key = sigV4HMAC(key, s.region)
+	key = sigV4HMAC(key, s.service)
+	key = sigV4HMAC(key, "aws4_request")
+
+	rq.Header.Set("Authorization", sigV4Algorithm+" Credential="+s.accessKeyID+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+hex.EncodeToString(sigV4HMAC(key, stringToSign)))
+}
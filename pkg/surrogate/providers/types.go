@@ -2,6 +2,7 @@ package providers
 
 import (
 	"net/http"
+	"time"
 )
 
 // SurrogateInterface represents the interface to implement to be part
@@ -12,13 +13,25 @@ type SurrogateInterface interface {
 	GetSurrogateControl(http.Header) (string, string)
 	GetSurrogateControlName() string
 	getSurrogateKey(http.Header) string
+	AppendSurrogateKeys(http.Header, ...string)
 	Purge(http.Header) (cacheKeys []string, surrogateKeys []string)
+	PurgeMatched([]string) (cacheKeys []string, surrogateKeys []string)
+	PurgeTags([]string) []string
+	MatchTags(http.Header) ([]string, error)
 	Invalidate(method string, h http.Header)
+	InvalidateKeys([]string)
 	purgeTag(string) []string
 	Store(*http.Response, string, string) error
-	storeTag(string, string)
+	BodyKeysLimit() int
+	storeTag(string, string, time.Time)
 	ParseHeaders(string) []string
 	List() map[string]string
+	LookupKey(string) []string
+	LookupURI(string) map[string][]string
+	Stats() map[string]TagStats
+	PurgeReport() PurgeReport
 	candidateStore(string) bool
+	Close()
+	Reset() error
 	Destruct() error
 }
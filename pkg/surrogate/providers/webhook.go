@@ -0,0 +1,195 @@
+package providers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/darkweak/souin/configurationtypes"
+)
+
+const (
+	varnishXkey          = "xkey"
+	varnishXkeySoftPurge = "xkey-softpurge"
+)
+
+// webhookPayload is the data given to the webhook templates and the default
+// JSON body of the webhook requests.
+type webhookPayload struct {
+	Tags []string `json:"tags"`
+	URIs []string `json:"uris"`
+	Keys []string `json:"keys"`
+}
+
+var webhookFuncs = template.FuncMap{
+	"join": strings.Join,
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+
+		return string(b), err
+	},
+}
+
+// WebhookSurrogateStorage is the layer for Surrogate-key support storage. It
+// sends a templated request to each configured endpoint on every purge. The
+// varnish preset sends a BAN request with the purged tags in the xkey header
+// instead of a JSON body.
+type WebhookSurrogateStorage struct {
+	*baseStorage
+	endpoints []*template.Template
+	body      *template.Template
+	method    string
+	varnish   bool
+	xkey      string
+}
+
+func generateWebhookInstance(config configurationtypes.AbstractConfigurationInterface, defaultStorerName string, varnish bool) *WebhookSurrogateStorage {
+	cdn := config.GetDefaultCache().GetCDN()
+	w := &WebhookSurrogateStorage{
+		baseStorage: &baseStorage{indexPaths: true},
+		method:      cdn.Webhook.Method,
+		varnish:     varnish,
+		xkey:        varnishXkey,
+	}
+	if w.method == "" {
+		w.method = http.MethodPost
+		if varnish {
+			w.method = "BAN"
+		}
+	}
+	if cdn.Strategy == "soft" {
+		w.xkey = varnishXkeySoftPurge
+	}
+
+	w.init(config, defaultStorerName)
+	w.parent = w
+
+	endpoints := cdn.Webhook.Endpoints
+	if len(endpoints) == 0 && cdn.BaseURL != "" {
+		endpoints = []string{cdn.BaseURL}
+	}
+	for _, endpoint := range endpoints {
+		tpl, err := template.New("endpoint").Funcs(webhookFuncs).Parse(endpoint)
+		if err != nil {
+			w.logger.Errorf("Impossible to parse the webhook endpoint %s: %v", endpoint, err)
+
+			continue
+		}
+		w.endpoints = append(w.endpoints, tpl)
+	}
+	if cdn.Webhook.Body != "" {
+		tpl, err := template.New("body").Funcs(webhookFuncs).Parse(cdn.Webhook.Body)
+		if err != nil {
+			w.logger.Errorf("Impossible to parse the webhook body: %v", err)
+		} else {
+			w.body = tpl
+		}
+	}
+
+	provider := "webhook"
+	if varnish {
+		provider = "varnish"
+	}
+	headers := cdn.Webhook.Headers
+	w.dispatcher = newPurgeDispatcher(provider, cdn.Purge, w.Storage, w.logger, func(rq *http.Request, _ []byte) {
+		for name, value := range headers {
+			rq.Header.Set(name, value)
+		}
+	})
+
+	return w
+}
+
+func (w *WebhookSurrogateStorage) getHeaderSeparator() string {
+	if w.varnish {
+		return " "
+	}
+
+	return ", "
+}
+
+func (w *WebhookSurrogateStorage) getOrderedSurrogateKeyHeadersCandidate() []string {
+	if w.varnish {
+		return []string{
+			varnishXkey,
+			surrogateKey,
+		}
+	}
+
+	return w.baseStorage.getOrderedSurrogateKeyHeadersCandidate()
+}
+
+// Store stores the response tags located in the first non empty supported
+// header. The varnish preset exposes them in the xkey header.
+func (w *WebhookSurrogateStorage) Store(response *http.Response, cacheKey, uri string) error {
+	if !w.varnish {
+		return w.baseStorage.Store(response, cacheKey, uri)
+	}
+
+	defer func() {
+		response.Header.Del(surrogateKey)
+		response.Header.Del(surrogateControl)
+	}()
+	e := w.baseStorage.Store(response, cacheKey, uri)
+	response.Header.Set(varnishXkey, strings.Join(NonEmpty(w.ParseHeaders(w.getSurrogateKey(response.Header))), w.getHeaderSeparator()))
+
+	return e
+}
+
+// PurgeMatched purges the urls associated to the matched tags
+func (w *WebhookSurrogateStorage) PurgeMatched(matched []string) (cacheKeys []string, surrogateKeys []string) {
+	keys, headers := w.baseStorage.PurgeMatched(matched)
+	if tags := NonEmpty(headers); len(tags) > 0 {
+		w.send(webhookPayload{Tags: tags, URIs: w.purgePaths(tags), Keys: keys})
+	}
+
+	return keys, headers
+}
+
+// InvalidateKeys notifies the endpoints about the cache keys invalidated
+// without tags. The varnish preset bans by tag only, so it ignores them.
+func (w *WebhookSurrogateStorage) InvalidateKeys(keys []string) {
+	if w.varnish || len(keys) == 0 {
+		return
+	}
+
+	w.send(webhookPayload{Tags: []string{}, URIs: []string{}, Keys: keys})
+}
+
+func (w *WebhookSurrogateStorage) send(payload webhookPayload) {
+	if payload.Keys == nil {
+		payload.Keys = []string{}
+	}
+
+	header := http.Header{}
+	var body []byte
+	switch {
+	case w.body != nil:
+		buf := new(bytes.Buffer)
+		if err := w.body.Execute(buf, payload); err != nil {
+			w.logger.Errorf("Impossible to render the webhook body: %v", err)
+
+			return
+		}
+		body = buf.Bytes()
+	case !w.varnish:
+		body, _ = json.Marshal(payload)
+		header.Set("Content-Type", "application/json")
+	}
+	if w.varnish {
+		header.Set(w.xkey, strings.Join(payload.Tags, " "))
+	}
+
+	for _, endpoint := range w.endpoints {
+		buf := new(bytes.Buffer)
+		if err := endpoint.Execute(buf, payload); err != nil {
+			w.logger.Errorf("Impossible to render the webhook endpoint: %v", err)
+
+			continue
+		}
+
+		w.dispatcher.Enqueue(w.method, buf.String(), header.Clone(), body)
+	}
+}
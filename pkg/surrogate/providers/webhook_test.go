@@ -0,0 +1,152 @@
+package providers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/darkweak/souin/pkg/storage"
+	"github.com/darkweak/souin/pkg/storage/types"
+	"github.com/darkweak/storages/core"
+)
+
+type webhookRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   string
+}
+
+func webhookStandIn(t *testing.T) (*httptest.Server, func() []webhookRequest) {
+	t.Helper()
+
+	var mu sync.Mutex
+	received := []webhookRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		received = append(received, webhookRequest{method: r.Method, path: r.URL.RequestURI(), header: r.Header, body: string(body)})
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []webhookRequest {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return append([]webhookRequest{}, received...)
+	}
+}
+
+func waitForResults(t *testing.T, p SurrogateInterface, results int) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(p.PurgeReport().Results) < results && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if report := p.PurgeReport().Results; len(report) != results {
+		t.Fatalf("Expected %d purge results, %+v given.", results, report)
+	}
+}
+
+func TestWebhookSurrogateStorage(t *testing.T) {
+	server, received := webhookStandIn(t)
+	memoryStorer, _ := storage.Factory(mockConfiguration(cdnConfigurationSouin))
+	core.RegisterStorage(memoryStorer)
+
+	p := SurrogateFactory(mockConfiguration(func() string {
+		return fmt.Sprintf(`
+default_cache:
+  cdn:
+    provider: webhook
+    webhook:
+      endpoints:
+        - %[1]s/default
+        - %[1]s/tags?list={{ join .Tags "+" }}
+      headers:
+        Authorization: Bearer secret
+`, server.URL)
+	}), types.DefaultStorageName)
+
+	_ = p.Store(&http.Response{Header: http.Header{surrogateKey: {"first, second"}}}, "GET-http-example.com-/path", "/path")
+	_, _ = p.Purge(http.Header{surrogateKey: {"first"}})
+	waitForResults(t, p, 2)
+
+	for _, rq := range received() {
+		if rq.method != http.MethodPost || rq.header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Unexpected webhook request %+v.", rq)
+		}
+		if rq.path != "/default" && rq.path != "/tags?list=first" {
+			t.Errorf("Unexpected webhook endpoint %s.", rq.path)
+		}
+		if rq.body != `{"tags":["first"],"uris":["/path"],"keys":["GET-http-example.com-/path"]}` {
+			t.Errorf("Unexpected webhook body %s.", rq.body)
+		}
+	}
+
+	p.InvalidateKeys([]string{"GET-http-example.com-/other"})
+	waitForResults(t, p, 4)
+	if last := received()[3]; last.body != `{"tags":[],"uris":[],"keys":["GET-http-example.com-/other"]}` {
+		t.Errorf("Unexpected webhook invalidation body %s.", last.body)
+	}
+}
+
+func TestWebhookSurrogateStorage_Body(t *testing.T) {
+	server, received := webhookStandIn(t)
+	memoryStorer, _ := storage.Factory(mockConfiguration(cdnConfigurationSouin))
+	core.RegisterStorage(memoryStorer)
+
+	p := SurrogateFactory(mockConfiguration(func() string {
+		return fmt.Sprintf(`
+default_cache:
+  cdn:
+    provider: webhook
+    base_url: %s/hook
+    webhook:
+      method: PUT
+      body: '{"purge":{{ json .Tags }}}'
+`, server.URL)
+	}), types.DefaultStorageName)
+
+	_, _ = p.Purge(http.Header{surrogateKey: {"first, second"}})
+	waitForResults(t, p, 1)
+
+	if rq := received()[0]; rq.method != http.MethodPut || rq.path != "/hook" || rq.body != `{"purge":["first","second"]}` {
+		t.Errorf("Unexpected webhook request %+v.", rq)
+	}
+}
+
+func TestVarnishSurrogateStorage(t *testing.T) {
+	server, received := webhookStandIn(t)
+	memoryStorer, _ := storage.Factory(mockConfiguration(cdnConfigurationSouin))
+	core.RegisterStorage(memoryStorer)
+
+	p := SurrogateFactory(mockConfiguration(func() string {
+		return fmt.Sprintf(`
+default_cache:
+  cdn:
+    provider: varnish
+    strategy: hard
+    base_url: %s
+`, server.URL)
+	}), types.DefaultStorageName)
+
+	res := &http.Response{Header: http.Header{surrogateKey: {"first second"}}}
+	_ = p.Store(res, "key", "/path")
+	if res.Header.Get(varnishXkey) != "first second" || res.Header.Get(surrogateKey) != "" {
+		t.Errorf("The response must expose the tags in the xkey header, %v given.", res.Header)
+	}
+
+	p.InvalidateKeys([]string{"key"})
+	_, _ = p.Purge(http.Header{http.CanonicalHeaderKey(varnishXkey): {"first second"}})
+	waitForResults(t, p, 1)
+
+	if rq := received()[0]; rq.method != "BAN" || rq.header.Get(varnishXkey) != "first second" || rq.body != "" {
+		t.Errorf("Unexpected varnish request %+v.", rq)
+	}
+}
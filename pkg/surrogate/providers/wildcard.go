@@ -0,0 +1,135 @@
+package providers
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The purged tags containing a * are patterns resolved against the stored
+// tags, the * matching any sequence of characters: product:* purges every
+// tag starting with product: and tenant:*:users every users tag of a tenant.
+// The \* escape matches a literal *.
+const (
+	tagWildcard        = "*"
+	escapedTagWildcard = `\*`
+
+	defaultMaxWildcardTags = 1000
+)
+
+// splitWildcard splits the pattern on its unescaped *, the escaped ones
+// being unescaped in the returned parts.
+func splitWildcard(pattern string) []string {
+	parts := []string{}
+	current := strings.Builder{}
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], escapedTagWildcard):
+			current.WriteString(tagWildcard)
+			i++
+		case pattern[i] == tagWildcard[0]:
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(pattern[i])
+		}
+	}
+
+	return append(parts, current.String())
+}
+
+// EscapeWildcards escapes the * of the tags so they are purged literally
+// when matched again, as the resolved tags sent to the other instances.
+func EscapeWildcards(tags []string) []string {
+	escaped := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		escaped = append(escaped, strings.ReplaceAll(tag, tagWildcard, escapedTagWildcard))
+	}
+
+	return escaped
+}
+
+func wildcardRegexp(parts []string) *regexp.Regexp {
+	quoted := make([]string, len(parts))
+	for i, part := range parts {
+		quoted[i] = regexp.QuoteMeta(part)
+	}
+
+	return regexp.MustCompile("^" + strings.Join(quoted, ".*") + "$")
+}
+
+// storedTags returns the sorted tags of the index starting with the prefix,
+// read from their group or from every group when the prefix is shorter. The
+// legacy tags are matched once migrated by the compaction.
+func (s *baseStorage) storedTags(prefix string) []string {
+	groups := []string{tagGroup(prefix)}
+	if len(prefix) < tagGroupLength {
+		groups = groups[:0]
+		for _, group := range s.memberList(indexSetKey(surrogateTagsPrefix, "")) {
+			if strings.HasPrefix(group, prefix) {
+				groups = append(groups, group)
+			}
+		}
+	}
+
+	tags := []string{}
+	for _, group := range groups {
+		for _, tag := range s.memberList(indexSetKey(surrogateTagsPrefix, group)) {
+			if strings.HasPrefix(tag, prefix) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	sort.Strings(tags)
+
+	return tags
+}
+
+// resolveWildcards replaces the patterns by the stored tags they match. It
+// fails when a pattern matches more tags than allowed.
+func (s *baseStorage) resolveWildcards(tags []string) ([]string, error) {
+	resolved := []string{}
+	for _, tag := range tags {
+		parts := splitWildcard(tag)
+		if len(parts) == 1 {
+			resolved = append(resolved, parts[0])
+
+			continue
+		}
+
+		matcher := wildcardRegexp(parts)
+		matched := []string{}
+		for _, candidate := range s.storedTags(parts[0]) {
+			if matcher.MatchString(candidate) {
+				matched = append(matched, candidate)
+			}
+		}
+		if len(matched) > s.maxWildcardTags {
+			return nil, fmt.Errorf("the pattern %s matches %d tags, more than the %d allowed", tag, len(matched), s.maxWildcardTags)
+		}
+
+		s.logger.Debugf("The pattern %s matches the tags %v", tag, matched)
+		resolved = append(resolved, matched...)
+	}
+
+	return resolved, nil
+}
+
+// matchTags returns the tags purged with the given ones, the patterns
+// resolved and the dependents included.
+func (s *baseStorage) matchTags(tags []string) ([]string, error) {
+	resolved, err := s.resolveWildcards(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.expandTags(resolved)
+}
+
+// MatchTags returns the tags a purge with the header would remove, without
+// purging them.
+func (s *baseStorage) MatchTags(header http.Header) ([]string, error) {
+	return s.matchTags(s.ParseHeaders(s.parent.getSurrogateKey(header)))
+}
@@ -47,5 +47,9 @@ func (s *SouinCaddyMiddleware) Cleanup() error {
 		_, _ = up.Delete(v)
 	}
 
+	if s.SouinBaseHandler != nil {
+		return s.SouinBaseHandler.Cleanup()
+	}
+
 	return nil
 }
@@ -62,6 +62,28 @@ type DefaultCache struct {
 	DisableCoalescing bool `json:"disable_coalescing"`
 	// MappingEvictionInterval interval between eviction
 	MappingEvictionInterval configurationtypes.Duration `json:"mapping_eviction_interval"`
+	// Coalescing wait and stale fallback.
+	Coalescing configurationtypes.Coalescing `json:"coalescing"`
+	// Background revalidation worker pool.
+	Revalidation configurationtypes.Revalidation `json:"revalidation"`
+	// Probabilistic early expiration.
+	EarlyExpiration configurationtypes.EarlyExpiration `json:"early_expiration"`
+	// Concurrent upstream requests limits.
+	OriginLimit configurationtypes.OriginLimit `json:"origin_limit"`
+	// Forced refresh authorization.
+	ForcedRefresh configurationtypes.ForcedRefresh `json:"forced_refresh"`
+	// Invalidation bus between the instances.
+	InvalidationBus configurationtypes.InvalidationBus `json:"invalidation_bus"`
+	// Cache rules evaluated on each request and response.
+	Rules []configurationtypes.Rule `json:"rules"`
+	// Heuristic freshness of the responses without explicit expiration.
+	HeuristicFreshness configurationtypes.HeuristicFreshness `json:"heuristic_freshness"`
+	// Generated ETag of the responses without validator.
+	GeneratedETag configurationtypes.GeneratedETag `json:"generated_etag"`
+	// Targeted cache control fields (RFC 9213) to honor.
+	TargetedCacheControl []string `json:"targeted_cache_control"`
+	// Serve the stale response when the backend times out.
+	ServeStaleOnTimeout bool `json:"serve_stale_on_timeout"`
 }
 
 // GetAllowedHTTPVerbs returns the allowed verbs to cache
@@ -189,6 +211,61 @@ func (d *DefaultCache) IsCoalescingDisable() bool {
 	return d.DisableCoalescing
 }
 
+// GetCoalescing returns the coalescing configuration
+func (d *DefaultCache) GetCoalescing() configurationtypes.Coalescing {
+	return d.Coalescing
+}
+
+// GetRevalidation returns the background revalidation configuration
+func (d *DefaultCache) GetRevalidation() configurationtypes.Revalidation {
+	return d.Revalidation
+}
+
+// GetEarlyExpiration returns the early expiration configuration
+func (d *DefaultCache) GetEarlyExpiration() configurationtypes.EarlyExpiration {
+	return d.EarlyExpiration
+}
+
+// GetOriginLimit returns the upstream concurrency limits
+func (d *DefaultCache) GetOriginLimit() configurationtypes.OriginLimit {
+	return d.OriginLimit
+}
+
+// GetForcedRefresh returns the forced refresh configuration
+func (d *DefaultCache) GetForcedRefresh() configurationtypes.ForcedRefresh {
+	return d.ForcedRefresh
+}
+
+// GetInvalidationBus returns the invalidation bus configuration
+func (d *DefaultCache) GetInvalidationBus() configurationtypes.InvalidationBus {
+	return d.InvalidationBus
+}
+
+// GetRules returns the cache rules
+func (d *DefaultCache) GetRules() []configurationtypes.Rule {
+	return d.Rules
+}
+
+// GetHeuristicFreshness returns the heuristic freshness configuration
+func (d *DefaultCache) GetHeuristicFreshness() configurationtypes.HeuristicFreshness {
+	return d.HeuristicFreshness
+}
+
+// GetGeneratedETag returns the generated ETag configuration
+func (d *DefaultCache) GetGeneratedETag() configurationtypes.GeneratedETag {
+	return d.GeneratedETag
+}
+
+// GetTargetedCacheControl returns the targeted cache control fields to honor
+func (d *DefaultCache) GetTargetedCacheControl() []string {
+	return d.TargetedCacheControl
+}
+
+// GetServeStaleOnTimeout returns if the stale response is served on backend timeout
+func (d *DefaultCache) GetServeStaleOnTimeout() bool {
+	return d.ServeStaleOnTimeout
+}
+
 // Configuration holder
 type Configuration struct {
 	// Default cache to fallback on when none are redefined.
@@ -782,6 +859,215 @@ func parseConfiguration(cfg *Configuration, h *caddyfile.Dispenser, isGlobal boo
 				}
 			case "disable_surrogate_key":
 				cfg.SurrogateKeyDisabled = true
+			case "coalescing":
+				coalescing := configurationtypes.Coalescing{}
+				for nesting := h.Nesting(); h.NextBlock(nesting); {
+					directive := h.Val()
+					switch directive {
+					case "wait":
+						wait, err := time.ParseDuration(h.RemainingArgs()[0])
+						if err != nil {
+							return h.Errf("unsupported coalescing wait: %v", err)
+						}
+						coalescing.Wait.Duration = wait
+					case "serve_stale":
+						coalescing.ServeStale = true
+					default:
+						return h.Errf("unsupported coalescing directive: %s", directive)
+					}
+				}
+				cfg.DefaultCache.Coalescing = coalescing
+			case "revalidation":
+				revalidation := configurationtypes.Revalidation{}
+				for nesting := h.Nesting(); h.NextBlock(nesting); {
+					directive := h.Val()
+					switch directive {
+					case "workers":
+						workers, err := strconv.Atoi(h.RemainingArgs()[0])
+						if err != nil {
+							return h.Errf("unsupported revalidation workers: %v", err)
+						}
+						revalidation.Workers = workers
+					case "queue_size":
+						size, err := strconv.Atoi(h.RemainingArgs()[0])
+						if err != nil {
+							return h.Errf("unsupported revalidation queue_size: %v", err)
+						}
+						revalidation.QueueSize = size
+					case "window":
+						window, err := time.ParseDuration(h.RemainingArgs()[0])
+						if err != nil {
+							return h.Errf("unsupported revalidation window: %v", err)
+						}
+						revalidation.Window.Duration = window
+					default:
+						return h.Errf("unsupported revalidation directive: %s", directive)
+					}
+				}
+				cfg.DefaultCache.Revalidation = revalidation
+			case "early_expiration":
+				earlyExpiration := configurationtypes.EarlyExpiration{Enable: true}
+				for nesting := h.Nesting(); h.NextBlock(nesting); {
+					directive := h.Val()
+					switch directive {
+					case "beta":
+						beta, err := strconv.ParseFloat(h.RemainingArgs()[0], 64)
+						if err != nil {
+							return h.Errf("unsupported early_expiration beta: %v", err)
+						}
+						earlyExpiration.Beta = beta
+					default:
+						return h.Errf("unsupported early_expiration directive: %s", directive)
+					}
+				}
+				cfg.DefaultCache.EarlyExpiration = earlyExpiration
+			case "origin_limit":
+				originLimit := configurationtypes.OriginLimit{}
+				for nesting := h.Nesting(); h.NextBlock(nesting); {
+					directive := h.Val()
+					switch directive {
+					case "max_concurrent", "max_concurrent_per_host", "queue_size":
+						value, err := strconv.Atoi(h.RemainingArgs()[0])
+						if err != nil {
+							return h.Errf("unsupported origin_limit %s: %v", directive, err)
+						}
+						switch directive {
+						case "max_concurrent":
+							originLimit.MaxConcurrent = value
+						case "max_concurrent_per_host":
+							originLimit.MaxConcurrentPerHost = value
+						default:
+							originLimit.QueueSize = value
+						}
+					case "queue_timeout", "retry_after":
+						value, err := time.ParseDuration(h.RemainingArgs()[0])
+						if err != nil {
+							return h.Errf("unsupported origin_limit %s: %v", directive, err)
+						}
+						if directive == "queue_timeout" {
+							originLimit.QueueTimeout.Duration = value
+						} else {
+							originLimit.RetryAfter.Duration = value
+						}
+					default:
+						return h.Errf("unsupported origin_limit directive: %s", directive)
+					}
+				}
+				cfg.DefaultCache.OriginLimit = originLimit
+			case "forced_refresh":
+				forcedRefresh := configurationtypes.ForcedRefresh{}
+				for nesting := h.Nesting(); h.NextBlock(nesting); {
+					directive := h.Val()
+					switch directive {
+					case "secret":
+						forcedRefresh.Secret = h.RemainingArgs()[0]
+					case "allowed_ips":
+						forcedRefresh.AllowedIPs = append(forcedRefresh.AllowedIPs, h.RemainingArgs()...)
+					case "purge_variants":
+						forcedRefresh.PurgeVariants = true
+					default:
+						return h.Errf("unsupported forced_refresh directive: %s", directive)
+					}
+				}
+				cfg.DefaultCache.ForcedRefresh = forcedRefresh
+			case "invalidation_bus":
+				invalidationBus := configurationtypes.InvalidationBus{}
+				for nesting := h.Nesting(); h.NextBlock(nesting); {
+					directive := h.Val()
+					switch directive {
+					case "transport":
+						invalidationBus.Transport = h.RemainingArgs()[0]
+					case "url":
+						invalidationBus.URL = h.RemainingArgs()[0]
+					case "channel":
+						invalidationBus.Channel = h.RemainingArgs()[0]
+					case "peers":
+						invalidationBus.Peers = append(invalidationBus.Peers, h.RemainingArgs()...)
+					case "secret":
+						invalidationBus.Secret = h.RemainingArgs()[0]
+					default:
+						return h.Errf("unsupported invalidation_bus directive: %s", directive)
+					}
+				}
+				cfg.DefaultCache.InvalidationBus = invalidationBus
+			case "rules":
+				for nesting := h.Nesting(); h.NextBlock(nesting); {
+					rule := configurationtypes.Rule{Name: h.Val()}
+					for nesting := h.Nesting(); h.NextBlock(nesting); {
+						directive := h.Val()
+						switch directive {
+						case "condition":
+							rule.Condition = strings.Join(h.RemainingArgs(), " ")
+						case "bypass":
+							rule.Bypass = true
+						case "ttl", "stale":
+							value, err := time.ParseDuration(h.RemainingArgs()[0])
+							if err != nil {
+								return h.Errf("unsupported rules (%s) %s: %v", rule.Name, directive, err)
+							}
+							if directive == "ttl" {
+								rule.TTL.Duration = value
+							} else {
+								rule.Stale.Duration = value
+							}
+						case "key_components":
+							rule.KeyComponents = append(rule.KeyComponents, h.RemainingArgs()...)
+						case "surrogate_keys":
+							rule.SurrogateKeys = append(rule.SurrogateKeys, h.RemainingArgs()...)
+						case "storers":
+							rule.Storers = append(rule.Storers, h.RemainingArgs()...)
+						case "strip_headers":
+							rule.StripHeaders = append(rule.StripHeaders, h.RemainingArgs()...)
+						default:
+							return h.Errf("unsupported rules (%s) directive: %s", rule.Name, directive)
+						}
+					}
+					cfg.DefaultCache.Rules = append(cfg.DefaultCache.Rules, rule)
+				}
+			case "heuristic_freshness":
+				heuristicFreshness := configurationtypes.HeuristicFreshness{Enable: true}
+				for nesting := h.Nesting(); h.NextBlock(nesting); {
+					directive := h.Val()
+					switch directive {
+					case "fraction":
+						fraction, err := strconv.ParseFloat(h.RemainingArgs()[0], 64)
+						if err != nil {
+							return h.Errf("unsupported heuristic_freshness fraction: %v", err)
+						}
+						heuristicFreshness.Fraction = fraction
+					case "min", "max":
+						value, err := time.ParseDuration(h.RemainingArgs()[0])
+						if err != nil {
+							return h.Errf("unsupported heuristic_freshness %s: %v", directive, err)
+						}
+						if directive == "min" {
+							heuristicFreshness.Min.Duration = value
+						} else {
+							heuristicFreshness.Max.Duration = value
+						}
+					default:
+						return h.Errf("unsupported heuristic_freshness directive: %s", directive)
+					}
+				}
+				cfg.DefaultCache.HeuristicFreshness = heuristicFreshness
+			case "generated_etag":
+				generatedETag := configurationtypes.GeneratedETag{Enable: true}
+				for nesting := h.Nesting(); h.NextBlock(nesting); {
+					directive := h.Val()
+					switch directive {
+					case "weak":
+						generatedETag.Weak = true
+					case "exclude_headers":
+						generatedETag.ExcludeHeaders = append(generatedETag.ExcludeHeaders, h.RemainingArgs()...)
+					default:
+						return h.Errf("unsupported generated_etag directive: %s", directive)
+					}
+				}
+				cfg.DefaultCache.GeneratedETag = generatedETag
+			case "targeted_cache_control":
+				cfg.DefaultCache.TargetedCacheControl = append(cfg.DefaultCache.TargetedCacheControl, h.RemainingArgs()...)
+			case "serve_stale_on_timeout":
+				cfg.DefaultCache.ServeStaleOnTimeout = true
 			default:
 				return h.Errf("unsupported root directive: %s", rootOption)
 			}
@@ -243,6 +243,39 @@ func (s *SouinCaddyMiddleware) FromApp(app *SouinApp) error {
 	if dc.Regex.Exclude == "" {
 		s.Configuration.DefaultCache.Regex.Exclude = appDc.Regex.Exclude
 	}
+	if dc.Coalescing == (configurationtypes.Coalescing{}) {
+		s.Configuration.DefaultCache.Coalescing = appDc.Coalescing
+	}
+	if dc.Revalidation == (configurationtypes.Revalidation{}) {
+		s.Configuration.DefaultCache.Revalidation = appDc.Revalidation
+	}
+	if dc.EarlyExpiration == (configurationtypes.EarlyExpiration{}) {
+		s.Configuration.DefaultCache.EarlyExpiration = appDc.EarlyExpiration
+	}
+	if dc.OriginLimit == (configurationtypes.OriginLimit{}) {
+		s.Configuration.DefaultCache.OriginLimit = appDc.OriginLimit
+	}
+	if dc.ForcedRefresh.Secret == "" && len(dc.ForcedRefresh.AllowedIPs) == 0 {
+		s.Configuration.DefaultCache.ForcedRefresh = appDc.ForcedRefresh
+	}
+	if dc.InvalidationBus.Transport == "" {
+		s.Configuration.DefaultCache.InvalidationBus = appDc.InvalidationBus
+	}
+	if len(dc.Rules) == 0 {
+		s.Configuration.DefaultCache.Rules = appDc.Rules
+	}
+	if dc.HeuristicFreshness == (configurationtypes.HeuristicFreshness{}) {
+		s.Configuration.DefaultCache.HeuristicFreshness = appDc.HeuristicFreshness
+	}
+	if !dc.GeneratedETag.Enable {
+		s.Configuration.DefaultCache.GeneratedETag = appDc.GeneratedETag
+	}
+	if len(dc.TargetedCacheControl) == 0 {
+		s.Configuration.DefaultCache.TargetedCacheControl = appDc.TargetedCacheControl
+	}
+	if !dc.ServeStaleOnTimeout {
+		s.Configuration.DefaultCache.ServeStaleOnTimeout = appDc.ServeStaleOnTimeout
+	}
 
 	return nil
 }
@@ -282,6 +315,8 @@ func (s *SouinCaddyMiddleware) Provision(ctx caddy.Context) error {
 	bh := middleware.NewHTTPCacheHandler(&s.Configuration)
 	surrogates, ok := up.LoadOrStore(surrogate_key, bh.SurrogateKeyStorer)
 	if ok {
+		// The shared surrogate storage replaces the one of the handler.
+		bh.SurrogateKeyStorer.Close()
 		bh.SurrogateKeyStorer = surrogates.(surrogates_providers.SurrogateInterface)
 	}
 
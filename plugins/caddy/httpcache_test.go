@@ -9,6 +9,7 @@ import (
 	"mime/multipart"
 	"net/http"
 	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -22,6 +23,10 @@ func compareHit(t *testing.T, headers http.Header, key, details string, ttl int,
 
 	suffix := strings.Join(suffixes, "; ")
 	tpl := "Souin; hit; ttl=%d; key=%s; detail=%s%s"
+	if strings.ContainsAny(key, ` "?=[]`) {
+		// The keys which aren't structured field tokens are serialized as strings.
+		key = strconv.Quote(key)
+	}
 
 	if headers.Get("Cache-Status") != fmt.Sprintf(tpl, ttl, key, details, suffix) &&
 		headers.Get("Cache-Status") != fmt.Sprintf(tpl, ttl-1, key, details, suffix) {
@@ -141,7 +146,7 @@ func TestQueryStringSort(t *testing.T) {
 	}`, "caddyfile")
 
 	resp1, _ := tester.AssertGetResponse(`http://localhost:9080/query-string-sort?b=2&a=1`, 200, "Hello, query string sort!")
-	if resp1.Header.Get("Cache-Status") != "Souin; fwd=uri-miss; stored; key=GET-http-localhost:9080-/query-string-sort?a=1&b=2" {
+	if resp1.Header.Get("Cache-Status") != `Souin; fwd=uri-miss; stored; key="GET-http-localhost:9080-/query-string-sort?a=1&b=2"` {
 		t.Errorf("unexpected Cache-Status header %v", resp1.Header.Get("Cache-Status"))
 	}
 
@@ -149,7 +154,7 @@ func TestQueryStringSort(t *testing.T) {
 	compareHit(t, resp2.Header, "GET-http-localhost:9080-/query-string-sort?a=1&b=2", "DEFAULT", 119)
 
 	resp3, _ := tester.AssertGetResponse(`http://localhost:9080/query-string-sort?word=beta&word=alpha`, 200, "Hello, query string sort!")
-	if resp3.Header.Get("Cache-Status") != "Souin; fwd=uri-miss; stored; key=GET-http-localhost:9080-/query-string-sort?word=alpha&word=beta" {
+	if resp3.Header.Get("Cache-Status") != `Souin; fwd=uri-miss; stored; key="GET-http-localhost:9080-/query-string-sort?word=alpha&word=beta"` {
 		t.Errorf("unexpected Cache-Status header %v", resp3.Header.Get("Cache-Status"))
 	}
 }
@@ -221,7 +226,7 @@ func TestMaxStale(t *testing.T) {
 
 	time.Sleep(3 * time.Second)
 	resp4, _ := tester.AssertResponse(reqMaxStale, 200, "Hello, max-stale!")
-	if resp4.Header.Get("Cache-Status") != "Souin; fwd=uri-miss; stored; key=GET-http-localhost:9080-/cache-max-stale" {
+	if resp4.Header.Get("Cache-Status") != "Souin; fwd=stale; stored; key=GET-http-localhost:9080-/cache-max-stale" {
 		t.Errorf("unexpected Cache-Status header %v", resp4.Header.Get("Cache-Status"))
 	}
 }
@@ -508,21 +513,21 @@ func TestAuthenticatedRoute(t *testing.T) {
 	}
 
 	respAuthBypassAlice1, _ := tester.AssertResponse(getRequestFor("/auth-bypass", "Alice"), 200, "Hello, auth bypass Bearer Alice!")
-	if respAuthBypassAlice1.Header.Get("Cache-Status") != "Souin; fwd=uri-miss; stored; key=GET-http-localhost:9080-/auth-bypass-Bearer Alice-text/plain" {
+	if respAuthBypassAlice1.Header.Get("Cache-Status") != `Souin; fwd=uri-miss; stored; key="GET-http-localhost:9080-/auth-bypass-Bearer Alice-text/plain"` {
 		t.Errorf("unexpected Cache-Status header %v", respAuthBypassAlice1.Header.Get("Cache-Status"))
 	}
 	respAuthBypassAlice2, _ := tester.AssertResponse(getRequestFor("/auth-bypass", "Alice"), 200, "Hello, auth bypass Bearer Alice!")
 	compareHit(t, respAuthBypassAlice2.Header, "GET-http-localhost:9080-/auth-bypass-Bearer Alice-text/plain", "DEFAULT", 4)
 
 	respAuthBypassBob1, _ := tester.AssertResponse(getRequestFor("/auth-bypass", "Bob"), 200, "Hello, auth bypass Bearer Bob!")
-	if respAuthBypassBob1.Header.Get("Cache-Status") != "Souin; fwd=uri-miss; stored; key=GET-http-localhost:9080-/auth-bypass-Bearer Bob-text/plain" {
+	if respAuthBypassBob1.Header.Get("Cache-Status") != `Souin; fwd=uri-miss; stored; key="GET-http-localhost:9080-/auth-bypass-Bearer Bob-text/plain"` {
 		t.Errorf("unexpected Cache-Status header %v", respAuthBypassBob1.Header.Get("Cache-Status"))
 	}
 	respAuthBypassBob2, _ := tester.AssertResponse(getRequestFor("/auth-bypass", "Bob"), 200, "Hello, auth bypass Bearer Bob!")
 	compareHit(t, respAuthBypassBob2.Header, "GET-http-localhost:9080-/auth-bypass-Bearer Bob-text/plain", "DEFAULT", 4)
 
 	respAuthVaryBypassAlice1, _ := tester.AssertResponse(getRequestFor("/auth-bypass-vary", "Alice"), 200, "Hello, auth vary bypass Bearer Alice!")
-	if respAuthVaryBypassAlice1.Header.Get("Cache-Status") != "Souin; fwd=uri-miss; stored; key=GET-http-localhost:9080-/auth-bypass-vary-Bearer Alice-text/plain" {
+	if respAuthVaryBypassAlice1.Header.Get("Cache-Status") != `Souin; fwd=uri-miss; stored; key="GET-http-localhost:9080-/auth-bypass-vary-Bearer Alice-text/plain"` {
 		t.Errorf("unexpected Cache-Status header %v", respAuthVaryBypassAlice1.Header.Get("Cache-Status"))
 	}
 	respAuthVaryBypassAlice2, _ := tester.AssertResponse(getRequestFor("/auth-bypass-vary", "Alice"), 200, "Hello, auth vary bypass Bearer Alice!")
@@ -605,7 +610,7 @@ func TestMustRevalidate(t *testing.T) {
 	if resp4.Header.Get("Cache-Control") != "must-revalidate" {
 		t.Errorf("unexpected resp4 Cache-Control header %v", resp4.Header.Get("Cache-Control"))
 	}
-	if resp4.Header.Get("Cache-Status") != "Souin; fwd=uri-miss; stored; key=GET-http-localhost:9080-/cache-default" {
+	if resp4.Header.Get("Cache-Status") != "Souin; fwd=stale; stored; key=GET-http-localhost:9080-/cache-default" {
 		t.Errorf("unexpected resp4 Cache-Status header %v", resp4.Header.Get("Cache-Status"))
 	}
 	if resp4.Header.Get("Age") != "" {
@@ -617,7 +622,7 @@ func TestMustRevalidate(t *testing.T) {
 	staleReq.Header = http.Header{"Cache-Control": []string{"max-stale=3"}}
 	resp5, _ := tester.AssertResponse(staleReq, http.StatusGatewayTimeout, "")
 
-	if resp5.Header.Get("Cache-Status") != "Souin; fwd=request; fwd-status=500; key=GET-http-localhost:9080-/cache-default; detail=REQUEST-REVALIDATION" {
+	if resp5.Header.Get("Cache-Status") != "Souin; fwd=stale; fwd-status=500; key=GET-http-localhost:9080-/cache-default; detail=REQUEST-REVALIDATION" {
 		t.Errorf("unexpected resp5 Cache-Status header %v", resp4.Header.Get("Cache-Status"))
 	}
 	if resp5.Header.Get("Age") != "" {
@@ -1220,7 +1225,7 @@ func TestComplexQuery(t *testing.T) {
 			t.Errorf("unexpected Age header %v", resp1.Header.Get("Age"))
 		}
 
-		if resp1.Header.Get("Cache-Status") != "Souin; fwd=uri-miss; stored; key=GET-http-localhost:9080-/complex-query?"+query {
+		if resp1.Header.Get("Cache-Status") != `Souin; fwd=uri-miss; stored; key="GET-http-localhost:9080-/complex-query?`+query+`"` {
 			t.Errorf("unexpected first Cache-Status header %v", resp1.Header.Get("Cache-Status"))
 		}
 
@@ -1669,3 +1674,80 @@ func TestCoalescing(t *testing.T) {
 		}
 	}
 }
+
+func TestDefaultCacheOptions(t *testing.T) {
+	tester := caddytest.NewTester(t)
+	tester.InitServer(`
+	{
+		admin localhost:2999
+		http_port     9080
+		https_port    9443
+		cache {
+			coalescing {
+				wait 500ms
+				serve_stale
+			}
+			early_expiration {
+				beta 1
+			}
+			generated_etag {
+				weak
+			}
+			heuristic_freshness {
+				fraction 0.1
+				min 10s
+				max 24h
+			}
+			origin_limit {
+				max_concurrent 100
+				queue_size 10
+				queue_timeout 1s
+			}
+			revalidation {
+				workers 2
+				queue_size 10
+				window 1s
+			}
+			rules {
+				bypass_private {
+					condition "request.path.startsWith('/options-private')"
+					bypass
+				}
+			}
+			serve_stale_on_timeout
+			targeted_cache_control CDN-Cache-Control
+		}
+	}
+	localhost:9080 {
+		route /options-targeted {
+			cache
+			header Cache-Control "no-store"
+			header CDN-Cache-Control "max-age=60"
+			respond "Hello, targeted!"
+		}
+		route /options-private {
+			cache
+			header Cache-Control "max-age=60"
+			respond "Hello, private!"
+		}
+	}`, "caddyfile")
+
+	resp1, _ := tester.AssertGetResponse(`http://localhost:9080/options-targeted`, 200, "Hello, targeted!")
+	if resp1.Header.Get("Cache-Status") != "Souin; fwd=uri-miss; stored; key=GET-http-localhost:9080-/options-targeted" {
+		t.Errorf("unexpected Cache-Status header %v", resp1.Header.Get("Cache-Status"))
+	}
+	if resp1.Header.Get("CDN-Cache-Control") != "" {
+		t.Errorf("unexpected CDN-Cache-Control header %v", resp1.Header.Get("CDN-Cache-Control"))
+	}
+	if !strings.HasPrefix(resp1.Header.Get("ETag"), `W/"`) {
+		t.Errorf("unexpected ETag header %v", resp1.Header.Get("ETag"))
+	}
+
+	resp2, _ := tester.AssertGetResponse(`http://localhost:9080/options-targeted`, 200, "Hello, targeted!")
+	compareHit(t, resp2.Header, "GET-http-localhost:9080-/options-targeted", "DEFAULT", 59)
+
+	resp3, _ := tester.AssertGetResponse(`http://localhost:9080/options-private`, 200, "Hello, private!")
+	if !strings.Contains(resp3.Header.Get("Cache-Status"), "fwd=bypass") {
+		t.Errorf("unexpected Cache-Status header %v", resp3.Header.Get("Cache-Status"))
+	}
+}
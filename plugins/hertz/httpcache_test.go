@@ -175,7 +175,7 @@ func TestAllowedMethodsRequest(t *testing.T) {
 	validateStoreAllowedMethods(t, engine, http.MethodPost)
 
 	rr1 := ut.PerformRequest(engine, http.MethodPut, "http://domain.com/allowed_methods", nil)
-	if rr1.Result().Header.Get("Cache-Status") != "Souin; fwd=bypass; detail=UNSUPPORTED-METHOD" {
+	if rr1.Result().Header.Get("Cache-Status") != "Souin; fwd=method; detail=UNSUPPORTED-METHOD" {
 		t.Errorf("The Cache-Status header response mismatched the expectations, %s given.", rr1.Result().Header.Get("Cache-Status"))
 	}
 	if rr1.Result().Header.Get("Age") != "" {
@@ -186,7 +186,7 @@ func TestAllowedMethodsRequest(t *testing.T) {
 	}
 
 	rr2 := ut.PerformRequest(engine, http.MethodPut, "http://domain.com/allowed_methods", nil)
-	if rr2.Result().Header.Get("Cache-Status") != "Souin; fwd=bypass; detail=UNSUPPORTED-METHOD" {
+	if rr2.Result().Header.Get("Cache-Status") != "Souin; fwd=method; detail=UNSUPPORTED-METHOD" {
 		t.Errorf("The Cache-Status header response mismatched the expectations, %s given.", rr2.Result().Header.Get("Cache-Status"))
 	}
 	if rr2.Result().Header.Get("Age") != "" {
@@ -60,8 +60,7 @@ func SouinResponseHandler(rw http.ResponseWriter, rs *http.Response, baseRq *htt
 	responseCc, _ := cacheobject.ParseResponseCacheControl(customWriter.Header().Get("Cache-Control"))
 
 	currentMatchedURL := s.SouinBaseHandler.DefaultMatchedUrl
-	if regexpURL := s.SouinBaseHandler.RegexpUrls.FindString(rq.Host + rq.URL.Path); regexpURL != "" {
-		u := s.SouinBaseHandler.Configuration.GetUrls()[regexpURL]
+	if u := context.GetMatchedURL(rq.Context()); u != nil {
 		if u.TTL.Duration != 0 {
 			currentMatchedURL.TTL = u.TTL
 		}